@@ -0,0 +1,387 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/reconcile"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/verify"
+)
+
+// version is set at build time via -ldflags "-X main.version=...".
+var version = ""
+
+func runVersion(fs *flag.FlagSet, args []string) error {
+	_ = fs.Parse(args)
+	fmt.Println("oci-watcher", watcherVersion())
+	return nil
+}
+
+// watcherVersion returns the version of the watcher, (devel) if unknown.
+func watcherVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// runReconcile asks the running watcher to reconcile via its HTTP API, or reconciles in-process with --once.
+func runReconcile(fs *flag.FlagSet, args []string) error {
+	var wf watcherFlags
+	wf.register(fs)
+	once := fs.Bool("once", false, "Reconcile once in-process instead of triggering the running watcher")
+	api := fs.String("api", "http://localhost:8080", "HTTP API of the running watcher (see -listen of watch)")
+	secret := fs.String("webhookSecret", "", "Shared secret of the running watcher")
+	_ = fs.Parse(args)
+
+	if !*once {
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*api, "/")+"/reconcile", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+*secret)
+		resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("POST %s: %s", req.URL, resp.Status)
+		}
+		fmt.Println("Reconcile triggered")
+		return nil
+	}
+
+	wf.login()
+	unlock, err := wf.lockDeployDir()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	w, err := wf.newWatcher()
+	if err != nil {
+		return err
+	}
+	flushTraces, err := wf.setupTracing(context.Background(), w.deviceID)
+	if err != nil {
+		return err
+	}
+	defer flushTraces()
+	return w.reconciler.Reconcile(context.Background())
+}
+
+// runRedeploy asks the running watcher to redeploy a component via its HTTP API, or redeploys it in-process with
+// --once.
+func runRedeploy(fs *flag.FlagSet, args []string) error {
+	var wf watcherFlags
+	wf.register(fs)
+	once := fs.Bool("once", false, "Redeploy in-process instead of via the running watcher")
+	api := fs.String("api", "http://localhost:8080", "HTTP API of the running watcher (see -listen of watch)")
+	secret := fs.String("webhookSecret", "", "Shared secret of the running watcher")
+	host := fs.String("host", "", "Host of the component if the watcher manages several (see -hosts)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one component, see 'oci-watcher redeploy -h'")
+	}
+	component := fs.Arg(0)
+
+	if !*once {
+		if *secret == "" {
+			return fmt.Errorf("-webhookSecret is required, the watcher only serves /redeploy with it")
+		}
+		query := url.Values{"component": {component}}
+		if *host != "" {
+			query.Set("host", *host)
+		}
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*api, "/")+"/redeploy?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+*secret)
+		resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("POST %s: %s: %s", req.URL, resp.Status, strings.TrimSpace(string(body)))
+		}
+		fmt.Println("Redeploy triggered")
+		return nil
+	}
+
+	wf.login()
+	unlock, err := wf.lockDeployDir()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	w, err := wf.newWatcher()
+	if err != nil {
+		return err
+	}
+	flushTraces, err := wf.setupTracing(context.Background(), w.deviceID)
+	if err != nil {
+		return err
+	}
+	defer flushTraces()
+	if err := w.reconciler.Redeploy(*host, component); err != nil {
+		return err
+	}
+	return w.reconciler.Reconcile(context.Background())
+}
+
+// runStatus lists the local deployments with their package digest and runtime state.
+func runStatus(fs *flag.FlagSet, args []string) error {
+	deployDir := fs.String("deployDir", "./deploy", "Directory to deploy")
+	daemon := registerDaemonFlags(fs)
+	systemdUser := fs.Bool("systemdUser", os.Geteuid() > 0, "Query the units of systemd, quadlet and wasm deployments in the user's service manager (defaults to true if run unprivileged)")
+	nomad := registerNomadFlags(fs)
+	kubernetes := registerKubernetesFlags(fs)
+	hostsFile := fs.String("hosts", "", "YAML file with further hosts managed by the watcher, whose deployments are listed as well")
+	localOverrides := fs.String("composeOverrides", "", "Directory with the compose override files of the device, see watch")
+	logs := fs.Bool("logs", false, "Print the logs captured when deployments last failed after the deployments")
+	capabilities := fs.Bool("capabilities", false, "Print the capabilities of the hosts, e.g. architecture, memory and runtime versions, before the deployments")
+	_ = fs.Parse(args)
+
+	var hosts []reconcile.HostConfig
+	if *hostsFile != "" {
+		var err error
+		if hosts, err = reconcile.LoadHosts(*hostsFile); err != nil {
+			return fmt.Errorf("invalid -hosts: %w", err)
+		}
+	}
+	local := &reconcile.Reconciler{
+		Backend:   &backend.Compose{Daemon: *daemon, LocalOverrides: *localOverrides},
+		Backends:  backendConfig{daemon: *daemon, systemdUser: *systemdUser, nomad: nomad, kubernetes: kubernetes}.profileBackends(),
+		DeployDir: *deployDir,
+	}
+	fleet := reconcile.NewFleet(local, hosts)
+	ctx := context.Background()
+	if *capabilities {
+		for _, r := range fleet.Reconcilers {
+			host := r.Host
+			if host == "" {
+				host = "local"
+			}
+			fmt.Printf("%s: %s\n", host, r.Capabilities(ctx))
+		}
+		fmt.Println()
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(hosts) > 0 {
+		fmt.Fprint(tw, "HOST\t")
+	}
+	fmt.Fprintln(tw, "COMPONENT\tVERSION\tPACKAGE\tDISK\tSTATUS")
+	for _, r := range fleet.Reconcilers {
+		if _, err := os.Stat(r.DeployDir); err != nil {
+			if r.Host != "" && os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, dir := range r.DeploymentDirs() {
+			name := r.ComponentName(dir)
+			version, pkg := "-", "-"
+			if metadata, err := reconcile.ReadMetadata(dir); err == nil && metadata != nil {
+				pkg = metadata.Digest
+				if metadata.Version != "" {
+					version = metadata.Version
+				}
+			}
+			status, err := r.DeploymentBackend(dir).Status(ctx, dir)
+			if err != nil {
+				status = "unknown"
+			}
+			if code, _ := reconcile.LastFailure(dir); code != "" {
+				status += backend.Status(", failed (" + code + ")")
+			}
+			if required := reconcile.Incompatible(dir); required != "" {
+				status += backend.Status(", requires watcher " + required)
+			}
+			if reason := reconcile.Unschedulable(dir); reason != "" {
+				status += backend.Status(", unschedulable (" + reason + ")")
+			}
+			switch pending := reconcile.Pending(dir); pending {
+			case "":
+			case "purge":
+				status += ", purge pending"
+			default:
+				status += ", update pending"
+			}
+			hookResults, _ := reconcile.HookResults(dir)
+			for _, phase := range []string{reconcile.HookPreStop, reconcile.HookPostStart} {
+				if hookResults[phase].Error != "" {
+					status += backend.Status(", " + phase + " hook failed")
+				}
+			}
+			if len(hosts) > 0 {
+				host := r.Host
+				if host == "" {
+					host = "local"
+				}
+				fmt.Fprintf(tw, "%s\t", host)
+			}
+			disk := "-"
+			if size, err := fsutil.DirSize(dir); err == nil {
+				disk = fsutil.FormatBytes(size)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, version, pkg, disk, status)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if *logs {
+		for _, r := range fleet.Reconcilers {
+			for _, dir := range r.DeploymentDirs() {
+				if captured := reconcile.FailureLogs(dir); captured != "" {
+					fmt.Printf("\n==> %s <==\n%s", r.ComponentName(dir), captured)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runVerify runs the extraction, digest and signature checks of the watcher on a package, so publishers can validate
+// it before rolling it out. Package and key are local files or blob locations as used in the desired state.
+func runVerify(fs *flag.FlagSet, args []string) error {
+	pkgLocation := fs.String("package", "", "Package to verify: a local file or a packageLocation (e.g. ghcr.io/org/repo@sha256:...)")
+	keyLocation := fs.String("key", "", "Armored public key: a local file or a keyLocation")
+	expectedDigest := fs.String("digest", "", "Expected digest of a local package, e.g. sha256:... or sha512:...")
+	verifyConfig := fs.String("verifyConfig", "", "YAML file with the signature verification policy (defaults to requiring GPG signatures)")
+	component := fs.String("component", "", "Component name used to select the policy rule")
+	targetPlatform := fs.String("platform", platform.Local().String(), "Platform used to select from multi-arch packages, e.g. linux/arm64")
+	var ageIdentities, gpgKeys stringList
+	registerDecryptionFlags(fs, &ageIdentities, &gpgKeys)
+	registerDockerConfigFlag(fs)
+	_ = fs.Parse(args)
+	if *pkgLocation == "" && fs.NArg() == 1 {
+		*pkgLocation = fs.Arg(0)
+	}
+	if *pkgLocation == "" || fs.NArg() > 1 {
+		return fmt.Errorf("expected exactly one package, see 'oci-watcher verify -h'")
+	}
+
+	verifier := verify.DefaultChain()
+	var err error
+	if *verifyConfig != "" {
+		if verifier, err = verify.LoadConfig(*verifyConfig); err != nil {
+			return fmt.Errorf("invalid -verifyConfig: %w", err)
+		}
+	}
+	ctx := context.Background()
+	regClient := &registry.Client{RC: regclient.New(regclient.WithDockerCerts(), regclient.WithDockerCreds())}
+
+	var pubKey []byte
+	if *keyLocation != "" {
+		r, err := openLocation(ctx, regClient, *keyLocation)
+		if err != nil {
+			return err
+		}
+		pubKey, err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	var pkg io.ReadCloser
+	var dgst digest.Digest
+	if fsutil.FileExists(*pkgLocation) {
+		// the digest of blobs from the registry is verified while downloading, local files are checked upfront
+		f, err := os.Open(*pkgLocation)
+		if err != nil {
+			return err
+		}
+		algorithm := digest.Canonical
+		if *expectedDigest != "" {
+			expected, err := digest.Parse(strings.TrimSpace(*expectedDigest))
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("invalid -digest: %w", err)
+			}
+			algorithm = expected.Algorithm()
+		}
+		if dgst, err = algorithm.FromReader(f); err != nil {
+			f.Close()
+			return err
+		}
+		if *expectedDigest != "" && dgst.String() != strings.TrimSpace(*expectedDigest) {
+			f.Close()
+			return fmt.Errorf("digest mismatch: expected %s, got %s", *expectedDigest, dgst)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		pkg = f
+	} else {
+		p, err := platform.Parse(*targetPlatform)
+		if err != nil {
+			return fmt.Errorf("invalid -platform: %w", err)
+		}
+		if *pkgLocation, err = regClient.ResolvePackage(ctx, *pkgLocation, p); err != nil {
+			return err
+		}
+		if _, dgst, err = registry.ParseBlobLocation(*pkgLocation); err != nil {
+			return err
+		}
+		if pkg, err = regClient.Download(ctx, *pkgLocation); err != nil {
+			return err
+		}
+	}
+	defer pkg.Close()
+
+	tempDir, err := os.MkdirTemp("", "oci-watcher-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	plaintext, err := decryptionKeys(ageIdentities, gpgKeys).Decrypt(ctx, pkg)
+	if err != nil {
+		return err
+	}
+	defer plaintext.Close()
+	app, err := reconcile.UnpackAndVerify(ctx, verifier, *component, plaintext, pubKey, tempDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: OK\n  app:    %s\n  digest: %s\n", *pkgLocation, filepath.Base(app), dgst)
+	return nil
+}
+
+// openLocation opens a local file, or downloads the blob location from the registry.
+func openLocation(ctx context.Context, c *registry.Client, location string) (io.ReadCloser, error) {
+	if fsutil.FileExists(location) {
+		return os.Open(location)
+	}
+	return c.Download(ctx, location)
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"text/tabwriter"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/reconcile"
+)
+
+// registerDebugHandlers serves net/http/pprof below /debug/pprof/ and the state of the watcher on /debug/state,
+// both requiring the secret. They are never served without one.
+func registerDebugHandlers(mux *http.ServeMux, secret string, fleet *reconcile.Fleet) {
+	protect := func(h http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret == "" || !authorized(r, secret) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		})
+	}
+	mux.Handle("/debug/pprof/", protect(pprof.Index))
+	mux.Handle("/debug/pprof/cmdline", protect(pprof.Cmdline))
+	mux.Handle("/debug/pprof/profile", protect(pprof.Profile))
+	mux.Handle("/debug/pprof/symbol", protect(pprof.Symbol))
+	mux.Handle("/debug/pprof/trace", protect(pprof.Trace))
+	mux.Handle("/debug/state", protect(debugState{fleet: fleet, started: time.Now()}.ServeHTTP))
+}
+
+// debugState dumps the phase of the reconcilers, the state of the deployments and the goroutine stacks, to debug hangs
+// on remote devices.
+type debugState struct {
+	fleet   *reconcile.Fleet
+	started time.Time
+}
+
+func (d debugState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "oci-watcher %s, pid %d, up %s, %d goroutines\n\n", watcherVersion(), os.Getpid(), time.Since(d.started).Round(time.Second), runtime.NumGoroutine())
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tCOMPONENT\tPHASE\tSINCE")
+	for _, rec := range d.fleet.Reconcilers {
+		phase := rec.Progress.Current()
+		since := "-"
+		if !phase.Since.IsZero() {
+			since = phase.Since.Format(time.RFC3339) + " (" + time.Since(phase.Since).Round(time.Second).String() + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cmp.Or(rec.Host, "local"), cmp.Or(phase.Component, "-"), cmp.Or(phase.Step, "not started"), since)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "HOST\tCOMPONENT\tPACKAGE\tAPPLIED\tPENDING")
+	for _, rec := range d.fleet.Reconcilers {
+		for _, dir := range rec.DeploymentDirs() {
+			pkg, applied := "-", "-"
+			if metadata, err := reconcile.ReadMetadata(dir); err != nil {
+				pkg = "invalid: " + err.Error()
+			} else if metadata != nil {
+				pkg = metadata.Digest
+				if !metadata.Applied.IsZero() {
+					applied = metadata.Applied.Format(time.RFC3339)
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", cmp.Or(rec.Host, "local"), rec.ComponentName(dir), pkg, applied, cmp.Or(reconcile.Pending(dir), "-"))
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprint(w, "\nGOROUTINES\n\n")
+	_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
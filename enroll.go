@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/identity"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/workload"
+)
+
+// credentialHelperName is the name under which the binary acts as Docker credential helper, serving tokens obtained
+// with the device identity. regclient (and Docker) invoke it via a symlink.
+const credentialHelperName = "docker-credential-oci-watcher"
+
+// tokenRefresh is how long a token is used before asking the credential helper for a fresh one, well before tokens
+// of identity services typically expire. It is an untyped constant in nanoseconds, as regclient's duration type is
+// internal.
+const tokenRefresh = 5 * 60 * 1e9
+
+// runEnroll creates the device key and identity.
+func runEnroll(fs *flag.FlagSet, args []string) error {
+	tokenURL := fs.String("tokenURL", "", "Token endpoint of the identity service, e.g. https://id.example.com/oauth2/token")
+	deviceID := fs.String("deviceID", "", "Device identifier, used as client ID (defaults to the hostname)")
+	clientID := fs.String("clientID", "", "Client ID registered with the identity service (defaults to the device ID)")
+	scope := fs.String("scope", "", "Scope requested for registry tokens")
+	tpmHandle := fs.String("tpmHandle", "", "Persistent TPM handle of the device key, e.g. 0x81010002; the key is created with tpm2-tools if missing")
+	keyFile := fs.String("keyFile", "", "PEM file holding the device key, for devices without TPM (created if missing)")
+	registryUser := fs.String("registryUser", "oauth2accesstoken", "Username presented to registries along with the token ('<token>' presents it as bearer token)")
+	var registries stringList
+	fs.Var(&registries, "registry", "Registry the token is presented to (repeatable, defaults to all)")
+	output := fs.String("o", identity.DefaultPath(), "Identity file written")
+	_ = fs.Parse(args)
+
+	if *tokenURL == "" {
+		return fmt.Errorf("-tokenURL is required")
+	}
+	if (*tpmHandle == "") == (*keyFile == "") {
+		return fmt.Errorf("either -tpmHandle or -keyFile is required")
+	}
+	if *deviceID == "" {
+		var err error
+		if *deviceID, err = os.Hostname(); err != nil {
+			return fmt.Errorf("failed to determine device ID: %w", err)
+		}
+	}
+	id := &identity.Identity{
+		DeviceID:     *deviceID,
+		TokenURL:     *tokenURL,
+		ClientID:     *clientID,
+		Scope:        *scope,
+		Registries:   registries,
+		RegistryUser: *registryUser,
+		Key:          identity.Key{TPMHandle: *tpmHandle, File: *keyFile},
+	}
+	ctx := context.Background()
+	if err := id.Key.Create(ctx); err != nil {
+		return fmt.Errorf("failed to create device key: %w", err)
+	}
+	pub, err := id.Key.PublicKey(ctx)
+	if err != nil {
+		return err
+	}
+	if err := id.Save(*output); err != nil {
+		return err
+	}
+	pemKey, err := identity.PublicKeyPEM(pub)
+	if err != nil {
+		return err
+	}
+	jwk, err := identity.MarshalJWK(pub)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Device identity written to", *output)
+	fmt.Println("Register the device key with the identity service:")
+	fmt.Printf("%s\nJWK: %s\n", pemKey, jwk)
+	return nil
+}
+
+// credentialHelperPath returns the path of the credential helper symlink in the config directory, creating it if
+// needed.
+func credentialHelperPath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(identity.DefaultPath())
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	helper := filepath.Join(dir, credentialHelperName)
+	if target, err := os.Readlink(helper); err == nil && target == exe {
+		return helper, nil
+	}
+	_ = os.Remove(helper)
+	if err := os.Symlink(exe, helper); err != nil {
+		return "", fmt.Errorf("failed to create credential helper: %w", err)
+	}
+	return helper, nil
+}
+
+// runCredentialHelper implements the Docker credential helper protocol for the workload and device identities. Only
+// get is supported, as tokens are never stored.
+func runCredentialHelper(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s get|store|erase|list", credentialHelperName)
+	}
+	switch args[0] {
+	case "store", "erase":
+		_, _ = bufio.NewReader(os.Stdin).ReadString(0)
+		return nil
+	case "list":
+		fmt.Println("{}")
+		return nil
+	case "get":
+	default:
+		return fmt.Errorf("unsupported action %q", args[0])
+	}
+
+	host, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	host = strings.TrimSpace(host)
+	username, secret, err := helperCredentials(context.Background(), host)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(map[string]string{"ServerURL": host, "Username": username, "Secret": secret})
+}
+
+// helperCredentials obtains the credentials for the registry from the identities announced by the watcher in the
+// environment, preferring the workload identity.
+func helperCredentials(ctx context.Context, host string) (string, string, error) {
+	if path := os.Getenv("OCI_WATCHER_WORKLOAD_IDENTITY"); path != "" {
+		cfg, err := workload.Load(path)
+		if err != nil {
+			return "", "", err
+		}
+		if r := cfg.Lookup(host); r != nil {
+			return r.Credentials(ctx)
+		}
+	}
+	path := os.Getenv("OCI_WATCHER_IDENTITY")
+	if path == "" {
+		path = identity.DefaultPath()
+	}
+	id, err := identity.Load(path)
+	if err != nil {
+		return "", "", err
+	}
+	if !id.Covers(host) {
+		return "", "", fmt.Errorf("credentials not found in native keychain")
+	}
+	token, _, err := id.Token(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return id.RegistryUser, token, nil
+}
@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+)
+
+// errorReport describes a panic or a component failing repeatedly.
+type errorReport struct {
+	Type      string    `json:"type"` // panic or failure
+	DeviceID  string    `json:"deviceId"`
+	Version   string    `json:"version"`
+	Host      string    `json:"host,omitempty"`
+	Component string    `json:"component,omitempty"`
+	Package   string    `json:"package,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	Error     string    `json:"error"`
+	Failures  int       `json:"failures,omitempty"`
+	Stack     string    `json:"stack,omitempty"`
+	Logs      string    `json:"logs,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// errorReporter reports panics and components failing repeatedly, since edge devices are rarely monitored
+// interactively. URLs with a key (https://<key>@<host>/<project>) are Sentry DSNs, other URLs receive the
+// errorReport as JSON.
+type errorReporter struct {
+	url       string
+	sentryKey string
+	deviceID  string
+	// threshold is the number of consecutive failures of a component after which it is reported.
+	threshold int
+	client    *http.Client
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func newErrorReporter(rawURL, deviceID string, threshold int) (*errorReporter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("unsupported URL %q", rawURL)
+	}
+	rep := &errorReporter{url: rawURL, deviceID: deviceID, threshold: max(threshold, 1), client: &http.Client{Timeout: 10 * time.Second}, failures: make(map[string]int)}
+	if u.User != nil {
+		project := strings.Trim(u.Path, "/")
+		if project == "" {
+			return nil, fmt.Errorf("invalid Sentry DSN %q, the project is missing", rawURL)
+		}
+		rep.sentryKey = u.User.Username()
+		rep.url = fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project)
+	}
+	return rep, nil
+}
+
+// observe reports components once they failed threshold times in a row.
+func (rep *errorReporter) observe(e notify.Event) {
+	key := e.Host + "/" + e.Component
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	switch e.Type {
+	case notify.EventFailed:
+		rep.failures[key]++
+		if n := rep.failures[key]; n == rep.threshold {
+			go rep.send(context.Background(), errorReport{Type: "failure", Host: e.Host, Component: e.Component, Package: e.Package, Code: e.Code, Error: e.Error, Failures: n, Logs: e.Logs})
+		}
+	case notify.EventApplied, notify.EventPurged:
+		delete(rep.failures, key)
+	}
+}
+
+// recoverPanic reports a panic of the calling goroutine before passing it on. It must be deferred. A nil reporter
+// reports nothing.
+func (rep *errorReporter) recoverPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if rep != nil {
+		rep.send(context.Background(), errorReport{Type: "panic", Error: fmt.Sprint(v), Stack: string(debug.Stack())})
+	}
+	panic(v)
+}
+
+// spawn runs f in a new goroutine whose panics are reported.
+func (rep *errorReporter) spawn(f func()) {
+	go func() {
+		defer rep.recoverPanic()
+		f()
+	}()
+}
+
+// handler reports panics of h. The HTTP server recovers them afterwards, as usual.
+func (rep *errorReporter) handler(h http.Handler) http.Handler {
+	if rep == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer rep.recoverPanic()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (rep *errorReporter) send(ctx context.Context, r errorReport) {
+	r.DeviceID, r.Version, r.Time = rep.deviceID, watcherVersion(), time.Now().UTC()
+	var body any = r
+	if rep.sentryKey != "" {
+		body = rep.sentryEvent(r)
+	}
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rep.url, bytes.NewReader(b))
+	if err != nil {
+		log.Printf("WARN: Failed to report %s: %s", r.Type, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rep.sentryKey != "" {
+		req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=oci-watcher/%s, sentry_key=%s", r.Version, rep.sentryKey))
+	}
+	resp, err := rep.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("WARN: Failed to report %s to %s: %s", r.Type, rep.url, err)
+	}
+}
+
+// sentryEvent converts the report to an event of Sentry's store API.
+func (rep *errorReporter) sentryEvent(r errorReport) map[string]any {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	tags := map[string]string{"device_id": r.DeviceID, "type": r.Type}
+	level, message := "fatal", "panic: "+r.Error
+	if r.Type == "failure" {
+		level, message = "error", fmt.Sprintf("%s failed %d times: %s", r.Component, r.Failures, r.Error)
+		tags["host"], tags["component"], tags["code"] = cmp.Or(r.Host, "local"), r.Component, r.Code
+	}
+	return map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   r.Time.Format(time.RFC3339),
+		"platform":    "go",
+		"level":       level,
+		"logger":      "oci-watcher",
+		"release":     "oci-watcher@" + r.Version,
+		"server_name": r.DeviceID,
+		"message":     message,
+		"tags":        tags,
+		"extra":       map[string]any{"package": r.Package, "stack": r.Stack, "logs": r.Logs},
+	}
+}
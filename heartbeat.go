@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/reconcile"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+)
+
+// heartbeatMediaType is the media and artifact type of heartbeats pushed to OCI repositories.
+const heartbeatMediaType = "application/vnd.margo.watcher.heartbeat.v1+json"
+
+// heartbeat shows the fleet backend that the device is alive, and what it runs.
+type heartbeat struct {
+	DeviceID string           `json:"deviceId"`
+	Version  string           `json:"version"`
+	Time     time.Time        `json:"time"`
+	Uptime   string           `json:"uptime"`
+	Runtime  heartbeatRuntime `json:"runtime"`
+	// Capabilities of the hosts, the local one first.
+	Capabilities []reconcile.Capabilities `json:"capabilities"`
+	Components   []heartbeatComponent     `json:"components"`
+}
+
+type heartbeatRuntime struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	GoVersion string `json:"goVersion"`
+	Hostname  string `json:"hostname,omitempty"`
+}
+
+type heartbeatComponent struct {
+	Host    string     `json:"host,omitempty"`
+	Name    string     `json:"name"`
+	Package string     `json:"package,omitempty"`
+	Digest  string     `json:"digest,omitempty"`
+	Version string     `json:"version,omitempty"`
+	Applied *time.Time `json:"applied,omitempty"`
+	// Pending is the package of a deferred update, or purge.
+	Pending string `json:"pending,omitempty"`
+	// Failure is the error code of the last reconciliation if it failed.
+	Failure string `json:"failure,omitempty"`
+	// Unschedulable is why the desired state of the component cannot run on the device.
+	Unschedulable string `json:"unschedulable,omitempty"`
+	// Timings are the durations of the phases of the installation, see reconcile.Timings.
+	Timings reconcile.Timings `json:"timings,omitempty"`
+}
+
+// started is when the watcher started.
+var started = time.Now()
+
+// collectHeartbeat lists the capabilities and deployments of the fleet.
+func collectHeartbeat(ctx context.Context, deviceID string, fleet *reconcile.Fleet) heartbeat {
+	hostname, _ := os.Hostname()
+	hb := heartbeat{
+		DeviceID:   deviceID,
+		Version:    watcherVersion(),
+		Time:       time.Now().UTC(),
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		Runtime:    heartbeatRuntime{OS: runtime.GOOS, Arch: runtime.GOARCH, GoVersion: runtime.Version(), Hostname: hostname},
+		Components: []heartbeatComponent{},
+	}
+	for _, r := range fleet.Reconcilers {
+		hb.Capabilities = append(hb.Capabilities, r.Capabilities(ctx))
+		for _, dir := range r.DeploymentDirs() {
+			c := heartbeatComponent{Host: r.Host, Name: r.ComponentName(dir), Pending: reconcile.Pending(dir)}
+			if metadata, err := reconcile.ReadMetadata(dir); err == nil && metadata != nil {
+				c.Package, c.Digest, c.Version, c.Timings = metadata.Package, metadata.Digest, metadata.Version, metadata.Timings
+				if !metadata.Applied.IsZero() {
+					c.Applied = &metadata.Applied
+				}
+			}
+			c.Failure, _ = reconcile.LastFailure(dir)
+			c.Unschedulable = reconcile.Unschedulable(dir)
+			hb.Components = append(hb.Components, c)
+		}
+	}
+	return hb
+}
+
+// heartbeatPublisher publishes heartbeats to an HTTP endpoint and/or an OCI repository, where they are tagged with
+// the device ID.
+type heartbeatPublisher struct {
+	deviceID string
+	fleet    *reconcile.Fleet
+	url      string
+	repo     *ref.Ref
+	registry *registry.Client
+	client   *http.Client
+}
+
+// invalidTagChars are replaced in device IDs to derive a tag.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func newHeartbeatPublisher(w *watcher, url, repo string) (*heartbeatPublisher, error) {
+	p := &heartbeatPublisher{deviceID: w.deviceID, fleet: w.reconciler, url: url, registry: w.registry, client: &http.Client{Timeout: 30 * time.Second}}
+	if repo != "" {
+		r, err := ref.New(repo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository %q: %w", repo, err)
+		}
+		tag := invalidTagChars.ReplaceAllString(w.deviceID, "_")
+		if len(tag) > 128 {
+			tag = tag[:128]
+		}
+		r = r.SetTag(tag)
+		p.repo = &r
+	}
+	return p, nil
+}
+
+// run publishes a heartbeat in every interval until ctx is done.
+func (p *heartbeatPublisher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hb := collectHeartbeat(ctx, p.deviceID, p.fleet)
+		b, _ := json.Marshal(hb)
+		if p.url != "" {
+			if err := p.post(ctx, b); err != nil {
+				log.Printf("WARN: Failed to publish heartbeat to %s: %s", p.url, err)
+			}
+		}
+		if p.repo != nil {
+			if err := p.push(ctx, b); err != nil {
+				log.Printf("WARN: Failed to push heartbeat to %s: %s", p.repo.CommonName(), err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *heartbeatPublisher) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// push replaces the heartbeat artifact of the device in the repository.
+func (p *heartbeatPublisher) push(ctx context.Context, body []byte) error {
+	layer, err := registry.PushBytes(ctx, p.registry.RC, *p.repo, heartbeatMediaType, body)
+	if err != nil {
+		return err
+	}
+	_, err = registry.PushArtifact(ctx, p.registry.RC, *p.repo, heartbeatMediaType, []descriptor.Descriptor{layer}, nil)
+	return err
+}
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build !unix
+
+package fsutil
+
+import "errors"
+
+// FreeSpace is not supported on this platform.
+func FreeSpace(dir string) (free uint64, device uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build unix
+
+package fsutil
+
+import "syscall"
+
+// FreeSpace returns the space available to unprivileged users on the filesystem of dir, and the device identifying
+// the filesystem.
+func FreeSpace(dir string) (free uint64, device uint64, err error) {
+	dir = existingParent(dir)
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, 0, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(fs.Bavail) * uint64(fs.Bsize), uint64(st.Dev), nil
+}
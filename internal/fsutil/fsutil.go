@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package fsutil provides filesystem helpers shared by the watcher packages.
+package fsutil
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UnpackTgz extracts a gzip-compressed tarball into destDir, optionally skipping hidden entries. src is read to the
+// end, so streamed downloads verify their digest.
+func UnpackTgz(src io.Reader, destDir string, skipHidden bool) error {
+	gzr, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break // End of archive
+		}
+		if err != nil {
+			return err
+		}
+		if skipHidden && strings.HasPrefix(header.Name, ".") {
+			log.Println("WARN: Skipping hidden entry", header.Name)
+			continue
+		}
+
+		target := filepath.Join(destDir, header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			if _, err := io.Copy(file, tr); err != nil {
+				return err
+			}
+		default:
+			log.Println("WARN: Skipping unsupported file type", header.Typeflag)
+		}
+	}
+	// the tar trailer may end before the gzip stream, whose checksum is verified at its end
+	_, err = io.Copy(io.Discard, gzr)
+	return err
+}
+
+// PackTgz writes the content of dir as gzip-compressed tarball, with names relative to dir.
+func PackTgz(dst io.Writer, dir string) error {
+	gzw := gzip.NewWriter(dst)
+	tw := tar.NewWriter(gzw)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Println("WARN: Skipping unsupported file", path)
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+// FindAppFiles returns all *.app files below dir.
+func FindAppFiles(dir string) ([]string, error) {
+	var appFiles []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".app") {
+			appFiles = append(appFiles, path)
+		}
+		return nil
+	})
+	return appFiles, err
+}
+
+// FileExists reports whether the file exists and can be accessed.
+func FileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false
+	}
+	return err == nil
+}
+
+// existingParent returns dir or its closest existing parent, e.g. to determine the filesystem of a directory which
+// is yet to be created.
+func existingParent(dir string) string {
+	dir = filepath.Clean(dir)
+	for !FileExists(dir) {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return dir
+}
+
+// FormatBytes formats the size with a binary unit, e.g. 1.5 GiB.
+func FormatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseBytes parses a size such as 512MiB, 2G or 1048576. Units are powers of 1024 with or without "i" and "B".
+func ParseBytes(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(unit), "B"), "I")
+	exp := strings.Index("KMGTPE", unit)
+	if unit == "" {
+		exp = -1
+	} else if exp < 0 || len(unit) != 1 {
+		return 0, fmt.Errorf("invalid unit of size %q", s)
+	}
+	for ; exp >= 0; exp-- {
+		value *= 1024
+	}
+	return uint64(value), nil
+}
+
+// DirSize returns the total size of the regular files below dir.
+func DirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// TgzSize returns the total size of the regular files in a gzip-compressed tarball.
+func TgzSize(src io.Reader) (uint64, error) {
+	gzr, err := gzip.NewReader(src)
+	if err != nil {
+		return 0, err
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	var size uint64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			size += uint64(header.Size)
+		}
+	}
+}
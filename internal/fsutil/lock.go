@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned if a lock is held by another process.
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an exclusive advisory lock on a file, which is released when the process exits.
+type Lock struct {
+	f *os.File
+}
+
+// AcquireLock locks the file at path, creating it unless it exists, and records the PID of the process in it. It
+// fails with ErrLocked if another process holds the lock.
+func AcquireLock(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		b, _ := os.ReadFile(path)
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			if pid := strings.TrimSpace(string(b)); pid != "" {
+				return nil, fmt.Errorf("%s: %w (pid %s)", path, ErrLocked, pid)
+			}
+			return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+		}
+		return nil, err
+	}
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	return l.f.Close()
+}
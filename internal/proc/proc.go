@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package proc runs commands portably across the platforms of the watcher.
+package proc
+
+import "runtime"
+
+// Shell returns the command running the command line with the shell of the platform: sh, or cmd on Windows.
+func Shell(commandLine string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", commandLine}
+	}
+	return []string{"sh", "-c", commandLine}
+}
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build !unix
+
+package proc
+
+import "os"
+
+// Terminate kills the process, as other platforms cannot deliver SIGTERM.
+func Terminate(p *os.Process) error {
+	return p.Kill()
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build unix
+
+package proc
+
+import (
+	"os"
+	"syscall"
+)
+
+// Terminate asks the process to exit by sending SIGTERM.
+func Terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build unix
+
+package selfupdate
+
+import (
+	"os"
+	"syscall"
+)
+
+// restart replaces the process by the executable, keeping its PID for the service manager.
+func restart(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package selfupdate replaces the binary of the running watcher and rolls back to the previous one if the new binary
+// fails to start.
+package selfupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAttempts is the number of starts after which an updated binary which has not been confirmed is rolled back.
+const maxAttempts = 3
+
+// Updater replaces the executable of the process. The staged binary (<executable>.new) and the previous one
+// (<executable>.old) are kept next to it, so it is replaced atomically. Until the update is confirmed, the starts of
+// the new binary are counted in <executable>.update.
+type Updater struct {
+	Executable string
+}
+
+// New returns an updater replacing the executable of the process.
+func New() (*Updater, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, err
+	}
+	return &Updater{Executable: exe}, nil
+}
+
+// Stage copies the binary next to the executable and checks that it runs on this device.
+func (u *Updater) Stage(ctx context.Context, binary string) error {
+	src, err := os.Open(binary)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	staged := u.Executable + ".new"
+	dst, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(staged)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, staged, "version").CombinedOutput()
+	if err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("binary does not run on this device: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	log.Printf("Staged %s", strings.TrimSpace(string(out)))
+	return nil
+}
+
+// Restart replaces the executable by the staged binary and restarts the process with it. It only returns on failure,
+// after restoring the previous binary.
+func (u *Updater) Restart() error {
+	exe, staged, previous := u.Executable, u.Executable+".new", u.Executable+".old"
+	if err := os.Rename(exe, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		_ = os.Rename(previous, exe)
+		return err
+	}
+	if err := os.WriteFile(u.marker(), []byte("0"), 0o644); err != nil {
+		u.rollback()
+		return err
+	}
+	err := restart(exe)
+	u.rollback()
+	return fmt.Errorf("failed to restart: %w", err)
+}
+
+// CheckStartup counts the starts of an updated binary which has not been confirmed yet. Once it failed to start
+// too often, the previous binary is restored and started instead.
+func (u *Updater) CheckStartup() error {
+	b, err := os.ReadFile(u.marker())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	attempts, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	attempts++
+	if attempts <= maxAttempts {
+		return os.WriteFile(u.marker(), []byte(strconv.Itoa(attempts)), 0o644)
+	}
+	log.Printf("ERROR: Updated watcher failed to start %d times, rolling back", maxAttempts)
+	if err := u.rollback(); err != nil {
+		return err
+	}
+	return restart(u.Executable)
+}
+
+// Confirm keeps the updated binary once it started successfully.
+func (u *Updater) Confirm() {
+	if err := os.Remove(u.marker()); err != nil {
+		return
+	}
+	_ = os.Remove(u.Executable + ".old")
+	log.Println("Self-update confirmed")
+}
+
+// rollback restores the previous binary.
+func (u *Updater) rollback() error {
+	if err := os.Rename(u.Executable+".old", u.Executable); err != nil {
+		return fmt.Errorf("failed to restore the previous watcher: %w", err)
+	}
+	return os.Remove(u.marker())
+}
+
+func (u *Updater) marker() string {
+	return u.Executable + ".update"
+}
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build !linux
+
+package sysinfo
+
+import "errors"
+
+// Memory is not supported on this platform.
+func Memory() (total uint64, available uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package sysinfo describes the hardware of the device.
+package sysinfo
+
+import (
+	"path/filepath"
+	"slices"
+)
+
+// gpuDevices match the device nodes of GPUs: NVIDIA GPUs and the render nodes of DRM drivers (Intel, AMD, ...).
+var gpuDevices = []string{"/dev/nvidia[0-9]*", "/dev/dri/renderD*"}
+
+// GPUs returns the device nodes of the GPUs of the device.
+func GPUs() []string {
+	var gpus []string
+	for _, pattern := range gpuDevices {
+		matches, _ := filepath.Glob(pattern)
+		gpus = append(gpus, matches...)
+	}
+	slices.Sort(gpus)
+	return gpus
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// journalSocket receives log entries in the native journal protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// Priorities of journal entries, as in syslog.
+const (
+	PriorityErr     = 3
+	PriorityWarning = 4
+	PriorityInfo    = 6
+)
+
+// JournalStream reports whether stderr is connected to the journal, so log entries are better sent with Journal.
+func JournalStream() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	dev, ino, ok := fileID(info)
+	return ok && stream == fmt.Sprintf("%d:%d", dev, ino)
+}
+
+// Journal writes log lines as journal entries with the given fields. The priority is derived from the ERROR: and
+// WARN: prefixes of the lines. Lines which cannot be sent to the journal are written to stderr.
+type Journal struct {
+	Identifier string
+	// Fields are added to every entry, the names must be upper case, e.g. OCI_WATCHER_DEVICE_ID.
+	Fields map[string]string
+
+	once sync.Once
+	conn *net.UnixConn
+}
+
+func (j *Journal) Write(p []byte) (int, error) {
+	j.once.Do(func() {
+		j.conn, _ = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	})
+	if j.conn == nil {
+		return os.Stderr.Write(p)
+	}
+	msg := strings.TrimSuffix(string(p), "\n")
+	priority := PriorityInfo
+	switch {
+	case strings.HasPrefix(msg, "ERROR:"):
+		priority = PriorityErr
+	case strings.HasPrefix(msg, "WARN:"):
+		priority = PriorityWarning
+	}
+	var entry bytes.Buffer
+	appendField(&entry, "MESSAGE", msg)
+	appendField(&entry, "PRIORITY", fmt.Sprint(priority))
+	appendField(&entry, "SYSLOG_IDENTIFIER", j.Identifier)
+	for name, value := range j.Fields {
+		appendField(&entry, name, value)
+	}
+	if _, err := j.conn.Write(entry.Bytes()); err != nil {
+		return os.Stderr.Write(p)
+	}
+	return len(p), nil
+}
+
+// appendField encodes the field in the native journal protocol: values with newlines are prefixed by their length.
+func appendField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
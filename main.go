@@ -7,86 +7,140 @@
 package main
 
 import (
-	"bufio"
-	"context"
-	"encoding/base64"
 	"flag"
 	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"path"
-	"syscall"
-	"time"
+	"path/filepath"
+	"strings"
 
-	"github.com/regclient/regclient"
-	"golang.org/x/term"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
 )
 
-var (
-	ctx, cancel = context.WithCancel(context.Background())
-	rc          *regclient.RegClient
-)
-
-func main() {
-	configPath := path.Join(os.Getenv("HOME"), ".docker", "config.json")
-	if !fileExists(configPath) {
-		_ = os.MkdirAll(path.Dir(configPath), 0o755)
-
-		reader := bufio.NewReader(os.Stdin)
-
-		fmt.Print("Enter Github username: ")
-		username, _ := reader.ReadString('\n')
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(fs *flag.FlagSet, args []string) error
+}
 
-		fmt.Print("Enter Github token (scope read:packages): ")
-		passwordBytes, _ := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Print("\n")
-		password := string(passwordBytes)
+var commands = []command{
+	{"watch", "watch [flags]", "Reconcile the desired state continuously (default)", runWatch},
+	{"reconcile", "reconcile [--once] [flags]", "Trigger a reconcile of the running watcher, or run one in-process with --once", runReconcile},
+	{"redeploy", "redeploy [--once] [flags] <component>", "Download, verify and install a component again, even if it is up-to-date", runRedeploy},
+	{"status", "status [flags]", "Show the local deployments", runStatus},
+	{"preflight", "preflight [--json] [flags]", "Check that the runtimes, deploy directories and desired state are usable", runPreflight},
+	{"verify", "verify [flags] -package <package>", "Verify a package like the watcher would before deploying it", runVerify},
+	{"package", "package [flags] -signingKey <key.asc>", "Assemble and sign an application package", runPackage},
+	{"push", "push [flags] -repo <ref> -package <package.tgz> -key <pubkey.asc>", "Push a package and its key, and update the desired state", runPush},
+	{"login", "login [flags]", "Store registry credentials in the Docker config", runLogin},
+	{"enroll", "enroll [flags] -tokenURL <url> -tpmHandle <handle>", "Create the device identity used to obtain short-lived registry tokens", runEnroll},
+	{"export-state", "export-state [flags] <archive.tgz>", "Write the deployments and the blob cache to an archive seeding a replacement device ('-' for stdout)", runExportState},
+	{"import-state", "import-state [flags] <archive.tgz>", "Seed the deployments and the blob cache from an archive written by export-state ('-' for stdin)", runImportState},
+	{"version", "version", "Print the version", runVersion},
+}
 
-		encodedAuth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
-		authConfig := fmt.Sprintf(`{
-	"auths": {
-		"ghcr.io": {
-			"auth": "%s"
+func main() {
+	// invoked by regclient as credential helper via symlink
+	if filepath.Base(os.Args[0]) == credentialHelperName {
+		if err := runCredentialHelper(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		return
 	}
-}`, encodedAuth)
-
-		file, err := os.OpenFile(configPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
-		if err != nil {
-			log.Fatalf("Failed to create file: %v", err)
+	// regclient and docker compose only look for the Docker config in the home directory unless DOCKER_CONFIG is set
+	os.Setenv("DOCKER_CONFIG", dockerConfigDir())
+	args := os.Args[1:]
+	// without subcommand the watcher runs as daemon, as it did before subcommands existed
+	name := "watch"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
 		}
-		defer file.Close()
-
-		_, err = file.WriteString(authConfig)
-		if err != nil {
-			log.Fatalf("Failed to write to file: %v", err)
+		fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: oci-watcher %s\n\n%s.\n\nFlags:\n", cmd.usage, cmd.summary)
+			fs.PrintDefaults()
 		}
+		if err := runService(func() error { return cmd.run(fs, args) }); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
+		return
 	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	deployDir := flag.String("deployDir", "./deploy", "Directory to deploy")
-	ociRegistry := flag.String("ociRegistry", "ghcr.io/silvanoc/poc-deploy:desired", "OCI registry URL")
-	flag.Parse()
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: oci-watcher <command> [flags]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'oci-watcher <command> -h' for the flags of a command.")
+}
+
+// registerDaemonFlags registers the flags selecting the Docker daemon.
+func registerDaemonFlags(fs *flag.FlagSet) *backend.Daemon {
+	d := &backend.Daemon{}
+	fs.StringVar(&d.Host, "dockerHost", "", "Docker daemon to deploy to, e.g. tcp://runtime:2376 or ssh://user@runtime (defaults to DOCKER_HOST, the current Docker context or, if run unprivileged, the rootless Docker or Podman daemon in XDG_RUNTIME_DIR)")
+	fs.StringVar(&d.CertPath, "dockerCertPath", "", "Directory with ca.pem, cert.pem and key.pem for TLS-secured access to -dockerHost")
+	fs.StringVar(&d.Context, "dockerContext", "", "Docker context to deploy to (defaults to DOCKER_CONTEXT or the current context)")
+	return d
+}
+
+func registerNomadFlags(fs *flag.FlagSet) *backend.Nomad {
+	n := &backend.Nomad{}
+	fs.StringVar(&n.Address, "nomadAddr", "", "Nomad API receiving the jobs of nomad deployment profiles (defaults to NOMAD_ADDR or http://127.0.0.1:4646); the token is read from NOMAD_TOKEN")
+	fs.StringVar(&n.Namespace, "nomadNamespace", "", "Nomad namespace of the jobs (defaults to NOMAD_NAMESPACE)")
+	return n
+}
+
+func registerKubernetesFlags(fs *flag.FlagSet) *backend.Kubernetes {
+	k := &backend.Kubernetes{}
+	fs.StringVar(&k.Kubeconfig, "kubeconfig", "", "kubeconfig of the cluster receiving the manifests of kubernetes and kustomize deployment profiles, e.g. /etc/rancher/k3s/k3s.yaml (defaults to KUBECONFIG or ~/.kube/config)")
+	fs.StringVar(&k.Namespace, "kubeNamespace", "", "Namespace of the manifests which do not specify one (defaults to the namespace of the kubeconfig context)")
+	return k
+}
 
-	rc = regclient.New(regclient.WithDockerCerts(), regclient.WithDockerCreds())
+// stringList is a flag which may be given multiple times.
+type stringList []string
 
-	defer cancel()
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	running := true
-	for running {
-		select {
-		case <-ticker.C:
-			if err := reconcileDeployments(*ociRegistry, *deployDir); err != nil {
-				log.Println("ERROR:", err)
-			}
-		case <-sigChan:
-			log.Println("Exiting gracefully...")
-			cancel()
-			running = false
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, element := range strings.Split(s, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
 		}
 	}
-	log.Println("Bye")
+	return list
+}
+
+// mirroredRegistries returns the registries which are redirected to the registry mirror.
+func mirroredRegistries(ociRegistry string) []string {
+	hosts := []string{"ghcr.io"}
+	if r, err := ref.New(ociRegistry); err == nil && r.Registry != "ghcr.io" {
+		hosts = append(hosts, r.Registry)
+	}
+	return hosts
 }
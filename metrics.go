@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/reconcile"
+)
+
+// metricsHandler serves the metrics of the watcher in the Prometheus text format.
+type metricsHandler struct {
+	fleet *reconcile.Fleet
+}
+
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, h.fleet)
+}
+
+// writeMetrics writes all metrics of the watcher.
+func writeMetrics(w io.Writer, fleet *reconcile.Fleet) {
+	writeDockerMetrics(w, backend.Clients())
+	writeDeploymentMetrics(w, fleet)
+	writeFailureMetrics(w, fleet)
+	writeTimingMetrics(w, fleet)
+}
+
+func writeTimingMetrics(w io.Writer, fleet *reconcile.Fleet) {
+	fmt.Fprint(w, "# HELP oci_watcher_install_phase_seconds Duration of the phases of the last installation of the component.\n# TYPE oci_watcher_install_phase_seconds gauge\n")
+	for _, r := range fleet.Reconcilers {
+		last := r.Timings.Last()
+		for _, component := range slices.Sorted(maps.Keys(last)) {
+			for _, phase := range slices.Sorted(maps.Keys(last[component])) {
+				fmt.Fprintf(w, "oci_watcher_install_phase_seconds{host=%q,component=%q,phase=%q} %g\n", cmp.Or(r.Host, "local"), component, phase, last[component][phase])
+			}
+		}
+	}
+}
+
+func writeFailureMetrics(w io.Writer, fleet *reconcile.Fleet) {
+	fmt.Fprint(w, "# HELP oci_watcher_reconcile_failures_total Failed reconciliations of components by error code.\n# TYPE oci_watcher_reconcile_failures_total counter\n")
+	for _, r := range fleet.Reconcilers {
+		failures := r.Failures.Counts()
+		for _, code := range slices.Sorted(maps.Keys(failures)) {
+			fmt.Fprintf(w, "oci_watcher_reconcile_failures_total{host=%q,code=%q} %d\n", cmp.Or(r.Host, "local"), code, failures[code])
+		}
+	}
+}
+
+func writeDeploymentMetrics(w io.Writer, fleet *reconcile.Fleet) {
+	fmt.Fprint(w, "# HELP oci_watcher_deployment_disk_bytes Size of the files of the deployment.\n# TYPE oci_watcher_deployment_disk_bytes gauge\n")
+	for _, r := range fleet.Reconcilers {
+		for _, dir := range r.DeploymentDirs() {
+			size, err := fsutil.DirSize(dir)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "oci_watcher_deployment_disk_bytes{host=%q,component=%q} %d\n", cmp.Or(r.Host, "local"), r.ComponentName(dir), size)
+		}
+	}
+	if quota := fleet.Reconcilers[0].DiskQuota; quota > 0 {
+		fmt.Fprintf(w, "# HELP oci_watcher_deployment_disk_quota_bytes Maximum size of the files of a deployment.\n# TYPE oci_watcher_deployment_disk_quota_bytes gauge\noci_watcher_deployment_disk_quota_bytes %d\n", quota)
+	}
+}
+
+func writeDockerMetrics(w io.Writer, stats []backend.ClientStats) {
+	metric := func(name, kind, help string, value func(backend.ClientStats) int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, s := range stats {
+			// the daemon selected by the environment is the default one
+			fmt.Fprintf(w, "%s{endpoint=%q} %d\n", name, cmp.Or(s.Endpoint, "default"), value(s))
+		}
+	}
+	metric("oci_watcher_docker_up", "gauge", "Whether the last health check of the Docker daemon succeeded.", func(s backend.ClientStats) int {
+		if s.Up {
+			return 1
+		}
+		return 0
+	})
+	metric("oci_watcher_docker_connects_total", "counter", "Clients created for the Docker daemon.", func(s backend.ClientStats) int { return s.Connects })
+	metric("oci_watcher_docker_ping_failures_total", "counter", "Failed health checks of the Docker daemon.", func(s backend.ClientStats) int { return s.PingFailures })
+}
+
+// metricsPusher pushes the metrics of devices which cannot be scraped, e.g. behind NAT. Pushgateway URLs
+// (pushgateway+http://host:9091) receive the latest metrics, grouped by the device ID. Other URLs receive the metrics
+// with timestamps in the Prometheus text format (e.g. /api/v1/import/prometheus of VictoriaMetrics), and the
+// metrics collected while the endpoint is unreachable are buffered and pushed once it is reachable again.
+type metricsPusher struct {
+	url      string
+	gateway  bool
+	deviceID string
+	fleet    *reconcile.Fleet
+	client   *http.Client
+	// buffer holds the snapshots which are yet to be pushed, at most max.
+	buffer [][]byte
+	max    int
+}
+
+func newMetricsPusher(rawURL, deviceID string, fleet *reconcile.Fleet, max int) (*metricsPusher, error) {
+	p := &metricsPusher{url: rawURL, deviceID: deviceID, fleet: fleet, client: &http.Client{Timeout: 30 * time.Second}, max: max}
+	if gateway, found := strings.CutPrefix(rawURL, "pushgateway+"); found {
+		p.gateway, p.max = true, 1
+		p.url = strings.TrimSuffix(gateway, "/") + "/metrics/job/oci-watcher/instance/" + url.PathEscape(deviceID)
+	}
+	if u, err := url.Parse(p.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("unsupported URL %q", rawURL)
+	}
+	return p, nil
+}
+
+// run collects and pushes the metrics in every interval until ctx is done.
+func (p *metricsPusher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.collect()
+		p.push(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect adds a snapshot of the metrics to the buffer, dropping the oldest if it is full.
+func (p *metricsPusher) collect() {
+	var b bytes.Buffer
+	writeMetrics(&b, p.fleet)
+	snapshot := b.Bytes()
+	if !p.gateway {
+		snapshot = withTimestamp(snapshot, time.Now())
+	}
+	p.buffer = append(p.buffer, snapshot)
+	if dropped := len(p.buffer) - p.max; dropped > 0 {
+		p.buffer = slices.Delete(p.buffer, 0, dropped)
+	}
+}
+
+// push sends the buffered snapshots, oldest first, until one fails.
+func (p *metricsPusher) push(ctx context.Context) {
+	method := http.MethodPost
+	if p.gateway {
+		// replaces the metrics of the device
+		method = http.MethodPut
+	}
+	for len(p.buffer) > 0 {
+		req, err := http.NewRequestWithContext(ctx, method, p.url, bytes.NewReader(p.buffer[0]))
+		if err != nil {
+			log.Printf("WARN: Failed to push metrics: %s", err)
+			return
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		resp, err := p.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("unexpected status %s", resp.Status)
+			}
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to push metrics to %s, %d snapshots buffered: %s", p.url, len(p.buffer), err)
+			return
+		}
+		p.buffer = p.buffer[1:]
+	}
+}
+
+// withTimestamp appends the timestamp to the samples of metrics in the Prometheus text format.
+func withTimestamp(metrics []byte, t time.Time) []byte {
+	var b bytes.Buffer
+	ts := " " + strconv.FormatInt(t.UnixMilli(), 10)
+	for _, line := range strings.Split(strings.TrimSuffix(string(metrics), "\n"), "\n") {
+		b.WriteString(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			b.WriteString(ts)
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+)
+
+// mqttStatus is published to the status topic after every reconcile and as heartbeat.
+type mqttStatus struct {
+	DeviceID string    `json:"deviceId"`
+	Type     string    `json:"type"` // reconcile, heartbeat or offline
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status,omitempty"` // ok or error
+	Error    string    `json:"error,omitempty"`
+	Code     string    `json:"code,omitempty"` // see errdefs.Code
+	// Heartbeat lists the deployments of the device in heartbeats.
+	Heartbeat *heartbeat `json:"heartbeat,omitempty"`
+}
+
+type mqttChannel struct {
+	client      mqtt.Client
+	deviceID    string
+	statusTopic string
+}
+
+// newMQTTChannel connects to the broker, subscribes to the trigger topic and publishes heartbeats with the inventory
+// of the device until the context is cancelled. The `{device}` placeholder in topics is replaced by the device ID.
+// Panics of the handlers are reported to rep, which may be nil.
+func newMQTTChannel(ctx context.Context, deviceID, broker, clientID, username, password, triggerTopic, statusTopic string, heartbeatInterval time.Duration, inventory func() heartbeat, rep *errorReporter) (*mqttChannel, error) {
+	ch := &mqttChannel{
+		deviceID:    deviceID,
+		statusTopic: strings.ReplaceAll(statusTopic, "{device}", deviceID),
+	}
+	triggerTopic = strings.ReplaceAll(triggerTopic, "{device}", deviceID)
+	if clientID == "" {
+		clientID = "oci-watcher-" + deviceID
+	}
+
+	will, _ := json.Marshal(mqttStatus{DeviceID: deviceID, Type: "offline"})
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetWill(ch.statusTopic, string(will), 1, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			defer rep.recoverPanic()
+			log.Println("MQTT: connected to", broker)
+			// resubscribe after every (re)connect, as the session may not be persistent
+			token := c.Subscribe(triggerTopic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+				defer rep.recoverPanic()
+				log.Println("MQTT: reconcile triggered via", msg.Topic())
+				triggerReconcile()
+			})
+			if token.Wait() && token.Error() != nil {
+				log.Println("ERROR: MQTT subscribe failed:", token.Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Println("WARN: MQTT connection lost:", err)
+		})
+	ch.client = mqtt.NewClient(opts)
+	if token := ch.client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	if heartbeatInterval > 0 {
+		rep.spawn(func() {
+			ticker := time.NewTicker(heartbeatInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					hb := inventory()
+					ch.publish(mqttStatus{Type: "heartbeat", Heartbeat: &hb})
+				}
+			}
+		})
+	}
+	return ch, nil
+}
+
+// publishResult publishes the outcome of a reconcile.
+func (ch *mqttChannel) publishResult(err error) {
+	status := mqttStatus{Type: "reconcile", Status: "ok"}
+	if err != nil {
+		status.Status, status.Error, status.Code = "error", err.Error(), errdefs.Code(err)
+	}
+	ch.publish(status)
+}
+
+func (ch *mqttChannel) publish(status mqttStatus) {
+	status.DeviceID, status.Time = ch.deviceID, time.Now().UTC()
+	b, _ := json.Marshal(status)
+	token := ch.client.Publish(ch.statusTopic, 1, true, b)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Println("WARN: MQTT publish failed:", token.Error())
+		}
+	}()
+}
+
+func (ch *mqttChannel) close() {
+	ch.client.Disconnect(1000)
+}
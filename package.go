@@ -0,0 +1,322 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/crypt"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/sbom"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/source"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/verify"
+)
+
+const (
+	packageArtifactType = "application/vnd.margo.package.v1"
+	packageMediaType    = registry.PackageMediaType
+	keyMediaType        = "application/pgp-keys"
+)
+
+// runPackage assembles an application package: a tarball with the signed app, which in turn holds the compose file
+// and the image tarballs.
+func runPackage(fs *flag.FlagSet, args []string) error {
+	dir := fs.String("dir", ".", "Directory with the compose file (compose.yaml or docker-compose.yaml) and further files of the app")
+	name := fs.String("name", "", "Name of the app (defaults to the directory name)")
+	signingKey := fs.String("signingKey", "", "Armored GPG private key; its passphrase is read from SIGNING_KEY_PASSPHRASE")
+	output := fs.String("o", "", "Output file (defaults to <name>.tgz)")
+	var images, encryptTo, ageRecipients stringList
+	fs.Var(&images, "image", "Image to bundle from the local Docker daemon (repeatable)")
+	fs.Var(&encryptTo, "encryptTo", "Armored GPG public key of a device (group) the package is encrypted for (repeatable)")
+	fs.Var(&ageRecipients, "ageRecipient", "age recipient the package is encrypted for, using the age CLI (repeatable)")
+	daemon := registerDaemonFlags(fs)
+	_ = fs.Parse(args)
+
+	if *signingKey == "" {
+		return fmt.Errorf("-signingKey is required")
+	}
+	if backend.FindComposeFile(*dir) == "" {
+		return fmt.Errorf("%s: no compose file found, e.g. %s", *dir, backend.ComposeFile)
+	}
+	if *name == "" {
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return err
+		}
+		*name = filepath.Base(abs)
+	}
+	if *output == "" {
+		*output = *name + ".tgz"
+	}
+
+	workDir, err := os.MkdirTemp("", "oci-watcher-package")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	appDir, pkgDir := filepath.Join(workDir, "app"), filepath.Join(workDir, "pkg")
+	if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+		return err
+	}
+	if err := os.CopyFS(appDir, os.DirFS(*dir)); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for _, image := range images {
+		fmt.Println("Saving image", image)
+		if err := daemon.SaveImage(ctx, image, filepath.Join(appDir, imageFileName(image))); err != nil {
+			return err
+		}
+	}
+
+	app := filepath.Join(pkgDir, *name+".app")
+	if err := writeTgz(app, appDir); err != nil {
+		return err
+	}
+	key, err := os.Open(*signingKey)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	if err := verify.GPGSign(key, []byte(os.Getenv("SIGNING_KEY_PASSPHRASE")), app, app+".sig"); err != nil {
+		return fmt.Errorf("failed to sign %s: %w", filepath.Base(app), err)
+	}
+	if len(encryptTo) == 0 && len(ageRecipients) == 0 {
+		if err := writeTgz(*output, pkgDir); err != nil {
+			return err
+		}
+		fmt.Println("Created", *output)
+		return nil
+	}
+
+	plain := filepath.Join(workDir, "package.tgz")
+	if err := writeTgz(plain, pkgDir); err != nil {
+		return err
+	}
+	if err := encryptFile(ctx, plain, *output, encryptTo, ageRecipients); err != nil {
+		return fmt.Errorf("failed to encrypt package: %w", err)
+	}
+	fmt.Println("Created encrypted", *output)
+	return nil
+}
+
+func encryptFile(ctx context.Context, src, dst string, gpgRecipients, ageRecipients []string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := crypt.Encrypt(ctx, in, out, gpgRecipients, ageRecipients); err != nil {
+		out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+var unsafeFileNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// imageFileName derives the name of the image tarball, e.g. ghcr.io/org/app:1.0 becomes ghcr.io_org_app_1.0.tar.
+func imageFileName(image string) string {
+	return unsafeFileNameRe.ReplaceAllString(image, "_") + ".tar"
+}
+
+func writeTgz(name, dir string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := fsutil.PackTgz(f, dir); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runPush uploads package and public key as blobs and optionally points a component of the desired state at them.
+func runPush(fs *flag.FlagSet, args []string) error {
+	repo := fs.String("repo", "", "Reference under which package and key are pushed, e.g. ghcr.io/org/app:1.0")
+	pkg := fs.String("package", "", "Package created by 'oci-watcher package'")
+	key := fs.String("key", "", "Armored public key verifying the package")
+	desiredState := fs.String("desiredState", "", "Desired-state artifact to update, e.g. ghcr.io/org/deploy:desired (optional)")
+	component := fs.String("component", "", "Component to update in the desired state (defaults to the package name)")
+	deploymentName := fs.String("deployment", "", "ApplicationDeployment holding the component (required if the desired state has several)")
+	signingKey := fs.String("signingKey", "", "Armored GPG private key for signing the desired state; its passphrase is read from SIGNING_KEY_PASSPHRASE (optional)")
+	var sboms stringList
+	fs.Var(&sboms, "sbom", "SPDX or CycloneDX JSON document attached to the package artifact, which the printed packageLocation then references (repeatable)")
+	registerDockerConfigFlag(fs)
+	_ = fs.Parse(args)
+
+	if *repo == "" || *pkg == "" || *key == "" {
+		return fmt.Errorf("-repo, -package and -key are required")
+	}
+	r, err := ref.New(*repo)
+	if err != nil {
+		return fmt.Errorf("invalid -repo: %w", err)
+	}
+	ctx := context.Background()
+	rc := regclient.New(regclient.WithDockerCerts(), regclient.WithDockerCreds())
+
+	pkgDesc, err := registry.PushFile(ctx, rc, r, packageMediaType, *pkg)
+	if err != nil {
+		return fmt.Errorf("failed to push package: %w", err)
+	}
+	keyDesc, err := registry.PushFile(ctx, rc, r, keyMediaType, *key)
+	if err != nil {
+		return fmt.Errorf("failed to push key: %w", err)
+	}
+	mf, err := registry.PushArtifact(ctx, rc, r, packageArtifactType, []descriptor.Descriptor{pkgDesc, keyDesc}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %w", r.CommonName(), err)
+	}
+	artifactDesc := mf.GetDescriptor()
+	for _, file := range sboms {
+		if err := pushSBOM(ctx, rc, r, artifactDesc, file); err != nil {
+			return fmt.Errorf("failed to push SBOM %s: %w", file, err)
+		}
+	}
+	packageLocation := r.SetDigest(pkgDesc.Digest.String()).CommonName()
+	if len(sboms) > 0 {
+		// referrers need a manifest as subject, so the watcher finds the SBOMs via the artifact
+		packageLocation = r.SetDigest(artifactDesc.Digest.String()).CommonName()
+	}
+	keyLocation := r.SetDigest(keyDesc.Digest.String()).CommonName()
+	fmt.Println("Pushed", r.CommonName())
+	fmt.Println("  packageLocation:", packageLocation)
+	fmt.Println("  keyLocation:    ", keyLocation)
+
+	if *desiredState == "" {
+		return nil
+	}
+	if *component == "" {
+		*component = strings.TrimSuffix(filepath.Base(*pkg), filepath.Ext(*pkg))
+	}
+	return updateDesiredState(ctx, rc, *desiredState, *deploymentName, *component, keyLocation, packageLocation, *signingKey)
+}
+
+// updateDesiredState points the component at the pushed package and publishes the desired state again. A missing
+// desired-state artifact is created. If a signing key is given, a GPG signature is attached as referrer.
+func updateDesiredState(ctx context.Context, rc *regclient.RegClient, desiredState, deploymentName, component, keyLocation, packageLocation, signingKey string) error {
+	r, err := ref.New(desiredState)
+	if err != nil {
+		return fmt.Errorf("invalid -desiredState: %w", err)
+	}
+	src, err := source.New("oci://"+desiredState, source.Options{RegClient: rc})
+	if err != nil {
+		return fmt.Errorf("invalid -desiredState: %w", err)
+	}
+	var docs []any
+	if _, err := rc.ManifestHead(ctx, r); err == nil {
+		b, mediaType, err := src.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+		if mediaType != source.DesiredStateMediaType {
+			return fmt.Errorf("%s holds a patch, not a desired state", desiredState)
+		}
+		if docs, err = deployment.SplitDocuments(b); err != nil {
+			return err
+		}
+	}
+	if docs, err = deployment.SetComponent(docs, deploymentName, component, keyLocation, packageLocation); err != nil {
+		return err
+	}
+	b, err := deployment.JoinDocuments(docs)
+	if err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		d, err := deployment.JoinDocuments([]any{doc})
+		if err != nil {
+			return err
+		}
+		appDeployment, err := deployment.Decode(d)
+		if err == nil {
+			err = appDeployment.Validate()
+		}
+		if err != nil {
+			return fmt.Errorf("updated desired state is invalid (document %d):\n%w", i, err)
+		}
+	}
+
+	layer, err := registry.PushBytes(ctx, rc, r, source.DesiredStateMediaType, b)
+	if err != nil {
+		return err
+	}
+	mf, err := registry.PushArtifact(ctx, rc, r, source.DesiredStateMediaType, []descriptor.Descriptor{layer}, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Updated component %s in %s\n", component, r.CommonName())
+	if signingKey == "" {
+		return nil
+	}
+	return signManifest(ctx, rc, r, mf, signingKey)
+}
+
+// signManifest attaches a detached GPG signature of the manifest as referrer, see verify.GPG.VerifyManifest.
+func signManifest(ctx context.Context, rc *regclient.RegClient, r ref.Ref, mf manifest.Manifest, signingKey string) error {
+	body, err := mf.RawBody()
+	if err != nil {
+		return err
+	}
+	key, err := os.Open(signingKey)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	var sig bytes.Buffer
+	if err := verify.GPGSignStream(key, []byte(os.Getenv("SIGNING_KEY_PASSPHRASE")), bytes.NewReader(body), &sig); err != nil {
+		return fmt.Errorf("failed to sign desired state: %w", err)
+	}
+	layer, err := registry.PushBytes(ctx, rc, r, verify.GPGSignatureMediaType, sig.Bytes())
+	if err != nil {
+		return err
+	}
+	subject := mf.GetDescriptor()
+	if _, err := registry.PushArtifact(ctx, rc, r, verify.GPGSignatureArtifactType, []descriptor.Descriptor{layer}, &subject); err != nil {
+		return fmt.Errorf("failed to push signature: %w", err)
+	}
+	fmt.Println("Signed", r.SetDigest(subject.Digest.String()).CommonName())
+	return nil
+}
+
+// pushSBOM attaches the SBOM as referrer to the artifact holding the package.
+func pushSBOM(ctx context.Context, rc *regclient.RegClient, r ref.Ref, artifactDesc descriptor.Descriptor, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	artifactType, err := sbom.Detect(content)
+	if err != nil {
+		return err
+	}
+	desc, err := registry.PushBytes(ctx, rc, r, artifactType, content)
+	if err != nil {
+		return err
+	}
+	_, err = registry.PushArtifact(ctx, rc, r, artifactType, []descriptor.Descriptor{desc}, &artifactDesc)
+	return err
+}
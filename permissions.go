@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// permissionProblems reports the files of the watcher whose permissions let other users read its credentials or
+// tamper with what it deploys. The watcher needs no privileges beyond write access to its deploy, cache and backup
+// directories, read access to its configuration and credentials, and access to the socket of its runtime, so it can
+// run as unprivileged user against a rootless Docker or Podman daemon. Permissions are not checked on Windows.
+func (f *watcherFlags) permissionProblems() []error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	var problems []error
+	check := func(file string, forbidden os.FileMode, what string) {
+		if file == "" {
+			return
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return
+		}
+		if mode := info.Mode().Perm(); mode&forbidden != 0 {
+			problems = append(problems, fmt.Errorf("%s is %s (mode %04o)", file, what, mode))
+		}
+	}
+	for _, file := range []string{dockerConfigPath(), credentialsPath(), *f.deviceIdentity} {
+		check(file, 0o077, "accessible by other users")
+	}
+	for _, file := range []string{*f.verifyConfig, *f.notifyConfig, *f.hooks, *f.maintenance, *f.overrides, *f.hosts, *f.composeLint, *f.sbomPolicy} {
+		check(file, 0o022, "writable by other users")
+	}
+	for _, dir := range []string{*f.deployDir, *f.cacheDir, *f.backupDir} {
+		check(dir, 0o002, "writable by all users")
+	}
+	return problems
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package backend runs deployments on a container runtime.
+package backend
+
+import "context"
+
+// Status is the runtime state of a deployment.
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	StatusStopped Status = "stopped"
+)
+
+// Backend runs the deployments unpacked by the reconciler. Every deployment lives in its own directory, which
+// identifies it towards the backend.
+type Backend interface {
+	// Load makes the artifacts bundled with a freshly unpacked deployment (e.g. image tarballs) available to the
+	// runtime.
+	Load(ctx context.Context, dir string) error
+	// EnsureRunning starts the deployment unless it is already up.
+	EnsureRunning(ctx context.Context, dir string) error
+	// Stop stops the deployment, keeping persistent data so it can be started again.
+	Stop(ctx context.Context, dir string) error
+	// Remove stops the deployment and deletes all its runtime resources.
+	Remove(ctx context.Context, dir string) error
+	// Status reports whether the deployment is running.
+	Status(ctx context.Context, dir string) (Status, error)
+}
+
+// Purge selects the runtime resources Remove deletes besides the containers of a deployment.
+type Purge struct {
+	// Volumes removes the data volumes as well. They are kept by default, so their data survives a later reinstall.
+	Volumes bool
+	// Images removes the images of the deployment unless other containers use them.
+	Images bool
+}
+
+// ImagePruner is implemented by backends which can remove the images of superseded deployments.
+type ImagePruner interface {
+	// Images lists the images of the deployment in dir.
+	Images(ctx context.Context, dir string) ([]string, error)
+	// RemoveImages removes the images which are not used by containers.
+	RemoveImages(ctx context.Context, images []string) error
+}
+
+// ReadinessChecker is implemented by backends which know when a running deployment is ready to serve, e.g. from
+// health checks. Other deployments are ready once they are running.
+type ReadinessChecker interface {
+	// Ready reports whether the deployment in dir is running and healthy.
+	Ready(ctx context.Context, dir string) (bool, error)
+}
+
+// Checker is implemented by backends which can check whether their runtime is usable, e.g. at startup.
+type Checker interface {
+	// Check returns an error describing why deployments cannot be run.
+	Check(ctx context.Context) error
+}
+
+// Validator is implemented by backends which can check the files of a deployment before it replaces the installed
+// version, so broken packages do not take down working deployments.
+type Validator interface {
+	// Validate returns an error describing why the deployment in dir cannot be run.
+	Validate(ctx context.Context, dir string) error
+}
+
+// LogReader is implemented by backends which can read the logs of deployments, e.g. to diagnose failures remotely.
+type LogReader interface {
+	// Logs returns the last lines of the logs of each service of the deployment in dir.
+	Logs(ctx context.Context, dir string, lines int) (string, error)
+}
+
+// Versioner is implemented by backends which can report the version of their runtime, e.g. as capability of the
+// device.
+type Versioner interface {
+	// RuntimeVersion returns the name of the runtime, e.g. docker, and the version of its server.
+	RuntimeVersion(ctx context.Context) (name string, version string, err error)
+}
+
+// VolumeBackuper is implemented by backends which can back up the data volumes of deployments, e.g. before they are
+// purged.
+type VolumeBackuper interface {
+	// BackupVolumes writes the content of every volume of the deployment in dir to destDir as gzip-compressed
+	// tarball named after the volume.
+	BackupVolumes(ctx context.Context, dir, destDir string) error
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBackupImage provides the tar used to back up volumes.
+const DefaultBackupImage = "busybox"
+
+var _ VolumeBackuper = (*Compose)(nil)
+
+// BackupVolumes backs up the volumes of the compose project in a container of BackupImage mounting them read-only.
+// The tarballs are streamed from the daemon, so it may run on another host.
+func (c *Compose) BackupVolumes(ctx context.Context, dir, destDir string) error {
+	ctx, cancel := c.Timeouts.stop(ctx)
+	defer cancel()
+	volumes, err := c.Daemon.volumes(ctx, projectLabel+"="+composeProject(dir))
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(destDir, 0o700); err != nil {
+		return err
+	}
+	for _, volume := range volumes {
+		if err := c.Daemon.backupVolume(ctx, cmp.Or(c.BackupImage, DefaultBackupImage), volume, filepath.Join(destDir, volume+".tgz")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumes lists the names of the volumes carrying the label.
+func (d Daemon) volumes(ctx context.Context, label string) ([]string, error) {
+	var stdout strings.Builder
+	if err := d.dockerTo(ctx, &stdout, "volume", "ls", "--quiet", "--filter", "label="+label); err != nil {
+		return nil, err
+	}
+	return strings.Fields(stdout.String()), nil
+}
+
+// backupVolume writes the content of the volume to file as gzip-compressed tarball.
+func (d Daemon) backupVolume(ctx context.Context, image, volume, file string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	err = d.dockerTo(ctx, f, "run", "--rm", "--network", "none", "--volume", volume+":/volume:ro", image, "tar", "-czf", "-", "-C", "/volume", ".")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file)
+		return fmt.Errorf("failed to back up volume %s: %w", volume, err)
+	}
+	return nil
+}
@@ -0,0 +1,488 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// ComposeFile is the default name of the compose file of deployments, see FindComposeFile for the others.
+const ComposeFile = "docker-compose.yaml"
+
+// Compose runs deployments with docker-compose. Bundled image tarballs are loaded into the Docker daemon.
+type Compose struct {
+	// Command invokes compose, defaults to docker-compose, or the compose plugin of docker if docker-compose is not
+	// installed, e.g. with Docker Desktop on Windows.
+	Command []string
+	// Daemon runs the deployments.
+	Daemon Daemon
+	// PullImages pulls the images of deployments without image tarballs from their registries, which requires the
+	// compose file to pin every image to a digest.
+	PullImages bool
+	// Credentials returns the username and password for pulling from the registry, empty for anonymous pulls.
+	Credentials func(ctx context.Context, registry string) (string, string, error)
+	// Purge selects the resources deleted on removal. By default, volumes and images are kept.
+	Purge Purge
+	// Timeouts limit the invocations of docker-compose.
+	Timeouts Timeouts
+	// Restarts limits how often failed services of a deployment are restarted.
+	Restarts RestartLimit
+	// LocalOverrides is a directory with override files of the device, which are merged into the compose files of
+	// deployments last and survive their updates, e.g. for site-specific settings. They are named after the compose
+	// project, see ProjectName, e.g. <component>.yaml. Optional.
+	LocalOverrides string
+	// Profiles are the compose profiles enabled for all deployments of the device, e.g. gpu. Deployments may enable
+	// further ones, see ProfilesFile.
+	Profiles []string
+	// BackupImage provides the tar used by BackupVolumes, defaults to DefaultBackupImage.
+	BackupImage string
+}
+
+// RestartLimit stops EnsureRunning from restarting deployments which keep failing, leaving them to the operator
+// instead of recreating their containers in every reconciliation. Zero values select the defaults.
+type RestartLimit struct {
+	// Count is the number of restarts allowed within Window, defaults to 3.
+	Count int
+	// Window defaults to 15 minutes.
+	Window time.Duration
+}
+
+// restarts records the restarts of deployments, shared by the copies of the backend for all hosts.
+var restarts = struct {
+	sync.Mutex
+	m map[restartKey][]time.Time
+}{m: make(map[restartKey][]time.Time)}
+
+type restartKey struct {
+	daemon Daemon
+	dir    string
+}
+
+// allow records a restart of the deployment, or returns an error if the limit is reached.
+func (l RestartLimit) allow(d Daemon, dir string) error {
+	count, window := cmp.Or(l.Count, 3), cmp.Or(l.Window, 15*time.Minute)
+	key := restartKey{daemon: d, dir: dir}
+	now := time.Now()
+	restarts.Lock()
+	defer restarts.Unlock()
+	recent := slices.DeleteFunc(restarts.m[key], func(t time.Time) bool { return now.Sub(t) > window })
+	if len(recent) >= count {
+		restarts.m[key] = recent
+		return fmt.Errorf("restarted %d times within %s, not restarting before %s", len(recent), window, recent[0].Add(window).Format(time.RFC3339))
+	}
+	restarts.m[key] = append(recent, now)
+	return nil
+}
+
+// reset forgets the restarts of the deployment, e.g. when it was stopped on purpose.
+func (l RestartLimit) reset(d Daemon, dir string) {
+	restarts.Lock()
+	defer restarts.Unlock()
+	delete(restarts.m, restartKey{daemon: d, dir: dir})
+}
+
+// projectLabel is set by compose on all resources of a project.
+const projectLabel = "com.docker.compose.project"
+
+var invalidProjectChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// ProjectFile records the compose project of a deployment, see ProjectName.
+const ProjectFile = ".project"
+
+// ProjectName returns the compose project of a component: its name, prefixed with the namespace of its deployment
+// if any, reduced to the characters compose allows.
+func ProjectName(namespace, component string) string {
+	name := component
+	if namespace != "" {
+		name = namespace + "_" + component
+	}
+	return strings.TrimLeft(invalidProjectChars.ReplaceAllString(strings.ToLower(name), ""), "_-")
+}
+
+// composeProject returns the project of the deployment in dir as recorded in its ProjectFile. Deployments installed
+// before projects were recorded use the name compose derives from the directory.
+func composeProject(dir string) string {
+	if b, err := os.ReadFile(filepath.Join(dir, ProjectFile)); err == nil {
+		if project := strings.TrimSpace(string(b)); project != "" {
+			return project
+		}
+	}
+	return ProjectName("", filepath.Base(dir))
+}
+
+var (
+	_ Backend          = (*Compose)(nil)
+	_ ImagePruner      = (*Compose)(nil)
+	_ ReadinessChecker = (*Compose)(nil)
+	_ Checker          = (*Compose)(nil)
+	_ Validator        = (*Compose)(nil)
+)
+
+// defaultComposeCommand prefers the standalone docker-compose over the compose plugin of docker.
+var defaultComposeCommand = sync.OnceValue(func() []string {
+	if _, err := exec.LookPath("docker-compose"); err != nil {
+		if _, err := exec.LookPath("docker"); err == nil {
+			return []string{"docker", "compose"}
+		}
+	}
+	return []string{"docker-compose"}
+})
+
+func (c *Compose) composeCommand() []string {
+	if len(c.Command) == 0 {
+		return defaultComposeCommand()
+	}
+	return c.Command
+}
+
+func (c *Compose) command(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	global := []string{"--project-name", composeProject(dir)}
+	for _, file := range c.files(dir) {
+		global = append(global, "--file", file)
+	}
+	for _, profile := range c.profiles(dir) {
+		global = append(global, "--profile", profile)
+	}
+	cmd := newCommand(ctx, c.composeCommand(), append(global, args...)...)
+	cmd.Dir = dir
+	if env := c.Daemon.Env(); env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// Check verifies that the daemon is reachable and docker-compose can be run.
+func (c *Compose) Check(ctx context.Context) error {
+	ctx, cancel := c.Timeouts.status(ctx)
+	defer cancel()
+	if err := c.Daemon.Ping(ctx); err != nil {
+		return fmt.Errorf("Docker daemon unreachable: %w", err)
+	}
+	cmd := newCommand(ctx, c.composeCommand(), "version")
+	if env := c.Daemon.Env(); env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if _, err := runOutput(cmd, "docker-compose"); err != nil {
+		return fmt.Errorf("docker-compose unusable: %w", err)
+	}
+	return nil
+}
+
+// RuntimeVersion returns the version of the Docker daemon.
+func (c *Compose) RuntimeVersion(ctx context.Context) (string, string, error) {
+	ctx, cancel := c.Timeouts.status(ctx)
+	defer cancel()
+	version, err := c.Daemon.Version(ctx)
+	return "docker", version, err
+}
+
+// WithDaemon returns a copy running the deployments on the daemon.
+func (c *Compose) WithDaemon(d Daemon) Backend {
+	copied := *c
+	copied.Daemon = d
+	return &copied
+}
+
+// Load loads all *.tar files in dir into Docker, or pulls the images of the compose file if there are none.
+func (c *Compose) Load(ctx context.Context, dir string) error {
+	if c.PullImages {
+		tarballs, err := filepath.Glob(filepath.Join(dir, "*.tar"))
+		if err != nil {
+			return err
+		}
+		if len(tarballs) == 0 {
+			// the daemon pulls the images of its platform, services may require another one
+			if _, err := c.Daemon.selectTarballs(ctx, dir, nil); err != nil {
+				return err
+			}
+			return c.pullImages(ctx, dir)
+		}
+	}
+	return c.Daemon.loadImages(ctx, dir)
+}
+
+// pullImages pulls the images referenced by the compose file, which must be pinned to a digest.
+func (c *Compose) pullImages(ctx context.Context, dir string) error {
+	services, err := composeServices(dir, c.files(dir))
+	if err != nil {
+		return err
+	}
+	var images []string
+	for name, service := range services {
+		if service.Image == "" {
+			continue
+		}
+		// the digest is the last part, following an optional tag
+		if _, dgst, found := strings.Cut(service.Image, "@"); !found || digest.Digest(dgst).Validate() != nil {
+			return fmt.Errorf("service %s: image %s is not pinned to a digest", name, service.Image)
+		}
+		if !slices.Contains(images, service.Image) {
+			images = append(images, service.Image)
+		}
+	}
+	slices.Sort(images)
+	for _, image := range images {
+		var user, pass string
+		if c.Credentials != nil {
+			r, err := ref.New(image)
+			if err != nil {
+				return fmt.Errorf("invalid image %s: %w", image, err)
+			}
+			if user, pass, err = c.Credentials(ctx, r.Registry); err != nil {
+				return fmt.Errorf("credentials for %s: %w", r.Registry, err)
+			}
+		}
+		if err := c.Daemon.PullImage(ctx, image, user, pass); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureRunning starts the deployment unless all its services are up, recreating the containers of services which
+// exited, keep restarting or are missing. Services which completed successfully and are not restarted by compose are
+// up as well.
+func (c *Compose) EnsureRunning(ctx context.Context, dir string) error {
+	ctx, cancel := c.Timeouts.start(ctx)
+	defer cancel()
+	failed, found, err := c.services(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if found {
+		if err := c.Restarts.allow(c.Daemon, dir); err != nil {
+			return err
+		}
+		log.Printf("%s: restarting deployment: %s", filepath.Base(dir), strings.Join(failed, ", "))
+	} else {
+		log.Printf("%s: starting deployment", filepath.Base(dir))
+	}
+	return runCommand(c.command(ctx, dir, "up", "--detach", "--remove-orphans"), filepath.Base(dir))
+}
+
+// Validate checks the compose file with docker-compose config, which parses and interpolates it like docker-compose
+// up would, and the platforms of the bundled images.
+func (c *Compose) Validate(ctx context.Context, dir string) error {
+	ctx, cancel := c.Timeouts.status(ctx)
+	defer cancel()
+	if FindComposeFile(dir) == "" {
+		return fmt.Errorf("compose file is missing, expected one of %s", strings.Join(composeFileNames, ", "))
+	}
+	if _, err := runOutput(c.command(ctx, dir, "config", "--quiet"), filepath.Base(dir)); err != nil {
+		return fmt.Errorf("invalid compose configuration: %w", err)
+	}
+	tarballs, err := filepath.Glob(filepath.Join(dir, "*.tar"))
+	if err != nil {
+		return err
+	}
+	_, err = c.Daemon.selectTarballs(ctx, dir, tarballs)
+	return err
+}
+
+// Stop takes the compose project down. Directories without compose file are ignored.
+func (c *Compose) Stop(ctx context.Context, dir string) error {
+	ctx, cancel := c.Timeouts.stop(ctx)
+	defer cancel()
+	if FindComposeFile(dir) == "" {
+		return nil
+	}
+	c.Restarts.reset(c.Daemon, dir)
+	return runCommand(c.command(ctx, dir, "down"), filepath.Base(dir))
+}
+
+// Remove takes the compose project down including its volumes unless they are kept, and the networks and volumes
+// left behind by previous versions. Directories without compose file are ignored.
+func (c *Compose) Remove(ctx context.Context, dir string) error {
+	ctx, cancel := c.Timeouts.stop(ctx)
+	defer cancel()
+	if FindComposeFile(dir) == "" {
+		return nil
+	}
+	c.Restarts.reset(c.Daemon, dir)
+	args := []string{"down", "--remove-orphans"}
+	if c.Purge.Volumes {
+		args = append(args, "--volumes")
+	}
+	if c.Purge.Images {
+		args = append(args, "--rmi", "all")
+	}
+	if err := runCommand(c.command(ctx, dir, args...), filepath.Base(dir)); err != nil {
+		return err
+	}
+	return c.Daemon.pruneProject(ctx, projectLabel, composeProject(dir), c.Purge.Volumes)
+}
+
+// Status reports the deployment as running if all its services are up, see EnsureRunning.
+func (c *Compose) Status(ctx context.Context, dir string) (Status, error) {
+	ctx, cancel := c.Timeouts.status(ctx)
+	defer cancel()
+	failed, _, err := c.services(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	if len(failed) > 0 {
+		return StatusStopped, nil
+	}
+	return StatusRunning, nil
+}
+
+// composeContainer is a container listed by docker-compose ps.
+type composeContainer struct {
+	Service  string `json:"Service"`
+	State    string `json:"State"`
+	ExitCode int    `json:"ExitCode"`
+	// Health is empty for containers without health check.
+	Health string `json:"Health"`
+}
+
+// Ready reports whether all services of the deployment are up and none of their containers is starting or
+// unhealthy according to its health check.
+func (c *Compose) Ready(ctx context.Context, dir string) (bool, error) {
+	ctx, cancel := c.Timeouts.status(ctx)
+	defer cancel()
+	containers, err := c.containers(ctx, dir)
+	if err != nil {
+		return false, err
+	}
+	failed, _, err := checkServices(dir, c.files(dir), c.profiles(dir), containers)
+	if err != nil || len(failed) > 0 {
+		return false, err
+	}
+	for _, container := range containers {
+		if container.State == "running" && container.Health != "" && container.Health != "healthy" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Logs returns the last lines of the logs of each service, prefixed with the container.
+func (c *Compose) Logs(ctx context.Context, dir string, lines int) (string, error) {
+	ctx, cancel := c.Timeouts.status(ctx)
+	defer cancel()
+	output, err := runOutput(c.command(ctx, dir, "logs", "--no-color", "--timestamps", "--tail", strconv.Itoa(lines)), filepath.Base(dir))
+	return string(output), err
+}
+
+func (c *Compose) containers(ctx context.Context, dir string) ([]composeContainer, error) {
+	output, err := runOutput(c.command(ctx, dir, "ps", "--all", "--format", "json"), filepath.Base(dir))
+	if err != nil {
+		return nil, err
+	}
+	containers, err := parseContainers(output)
+	if err != nil {
+		return nil, fmt.Errorf("%s: docker-compose ps: %w", filepath.Base(dir), err)
+	}
+	return containers, nil
+}
+
+// services describes the services of the deployment which are not up, and reports whether it has any containers.
+func (c *Compose) services(ctx context.Context, dir string) (failed []string, found bool, err error) {
+	containers, err := c.containers(ctx, dir)
+	if err != nil {
+		return nil, false, err
+	}
+	return checkServices(dir, c.files(dir), c.profiles(dir), containers)
+}
+
+// checkServices describes the services of the deployment which are not up given its compose files, enabled profiles
+// and containers.
+func checkServices(dir string, files, profiles []string, containers []composeContainer) (failed []string, found bool, err error) {
+	services, err := composeServices(dir, files)
+	if err != nil {
+		return nil, false, err
+	}
+
+	names := make([]string, 0, len(services))
+	for name, service := range services {
+		// optional services are only started if one of their profiles is enabled
+		if len(service.Profiles) == 0 || slices.ContainsFunc(service.Profiles, func(p string) bool { return slices.Contains(profiles, p) }) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		restarted := services[name].Restart == "always" || services[name].Restart == "unless-stopped"
+		state := "missing"
+		for _, container := range containers {
+			if container.Service != name {
+				continue
+			}
+			switch {
+			case container.State == "running":
+				state = ""
+			case container.State == "exited" && container.ExitCode == 0 && !restarted:
+				// completed one-off task, e.g. a migration
+				state = ""
+			case container.State == "exited":
+				state = fmt.Sprintf("exited with code %d", container.ExitCode)
+			default:
+				state = container.State
+			}
+			if state != "" {
+				break
+			}
+		}
+		if state != "" {
+			failed = append(failed, name+" "+state)
+		}
+	}
+	return failed, len(containers) > 0, nil
+}
+
+// parseContainers parses the output of docker-compose ps --format json, which is a JSON array in older versions of
+// compose and one object per line in newer ones.
+func parseContainers(output []byte) ([]composeContainer, error) {
+	output = bytes.TrimSpace(output)
+	var containers []composeContainer
+	if bytes.HasPrefix(output, []byte("[")) {
+		err := json.Unmarshal(output, &containers)
+		return containers, err
+	}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var container composeContainer
+		if err := json.Unmarshal(line, &container); err != nil {
+			return nil, err
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// Images lists the images of the compose file and the bundled tarballs.
+func (c *Compose) Images(_ context.Context, dir string) ([]string, error) {
+	return deploymentImages(dir)
+}
+
+// RemoveImages removes the images from the daemon unless containers use them.
+func (c *Compose) RemoveImages(ctx context.Context, images []string) error {
+	return c.Daemon.removeImages(ctx, images)
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFileNames are the names of compose files in the order docker compose prefers them.
+var composeFileNames = []string{"compose.yaml", "compose.yml", ComposeFile, "docker-compose.yml"}
+
+// overrideFileNames are the names of override files merged into the compose file, in the order docker compose
+// prefers them.
+var overrideFileNames = []string{"compose.override.yaml", "compose.override.yml", "docker-compose.override.yaml", "docker-compose.override.yml"}
+
+// FindComposeFile returns the name of the compose file in dir, the empty string if there is none.
+func FindComposeFile(dir string) string {
+	for _, name := range composeFileNames {
+		if fsutil.FileExists(filepath.Join(dir, name)) {
+			return name
+		}
+	}
+	return ""
+}
+
+// ComposeFiles returns the names of the compose files of the app in dir in the order they are merged: the compose
+// file followed by its override file, if any. It is empty if dir has no compose file.
+func ComposeFiles(dir string) []string {
+	file := FindComposeFile(dir)
+	if file == "" {
+		return nil
+	}
+	files := []string{file}
+	for _, name := range overrideFileNames {
+		if fsutil.FileExists(filepath.Join(dir, name)) {
+			return append(files, name)
+		}
+	}
+	return files
+}
+
+// files returns the compose files of the deployment in dir: those of its app, followed by the device-local override
+// file, if any. The files of the app are relative to dir.
+func (c *Compose) files(dir string) []string {
+	files := ComposeFiles(dir)
+	if len(files) == 0 || c.LocalOverrides == "" {
+		return files
+	}
+	local, err := filepath.Abs(filepath.Join(c.LocalOverrides, composeProject(dir)+".yaml"))
+	if err == nil && fsutil.FileExists(local) {
+		files = append(files, local)
+	}
+	return files
+}
+
+// ProfilesFile records the compose profiles the desired state enables for a deployment, comma-separated.
+const ProfilesFile = ".compose-profiles"
+
+// ReadProfiles returns the compose profiles recorded for the deployment in dir, see ProfilesFile.
+func ReadProfiles(dir string) []string {
+	b, _ := os.ReadFile(filepath.Join(dir, ProfilesFile))
+	var profiles []string
+	for _, profile := range strings.Split(string(b), ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// profiles returns the compose profiles enabled for the deployment in dir: those of the device and those recorded
+// for the deployment.
+func (c *Compose) profiles(dir string) []string {
+	profiles := slices.Concat(c.Profiles, ReadProfiles(dir))
+	slices.Sort(profiles)
+	return slices.Compact(profiles)
+}
+
+// composeServices returns the services of the compose files, which are relative to dir unless absolute. The fields
+// of services defined by several files are merged like docker compose does.
+func composeServices(dir string, files []string) (map[string]composeService, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no compose file", filepath.Base(dir))
+	}
+	services := make(map[string]composeService)
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var compose struct {
+			Services map[string]composeService `yaml:"services"`
+		}
+		if err := yaml.Unmarshal(b, &compose); err != nil {
+			return nil, fmt.Errorf("invalid compose file %s: %w", filepath.Base(file), err)
+		}
+		for name, override := range compose.Services {
+			services[name] = services[name].merge(override)
+		}
+	}
+	return services, nil
+}
+
+// merge returns the service with the fields set by the override replaced, and its volumes appended.
+func (s composeService) merge(override composeService) composeService {
+	if override.Image != "" {
+		s.Image = override.Image
+	}
+	if override.Privileged {
+		s.Privileged = true
+	}
+	if override.NetworkMode != "" {
+		s.NetworkMode = override.NetworkMode
+	}
+	if override.Restart != "" {
+		s.Restart = override.Restart
+	}
+	if override.Profiles != nil {
+		s.Profiles = override.Profiles
+	}
+	if override.Platform != "" {
+		s.Platform = override.Platform
+	}
+	s.Volumes = append(s.Volumes, override.Volumes...)
+	return s
+}
@@ -0,0 +1,649 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"archive/tar"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	dockerimage "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// Daemon selects the Docker daemon, which may run on another host than the watcher. The zero value uses the
+// environment like the docker CLI: DOCKER_HOST, DOCKER_CERT_PATH and DOCKER_TLS_VERIFY, or else the context selected
+// by DOCKER_CONTEXT or the Docker config. If none selects a daemon and the watcher runs unprivileged without access
+// to the system-wide daemon, the rootless Docker or Podman daemon of the user is used, whose socket is found in
+// $XDG_RUNTIME_DIR.
+type Daemon struct {
+	// Host is the address of the daemon, e.g. tcp://runtime:2376 or ssh://user@runtime.
+	Host string
+	// CertPath is the directory with ca.pem, cert.pem and key.pem, enabling TLS with verification for Host.
+	CertPath string
+	// Context is the name of a Docker context, used if Host is empty.
+	Context string
+}
+
+// endpoint is a resolved daemon address.
+type endpoint struct {
+	host       string
+	tlsDir     string
+	skipVerify bool
+}
+
+// Env returns the environment variables selecting the daemon for docker CLIs such as docker compose.
+func (d Daemon) Env() []string {
+	switch {
+	case d.Host != "" && d.CertPath != "":
+		return []string{"DOCKER_HOST=" + d.Host, "DOCKER_CERT_PATH=" + d.CertPath, "DOCKER_TLS_VERIFY=1"}
+	case d.Host != "":
+		return []string{"DOCKER_HOST=" + d.Host}
+	case d.Context != "":
+		return []string{"DOCKER_CONTEXT=" + d.Context}
+	}
+	if host := d.rootlessHost(); host != "" {
+		return []string{"DOCKER_HOST=" + host}
+	}
+	return nil
+}
+
+// rootlessHost returns the socket of the rootless daemon of the user unless the daemon is selected otherwise.
+func (d Daemon) rootlessHost() string {
+	if d.Host != "" || d.Context != "" || os.Getenv("DOCKER_HOST") != "" || os.Getenv("DOCKER_CONTEXT") != "" {
+		return ""
+	}
+	if name := currentContext(); name != "" && name != "default" {
+		return ""
+	}
+	return rootlessSocket()
+}
+
+// endpoint resolves the daemon, returning the zero endpoint if the environment selects it.
+func (d Daemon) endpoint() (endpoint, error) {
+	if d.Host != "" {
+		return endpoint{host: d.Host, tlsDir: d.CertPath}, nil
+	}
+	if host := d.rootlessHost(); host != "" {
+		return endpoint{host: host}, nil
+	}
+	name := d.Context
+	if name == "" && os.Getenv("DOCKER_HOST") == "" {
+		if name = os.Getenv("DOCKER_CONTEXT"); name == "" {
+			name = currentContext()
+		}
+	}
+	if name == "" || name == "default" {
+		return endpoint{}, nil
+	}
+	return contextEndpoint(name)
+}
+
+func configDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+func currentContext() string {
+	var cfg struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if b, err := os.ReadFile(filepath.Join(configDir(), "config.json")); err == nil {
+		_ = json.Unmarshal(b, &cfg)
+	}
+	return cfg.CurrentContext
+}
+
+// contextEndpoint reads the endpoint of the context from the context store of the docker CLI.
+func contextEndpoint(name string) (endpoint, error) {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	b, err := os.ReadFile(filepath.Join(configDir(), "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return endpoint{}, fmt.Errorf("docker context %s: %w", name, err)
+	}
+	var meta struct {
+		Endpoints struct {
+			Docker struct {
+				Host          string `json:"Host"`
+				SkipTLSVerify bool   `json:"SkipTLSVerify"`
+			} `json:"docker"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return endpoint{}, fmt.Errorf("docker context %s: %w", name, err)
+	}
+	if meta.Endpoints.Docker.Host == "" {
+		return endpoint{}, fmt.Errorf("docker context %s: no docker endpoint", name)
+	}
+	ep := endpoint{host: meta.Endpoints.Docker.Host, skipVerify: meta.Endpoints.Docker.SkipTLSVerify}
+	if tlsDir := filepath.Join(configDir(), "contexts", "tls", id, "docker"); dirExists(tlsDir) {
+		ep.tlsDir = tlsDir
+	}
+	return ep, nil
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// tlsConfig loads the CA and client certificate from ca.pem, cert.pem and key.pem, each of which is optional.
+func (ep endpoint) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: ep.skipVerify}
+	if ca, err := os.ReadFile(filepath.Join(ep.tlsDir, "ca.pem")); err == nil {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%s: no certificates found", filepath.Join(ep.tlsDir, "ca.pem"))
+		}
+	}
+	certFile, keyFile := filepath.Join(ep.tlsDir, "cert.pem"), filepath.Join(ep.tlsDir, "key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ssh reports whether the daemon is reached via SSH, which only the docker CLI supports.
+func (ep endpoint) ssh() bool {
+	return strings.HasPrefix(ep.host, "ssh://")
+}
+
+// healthInterval is the interval in which shared clients are checked before use.
+const healthInterval = 30 * time.Second
+
+// sharedClient is the client of an endpoint, shared by all deployments and hosts using it.
+type sharedClient struct {
+	cli     *client.Client
+	checked time.Time
+	stats   ClientStats
+}
+
+var clients = struct {
+	sync.Mutex
+	m map[endpoint]*sharedClient
+}{m: make(map[endpoint]*sharedClient)}
+
+// ClientStats describes the connection to a Docker daemon.
+type ClientStats struct {
+	// Endpoint is the address of the daemon, empty if selected by the environment.
+	Endpoint string
+	// Connects counts the clients created, i.e. the initial one and those replacing clients which failed the health
+	// check.
+	Connects int
+	// PingFailures counts the failed health checks.
+	PingFailures int
+	// Up reports whether the last health check succeeded.
+	Up bool
+}
+
+// Clients returns the statistics of the connections to Docker daemons, sorted by endpoint.
+func Clients() []ClientStats {
+	clients.Lock()
+	defer clients.Unlock()
+	stats := make([]ClientStats, 0, len(clients.m))
+	for _, c := range clients.m {
+		stats = append(stats, c.stats)
+	}
+	slices.SortFunc(stats, func(a, b ClientStats) int { return strings.Compare(a.Endpoint, b.Endpoint) })
+	return stats
+}
+
+// client returns the shared client of the endpoint. Clients are pinged if they have not been checked recently and
+// replaced if the daemon is unreachable, e.g. after it restarted with another socket.
+func (ep endpoint) client(ctx context.Context) (*client.Client, error) {
+	clients.Lock()
+	defer clients.Unlock()
+	c := clients.m[ep]
+	if c != nil && c.cli != nil {
+		if time.Since(c.checked) < healthInterval {
+			return c.cli, nil
+		}
+		if c.ping(ctx) {
+			return c.cli, nil
+		}
+		c.cli.Close()
+		c.cli = nil
+	}
+	if c == nil {
+		c = &sharedClient{stats: ClientStats{Endpoint: ep.host}}
+		clients.m[ep] = c
+	}
+
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if ep.host != "" {
+		if ep.tlsDir != "" {
+			tlsConfig, err := ep.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, CheckRedirect: client.CheckRedirect}))
+		}
+		opts = append(opts, client.WithHost(ep.host))
+	}
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	c.cli = cli
+	c.stats.Connects++
+	// an unreachable daemon is reported by the calls using the client
+	c.ping(ctx)
+	return cli, nil
+}
+
+// ping checks whether the daemon is reachable.
+func (c *sharedClient) ping(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := c.cli.Ping(ctx)
+	c.checked = time.Now()
+	c.stats.Up = err == nil
+	if err != nil {
+		c.stats.PingFailures++
+		log.Printf("WARN: Docker daemon %s: %s", cmp.Or(c.stats.Endpoint, "from environment"), err)
+	}
+	return err == nil
+}
+
+// Ping checks whether the daemon is reachable.
+func (d Daemon) Ping(ctx context.Context) error {
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		cmd := newCommand(ctx, []string{"docker"}, "version", "--format", "{{.Server.Version}}")
+		cmd.Env = append(os.Environ(), d.Env()...)
+		_, err := runOutput(cmd, "docker")
+		return err
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = cli.Ping(ctx)
+	return err
+}
+
+// Version returns the version of the daemon.
+func (d Daemon) Version(ctx context.Context) (string, error) {
+	ep, err := d.endpoint()
+	if err != nil {
+		return "", err
+	}
+	if ep.ssh() {
+		cmd := newCommand(ctx, []string{"docker"}, "version", "--format", "{{.Server.Version}}")
+		cmd.Env = append(os.Environ(), d.Env()...)
+		out, err := runOutput(cmd, "docker")
+		return strings.TrimSpace(string(out)), err
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return "", err
+	}
+	v, err := cli.ServerVersion(ctx)
+	return v.Version, err
+}
+
+// docker runs the docker CLI against the daemon.
+func (d Daemon) docker(ctx context.Context, args ...string) error {
+	return d.dockerTo(ctx, os.Stdout, args...)
+}
+
+// dockerTo is like docker but writes the output of the CLI to stdout.
+func (d Daemon) dockerTo(ctx context.Context, stdout io.Writer, args ...string) error {
+	cmd := newCommand(ctx, []string{"docker"}, args...)
+	cmd.Env = append(os.Environ(), d.Env()...)
+	cmd.Stdout = stdout
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// loadImages loads all *.tar files in dir, skipping tarballs whose images are present in the daemon already or which
+// are for another platform, see selectTarballs.
+func (d Daemon) loadImages(ctx context.Context, dir string) error {
+	var tarballs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".tar") {
+			tarballs = append(tarballs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if tarballs, err = d.selectTarballs(ctx, dir, tarballs); err != nil {
+		return err
+	}
+	for _, path := range tarballs {
+		if d.imagesPresent(ctx, path) {
+			log.Printf("%s: images already present, skipping load", filepath.Base(path))
+			continue
+		}
+		if err := d.LoadImage(ctx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarballImage is an image in a tarball as listed by its manifest.json.
+type tarballImage struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+}
+
+// ID returns the image ID, i.e. the digest of the config, which is stored as <hex>.json by older versions of docker
+// save and as blobs/sha256/<hex> in the OCI layout.
+func (img tarballImage) ID() string {
+	return "sha256:" + strings.TrimSuffix(filepath.Base(img.Config), ".json")
+}
+
+// tarballImages reads the images of a tarball produced by docker save.
+func tarballImages(file string) ([]tarballImage, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(hdr.Name) != "manifest.json" {
+			continue
+		}
+		var images []tarballImage
+		if err := json.NewDecoder(tr).Decode(&images); err != nil {
+			return nil, fmt.Errorf("%s: invalid manifest.json: %w", filepath.Base(file), err)
+		}
+		return images, nil
+	}
+}
+
+// imagesPresent reports whether the daemon has all images of the tarball, tagging them where tags are missing. It
+// errs on the side of loading the tarball.
+func (d Daemon) imagesPresent(ctx context.Context, file string) bool {
+	images, err := tarballImages(file)
+	if err != nil || len(images) == 0 {
+		return false
+	}
+	ep, err := d.endpoint()
+	if err != nil || ep.ssh() {
+		return false
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return false
+	}
+	for _, img := range images {
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, img.ID())
+		if err != nil {
+			return false
+		}
+		for _, tag := range img.RepoTags {
+			if !slices.Contains(inspect.RepoTags, tag) {
+				if err := cli.ImageTag(ctx, img.ID(), tag); err != nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// deploymentImages lists the images of the compose files of the app in dir and of the bundled tarballs.
+func deploymentImages(dir string) ([]string, error) {
+	var images []string
+	if files := ComposeFiles(dir); len(files) > 0 {
+		services, err := composeServices(dir, files)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			// interpolated references cannot be resolved
+			if service.Image != "" && !strings.Contains(service.Image, "$") {
+				images = append(images, service.Image)
+			}
+		}
+	}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".tar") {
+			return err
+		}
+		tarball, err := tarballImages(file)
+		if err != nil {
+			return nil
+		}
+		for _, img := range tarball {
+			if len(img.RepoTags) == 0 {
+				images = append(images, img.ID())
+			}
+			images = append(images, img.RepoTags...)
+		}
+		return nil
+	})
+	slices.Sort(images)
+	return slices.Compact(images), err
+}
+
+// removeImages removes the images, skipping those which are in use or missing.
+func (d Daemon) removeImages(ctx context.Context, images []string) error {
+	if len(images) == 0 {
+		return nil
+	}
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		for _, image := range images {
+			if err := d.docker(ctx, "image", "rm", image); err != nil {
+				log.Printf("WARN: %s", err)
+			}
+		}
+		return nil
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if _, err := cli.ImageRemove(ctx, image, dockerimage.RemoveOptions{PruneChildren: true}); err != nil {
+			if !errdefs.IsNotFound(err) {
+				log.Printf("WARN: failed to remove image %s: %s", image, err)
+			}
+			continue
+		}
+		log.Printf("Removed image %s", image)
+	}
+	return nil
+}
+
+// pruneProject removes the unused networks, and volumes if requested, carrying the label of the project.
+func (d Daemon) pruneProject(ctx context.Context, label, project string, volumes bool) error {
+	filter := label + "=" + project
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		if err := d.docker(ctx, "network", "prune", "--force", "--filter", "label="+filter); err != nil {
+			return err
+		}
+		if volumes {
+			return d.docker(ctx, "volume", "prune", "--all", "--force", "--filter", "label="+filter)
+		}
+		return nil
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := cli.NetworksPrune(ctx, filters.NewArgs(filters.Arg("label", filter))); err != nil {
+		return fmt.Errorf("failed to remove networks of %s: %w", project, err)
+	}
+	if volumes {
+		// all includes named volumes
+		if _, err := cli.VolumesPrune(ctx, filters.NewArgs(filters.Arg("label", filter), filters.Arg("all", "true"))); err != nil {
+			return fmt.Errorf("failed to remove volumes of %s: %w", project, err)
+		}
+	}
+	return nil
+}
+
+// LoadImage loads an image tarball (as produced by `docker save`) into the Docker daemon.
+func (d Daemon) LoadImage(ctx context.Context, filePath string) error {
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		return d.docker(ctx, "load", "--input", filePath)
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	response, err := cli.ImageLoad(ctx, file, true)
+	if err != nil {
+		return fmt.Errorf("failed to load image into Docker: %w", err)
+	}
+	defer response.Body.Close()
+
+	// show response
+	_, err = io.Copy(os.Stdout, response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return nil
+}
+
+// PullImage pulls the image into the daemon unless it is present already. The credentials are optional.
+func (d Daemon) PullImage(ctx context.Context, image, user, pass string) error {
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		// the docker CLI uses the credentials of its config
+		if err := d.docker(ctx, "pull", "--quiet", image); err != nil {
+			return pullError(image, err)
+		}
+		return nil
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+	if _, _, err := cli.ImageInspectWithRaw(ctx, image); err == nil {
+		return nil
+	}
+
+	log.Printf("Pulling %s", image)
+	var opts dockerimage.PullOptions
+	if user != "" || pass != "" {
+		if opts.RegistryAuth, err = registry.EncodeAuthConfig(registry.AuthConfig{Username: user, Password: pass}); err != nil {
+			return err
+		}
+	}
+	response, err := cli.ImagePull(ctx, image, opts)
+	if err != nil {
+		return pullError(image, err)
+	}
+	defer response.Close()
+	// errors are reported in the progress stream
+	if err := jsonmessage.DisplayJSONMessagesStream(response, io.Discard, 0, false, nil); err != nil {
+		return pullError(image, err)
+	}
+	return nil
+}
+
+// pullError wraps the error of a pull, which is classified as ErrPlatformMismatch if the image is not available for
+// the platform of the daemon.
+func pullError(image string, err error) error {
+	if strings.Contains(err.Error(), "no matching manifest") {
+		return fmt.Errorf("failed to pull image %s: %w: %w", image, ErrPlatformMismatch, err)
+	}
+	return fmt.Errorf("failed to pull image %s: %w", image, err)
+}
+
+// SaveImage writes the image from the Docker daemon as tarball, which LoadImage can load again.
+func (d Daemon) SaveImage(ctx context.Context, image, filePath string) error {
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		return d.docker(ctx, "save", "--output", filePath, image)
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	response, err := cli.ImageSave(ctx, []string{image})
+	if err != nil {
+		return fmt.Errorf("failed to save image %s: %w", image, err)
+	}
+	defer response.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(file, response); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save image %s: %w", image, err)
+	}
+	return file.Close()
+}
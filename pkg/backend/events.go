@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// eventRetryDelay is the delay before subscribing to the events again after the stream failed.
+const eventRetryDelay = 10 * time.Second
+
+// ContainerEvent reports a container of a deployment which died unexpectedly or ran out of memory.
+type ContainerEvent struct {
+	// Dir is the directory of the deployment.
+	Dir       string
+	Service   string
+	Container string
+	// Action is die or oom.
+	Action string
+	// ExitCode is set for die events.
+	ExitCode string
+}
+
+// WatchEvents calls handle for the containers of the deployments in deployDir which die or run out of memory, until
+// the context is done. Containers stopped by compose or the docker CLI are not reported, as they are killed before
+// they die. The stream of the daemon is subscribed again if it fails.
+func (c *Compose) WatchEvents(ctx context.Context, deployDir string, handle func(ContainerEvent)) {
+	// killed records the containers which were stopped on purpose
+	killed := make(map[string]time.Time)
+	for ctx.Err() == nil {
+		err := c.Daemon.events(ctx, func(msg events.Message) {
+			for id, t := range killed {
+				if time.Since(t) > time.Minute {
+					delete(killed, id)
+				}
+			}
+			if msg.Action == events.ActionKill {
+				killed[msg.Actor.ID] = time.Now()
+				return
+			}
+			if _, found := killed[msg.Actor.ID]; found && msg.Action == events.ActionDie {
+				return
+			}
+			dir := deploymentDir(deployDir, msg.Actor.Attributes[projectLabel])
+			if dir == "" {
+				return
+			}
+			handle(ContainerEvent{
+				Dir:       dir,
+				Service:   msg.Actor.Attributes["com.docker.compose.service"],
+				Container: cmp.Or(msg.Actor.Attributes["name"], msg.Actor.ID),
+				Action:    string(msg.Action),
+				ExitCode:  msg.Actor.Attributes["exitCode"],
+			})
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("WARN: Docker events: %s, subscribing again in %s", err, eventRetryDelay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(eventRetryDelay):
+		}
+	}
+}
+
+// deploymentDir returns the directory of the compose project in deployDir or the deploy directories of namespaces in
+// its .namespaces directory, empty if it is not a deployment.
+func deploymentDir(deployDir, project string) string {
+	if project == "" {
+		return ""
+	}
+	roots := []string{deployDir}
+	namespaces, _ := filepath.Glob(filepath.Join(deployDir, ".namespaces", "*"))
+	for _, root := range append(roots, namespaces...) {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// previous versions are stopped
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && composeProject(filepath.Join(root, entry.Name())) == project {
+				return filepath.Join(root, entry.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// events streams the kill, die and oom events of compose containers until the stream fails.
+func (d Daemon) events(ctx context.Context, handle func(events.Message)) error {
+	ep, err := d.endpoint()
+	if err != nil {
+		return err
+	}
+	if ep.ssh() {
+		return d.eventsCLI(ctx, handle)
+	}
+	cli, err := ep.client(ctx)
+	if err != nil {
+		return err
+	}
+	messages, errs := cli.Events(ctx, events.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionKill)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionOOM)),
+		filters.Arg("label", projectLabel),
+	)})
+	for {
+		select {
+		case msg := <-messages:
+			handle(msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// eventsCLI streams the events with the docker CLI.
+func (d Daemon) eventsCLI(ctx context.Context, handle func(events.Message)) error {
+	cmd := newCommand(ctx, []string{"docker"}, "events", "--format", "{{json .}}", "--filter", "type=container",
+		"--filter", "event=kill", "--filter", "event=die", "--filter", "event=oom", "--filter", "label="+projectLabel)
+	cmd.Env = append(os.Environ(), d.Env()...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &tailWriter{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var msg events.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err == nil {
+			handle(msg)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("docker events: %w: %s", err, stderr)
+	}
+	return errors.New("docker events exited")
+}
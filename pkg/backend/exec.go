@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/proc"
+)
+
+// Debug logs the output of the runtime CLIs.
+var Debug bool
+
+// killDelay is the grace period between terminating a command whose context is done and killing it.
+const killDelay = 10 * time.Second
+
+// newCommand returns the command with the arguments appended. If the context is done before it exits, e.g. on
+// timeout or shutdown, it is terminated and killed after killDelay, so compose can stop the containers it started.
+func newCommand(ctx context.Context, command []string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, command[0], append(slices.Clone(command[1:]), args...)...)
+	cmd.Cancel = func() error {
+		return proc.Terminate(cmd.Process)
+	}
+	cmd.WaitDelay = killDelay
+	return cmd
+}
+
+// Timeouts limit the runtime CLI invocations of an operation on a deployment. Zero values select the defaults.
+type Timeouts struct {
+	// Start covers starting a deployment including pulling missing images, defaults to 10 minutes.
+	Start time.Duration
+	// Stop covers stopping and removing a deployment, defaults to 5 minutes.
+	Stop time.Duration
+	// Status defaults to 1 minute.
+	Status time.Duration
+}
+
+func (t Timeouts) start(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cmp.Or(t.Start, 10*time.Minute))
+}
+
+func (t Timeouts) stop(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cmp.Or(t.Stop, 5*time.Minute))
+}
+
+func (t Timeouts) status(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cmp.Or(t.Status, time.Minute))
+}
+
+// stderrTail is the number of lines of stderr included in errors.
+const stderrTail = 10
+
+// runCommand runs the command, logging its output with the prefix if Debug is set. Errors include the tail of
+// stderr.
+func runCommand(cmd *exec.Cmd, prefix string) error {
+	_, err := execute(cmd, prefix, false)
+	return err
+}
+
+// runOutput is like runCommand but returns stdout instead of logging it.
+func runOutput(cmd *exec.Cmd, prefix string) ([]byte, error) {
+	return execute(cmd, prefix, true)
+}
+
+func execute(cmd *exec.Cmd, prefix string, capture bool) ([]byte, error) {
+	var stdout bytes.Buffer
+	stderr := &tailWriter{}
+	var logStdout, logStderr *logWriter
+	if Debug {
+		logStdout, logStderr = &logWriter{prefix: prefix}, &logWriter{prefix: prefix}
+		defer logStdout.Flush()
+		defer logStderr.Flush()
+		cmd.Stderr = io.MultiWriter(stderr, logStderr)
+	} else {
+		cmd.Stderr = stderr
+	}
+	switch {
+	case capture:
+		cmd.Stdout = &stdout
+	case Debug:
+		cmd.Stdout = logStdout
+	}
+	if err := cmd.Run(); err != nil {
+		name := filepath.Base(cmd.Path)
+		// the subcommand follows the global flags and their values
+		for i := 1; i < len(cmd.Args); i++ {
+			if arg := cmd.Args[i]; !strings.HasPrefix(arg, "-") {
+				name += " " + arg
+				break
+			} else if !strings.Contains(arg, "=") {
+				i++
+			}
+		}
+		if tail := stderr.String(); tail != "" {
+			return stdout.Bytes(), fmt.Errorf("%s: %w: %s", name, err, tail)
+		}
+		return stdout.Bytes(), fmt.Errorf("%s: %w", name, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// tailWriter keeps the last lines written to it.
+type tailWriter struct {
+	lines []string
+	// partial is the last line until it is terminated
+	partial []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(t.partial[:i])); line != "" {
+			t.lines = append(t.lines, line)
+		}
+		t.partial = t.partial[i+1:]
+	}
+	if len(t.lines) > stderrTail {
+		t.lines = t.lines[len(t.lines)-stderrTail:]
+	}
+	return len(p), nil
+}
+
+// String returns the tail joined into a single line.
+func (t *tailWriter) String() string {
+	lines := t.lines
+	if line := strings.TrimSpace(string(t.partial)); line != "" {
+		lines = append(lines[max(0, len(lines)-stderrTail+1):], line)
+	}
+	return strings.Join(lines, "; ")
+}
+
+// logWriter logs every line written to it.
+type logWriter struct {
+	prefix  string
+	partial []byte
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(l.partial[:i]), "\r"); line != "" {
+			log.Printf("%s: %s", l.prefix, line)
+		}
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the unterminated last line.
+func (l *logWriter) Flush() {
+	if len(l.partial) > 0 {
+		log.Printf("%s: %s", l.prefix, l.partial)
+		l.partial = nil
+	}
+}
@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"gopkg.in/yaml.v3"
+)
+
+// Kubernetes applies the manifests of deployments to a Kubernetes cluster such as k3s with server-side apply. The
+// manifests are rendered with Kustomize if the app has a kustomization.yaml, otherwise all *.yaml, *.yml and *.json
+// files in its top-level directory are applied. Bundled image tarballs are imported into containerd.
+type Kubernetes struct {
+	// Command invokes kubectl, defaults to kubectl.
+	Command []string
+	// Kubeconfig defaults to KUBECONFIG or ~/.kube/config.
+	Kubeconfig string
+	// Namespace of namespaced resources without one, defaults to the namespace of the kubeconfig context.
+	Namespace string
+	// ImportCommand imports an image tarball given as last argument, defaults to k3s ctr images import.
+	ImportCommand []string
+	// Purge selects the resources deleted on removal; persistent volumes and their claims are data volumes. Images
+	// are left to the garbage collection of the kubelet.
+	Purge Purge
+	// Timeouts limit the invocations of kubectl.
+	Timeouts Timeouts
+}
+
+var _ Backend = (*Kubernetes)(nil)
+
+// fieldManager owns the fields applied by the watcher, so conflicting changes by others are overridden.
+const fieldManager = "oci-watcher"
+
+// deploymentLabel is set on all applied resources.
+const deploymentLabel = "watcher.margo.org/deployment"
+
+var kustomizations = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+func (k *Kubernetes) kubectl(ctx context.Context, stdin []byte, args ...string) (string, error) {
+	command := k.Command
+	if len(command) == 0 {
+		command = []string{"kubectl"}
+	}
+	// global flags are accepted after the subcommand as well
+	globalArgs := slices.Clone(args)
+	if k.Kubeconfig != "" {
+		globalArgs = append(globalArgs, "--kubeconfig", k.Kubeconfig)
+	}
+	if k.Namespace != "" {
+		globalArgs = append(globalArgs, "--namespace", k.Namespace)
+	}
+	cmd := newCommand(ctx, command, globalArgs...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return string(out), fmt.Errorf("kubectl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// manifests renders the resources of the deployment, labeled with the deployment. The kinds are filtered if keep is
+// not nil.
+func (k *Kubernetes) manifests(ctx context.Context, dir string, keep func(kind string) bool) ([]byte, error) {
+	var rendered []byte
+	if slices.ContainsFunc(kustomizations, func(name string) bool { return fsutil.FileExists(filepath.Join(dir, name)) }) {
+		out, err := k.kubectl(ctx, nil, "kustomize", dir)
+		if err != nil {
+			return nil, err
+		}
+		rendered = []byte(out)
+	} else {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := path.Ext(entry.Name())
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			rendered = append(append(rendered, b...), "\n---\n"...)
+		}
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	dec := yaml.NewDecoder(bytes.NewReader(rendered))
+	for {
+		var obj map[string]any
+		if err := dec.Decode(&obj); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: invalid manifest: %w", filepath.Base(dir), err)
+		}
+		kind, _ := obj["kind"].(string)
+		if obj == nil || kind == "" || (keep != nil && !keep(kind)) {
+			continue
+		}
+		metadata, _ := obj["metadata"].(map[string]any)
+		if metadata == nil {
+			metadata = make(map[string]any)
+			obj["metadata"] = metadata
+		}
+		labels, _ := metadata["labels"].(map[string]any)
+		if labels == nil {
+			labels = make(map[string]any)
+			metadata["labels"] = labels
+		}
+		labels[deploymentLabel] = filepath.Base(dir)
+		if err := enc.Encode(obj); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Load imports all *.tar files in dir into containerd, e.g. of k3s.
+func (k *Kubernetes) Load(ctx context.Context, dir string) error {
+	command := k.ImportCommand
+	if len(command) == 0 {
+		command = []string{"k3s", "ctr", "images", "import"}
+	}
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".tar") {
+			if _, err := run(ctx, command, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EnsureRunning applies the manifests unless all resources exist.
+func (k *Kubernetes) EnsureRunning(ctx context.Context, dir string) error {
+	ctx, cancel := k.Timeouts.start(ctx)
+	defer cancel()
+	status, err := k.Status(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if status == StatusRunning {
+		return nil
+	}
+	manifests, err := k.manifests(ctx, dir, nil)
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		return fmt.Errorf("%s: no Kubernetes manifests found", filepath.Base(dir))
+	}
+	log.Printf("%s: applying manifests", filepath.Base(dir))
+	_, err = k.kubectl(ctx, manifests, "apply", "--server-side", "--field-manager", fieldManager, "--force-conflicts", "--filename", "-")
+	return err
+}
+
+// persistentKinds are kept when stopping a deployment.
+var persistentKinds = []string{"Namespace", "PersistentVolumeClaim", "PersistentVolume"}
+
+// Stop deletes the resources except namespaces and persistent volumes.
+func (k *Kubernetes) Stop(ctx context.Context, dir string) error {
+	ctx, cancel := k.Timeouts.stop(ctx)
+	defer cancel()
+	return k.delete(ctx, dir, func(kind string) bool { return !slices.Contains(persistentKinds, kind) })
+}
+
+// Remove deletes all resources of the deployment, except persistent volumes unless they are purged.
+func (k *Kubernetes) Remove(ctx context.Context, dir string) error {
+	ctx, cancel := k.Timeouts.stop(ctx)
+	defer cancel()
+	if !k.Purge.Volumes {
+		return k.delete(ctx, dir, func(kind string) bool { return kind != "PersistentVolumeClaim" && kind != "PersistentVolume" })
+	}
+	return k.delete(ctx, dir, nil)
+}
+
+func (k *Kubernetes) delete(ctx context.Context, dir string, keep func(kind string) bool) error {
+	manifests, err := k.manifests(ctx, dir, keep)
+	if err != nil || len(manifests) == 0 {
+		return err
+	}
+	_, err = k.kubectl(ctx, manifests, "delete", "--ignore-not-found", "--wait=false", "--filename", "-")
+	return err
+}
+
+// Status reports the deployment as running if all its resources exist.
+func (k *Kubernetes) Status(ctx context.Context, dir string) (Status, error) {
+	ctx, cancel := k.Timeouts.status(ctx)
+	defer cancel()
+	manifests, err := k.manifests(ctx, dir, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(manifests) == 0 {
+		return StatusStopped, nil
+	}
+	// get fails if any resource is missing
+	if _, err := k.kubectl(ctx, manifests, "get", "--output", "name", "--filename", "-"); err != nil {
+		return StatusStopped, nil
+	}
+	return StatusRunning, nil
+}
+
+// RuntimeVersion returns the version of the Kubernetes API server.
+func (k *Kubernetes) RuntimeVersion(ctx context.Context) (string, string, error) {
+	ctx, cancel := k.Timeouts.status(ctx)
+	defer cancel()
+	out, err := k.kubectl(ctx, nil, "version", "--output", "json")
+	if err != nil {
+		return "kubernetes", "", err
+	}
+	var version struct {
+		ServerVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"serverVersion"`
+	}
+	if err := json.Unmarshal([]byte(out), &version); err != nil {
+		return "kubernetes", "", fmt.Errorf("invalid output of kubectl version: %w", err)
+	}
+	return "kubernetes", strings.TrimPrefix(version.ServerVersion.GitVersion, "v"), nil
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LintAction is the treatment of a dangerous compose construct.
+type LintAction string
+
+const (
+	LintDeny  LintAction = "deny"
+	LintWarn  LintAction = "warn"
+	LintAllow LintAction = "allow"
+)
+
+// LintPolicy configures the compose linter. Unset actions default to deny.
+type LintPolicy struct {
+	Privileged  LintAction `yaml:"privileged"`
+	HostNetwork LintAction `yaml:"hostNetwork"`
+	// BindMounts applies to bind mounts of host paths outside the deployment directory.
+	BindMounts   LintAction `yaml:"bindMounts"`
+	DockerSocket LintAction `yaml:"dockerSocket"`
+}
+
+// LoadLintPolicy reads the policy from a YAML file of the form:
+//
+//	privileged: deny
+//	hostNetwork: warn
+//	bindMounts: warn
+//	dockerSocket: deny
+func LoadLintPolicy(path string) (*LintPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p LintPolicy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	for field, action := range map[string]LintAction{"privileged": p.Privileged, "hostNetwork": p.HostNetwork, "bindMounts": p.BindMounts, "dockerSocket": p.DockerSocket} {
+		if action != "" && !slices.Contains([]LintAction{LintDeny, LintWarn, LintAllow}, action) {
+			return nil, fmt.Errorf("%s: unknown action %q", field, action)
+		}
+	}
+	return &p, nil
+}
+
+type composeService struct {
+	Image       string `yaml:"image"`
+	Privileged  bool   `yaml:"privileged"`
+	NetworkMode string `yaml:"network_mode"`
+	Volumes     []any  `yaml:"volumes"`
+	Restart     string `yaml:"restart"`
+	// Profiles makes the service optional, it is only started if one of them is enabled.
+	Profiles []string `yaml:"profiles"`
+	// Platform selects the variant of the image, e.g. windows/amd64.
+	Platform string `yaml:"platform"`
+}
+
+// Lint checks the compose file which will be deployed in dir. It returns the findings to warn about, and an error
+// listing the denied ones.
+func (p *LintPolicy) Lint(composeFile []byte, dir string) ([]string, error) {
+	var compose struct {
+		Services map[string]composeService `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(composeFile, &compose); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	var warnings, denied []string
+	report := func(action LintAction, service, finding string) {
+		msg := fmt.Sprintf("service %s: %s", service, finding)
+		switch action {
+		case LintAllow:
+		case LintWarn:
+			warnings = append(warnings, msg)
+		default:
+			denied = append(denied, msg)
+		}
+	}
+	names := make([]string, 0, len(compose.Services))
+	for name := range compose.Services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		service := compose.Services[name]
+		if service.Privileged {
+			report(p.Privileged, name, "privileged container")
+		}
+		if service.NetworkMode == "host" {
+			report(p.HostNetwork, name, "host network")
+		}
+		for _, volume := range service.Volumes {
+			source, bind := bindSource(volume)
+			if !bind {
+				continue
+			}
+			if strings.HasSuffix(source, "docker.sock") {
+				report(p.DockerSocket, name, "mounts the Docker socket "+source)
+				continue
+			}
+			if !filepath.IsAbs(source) && !strings.HasPrefix(source, "~") {
+				source = filepath.Join(dir, source)
+			}
+			if rel, err := filepath.Rel(dir, source); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+				report(p.BindMounts, name, "bind mount of "+source+" outside the deployment")
+			}
+		}
+	}
+	if len(denied) > 0 {
+		return warnings, errors.New("compose file violates lint policy: " + strings.Join(denied, "; "))
+	}
+	return warnings, nil
+}
+
+// bindSource returns the host path of a bind mount, in short ("./data:/data:ro") or long syntax.
+func bindSource(volume any) (string, bool) {
+	switch v := volume.(type) {
+	case string:
+		source, _, found := strings.Cut(v, ":")
+		if !found {
+			// anonymous volume
+			return "", false
+		}
+		return source, strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+	case map[string]any:
+		source, _ := v["source"].(string)
+		return source, v["type"] == "bind"
+	}
+	return "", false
+}
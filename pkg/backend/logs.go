@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// logRescanInterval is the interval in which new deployments are looked for, and in which the logs of deployments
+// are followed again after docker-compose logs exited, e.g. because the deployment was stopped.
+const logRescanInterval = 30 * time.Second
+
+// LogLine is a line logged by a container of a deployment.
+type LogLine struct {
+	// Dir is the directory of the deployment.
+	Dir       string
+	Container string
+	Time      time.Time
+	Message   string
+}
+
+// FollowLogs calls handle for the lines logged by the containers of the deployments in deployDir and the deploy
+// directories of its namespaces, until the context is done. Lines logged before are skipped. Deployments are picked
+// up once they are installed. Handle is called concurrently for different deployments.
+func (c *Compose) FollowLogs(ctx context.Context, deployDir string, handle func(LogLine)) {
+	followed := make(map[string]bool)
+	done := make(chan string)
+	for {
+		roots := []string{deployDir}
+		namespaces, _ := filepath.Glob(filepath.Join(deployDir, ".namespaces", "*"))
+		for _, root := range append(roots, namespaces...) {
+			entries, _ := os.ReadDir(root)
+			for _, entry := range entries {
+				dir := filepath.Join(root, entry.Name())
+				// hidden directories hold previous versions, which are stopped
+				if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || followed[dir] {
+					continue
+				}
+				followed[dir] = true
+				go func() {
+					c.followDeployment(ctx, dir, handle)
+					select {
+					case done <- dir:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case dir := <-done:
+			delete(followed, dir)
+		case <-time.After(logRescanInterval):
+		}
+	}
+}
+
+// followDeployment follows the logs of the deployment in dir until it is removed or the context is done.
+func (c *Compose) followDeployment(ctx context.Context, dir string, handle func(LogLine)) {
+	since := time.Now()
+	for fsutil.FileExists(dir) && ctx.Err() == nil {
+		cmd := c.command(ctx, dir, "logs", "--follow", "--no-color", "--timestamps", "--since", since.Format(time.RFC3339Nano))
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			log.Printf("WARN: %s: failed to follow logs: %s", filepath.Base(dir), err)
+			return
+		}
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line, err := parseLogLine(scanner.Text())
+			if err != nil {
+				continue
+			}
+			line.Dir = dir
+			handle(line)
+			since = line.Time.Add(time.Nanosecond)
+		}
+		_ = cmd.Wait()
+		select {
+		case <-ctx.Done():
+		case <-time.After(logRescanInterval):
+		}
+	}
+}
+
+// parseLogLine parses a line of docker-compose logs --no-color --timestamps, e.g.
+// "app-web-1  | 2025-01-02T03:04:05.123456789Z listening on :8080".
+func parseLogLine(s string) (LogLine, error) {
+	container, rest, found := strings.Cut(s, "|")
+	if !found {
+		return LogLine{}, fmt.Errorf("invalid log line %q", s)
+	}
+	timestamp, message, _ := strings.Cut(strings.TrimPrefix(rest, " "), " ")
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return LogLine{}, fmt.Errorf("invalid timestamp in log line %q: %w", s, err)
+	}
+	return LogLine{Container: strings.TrimSpace(container), Time: t, Message: message}, nil
+}
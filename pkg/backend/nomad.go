@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Nomad submits the job specs of deployments to a Nomad cluster: all *.nomad, *.nomad.hcl and *.nomad.json files in
+// the top-level directory of the app. HCL specs are converted by the Nomad API. The jobs reference their images in
+// a registry, bundled image tarballs are not supported.
+type Nomad struct {
+	// Address of the Nomad API, defaults to NOMAD_ADDR or http://127.0.0.1:4646.
+	Address string
+	// Token is the ACL token, defaults to NOMAD_TOKEN.
+	Token string
+	// Namespace and Region of the jobs, default to NOMAD_NAMESPACE and NOMAD_REGION, or those of the cluster.
+	Namespace string
+	Region    string
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+var _ Backend = (*Nomad)(nil)
+
+// nomadJob is the part of a job the backend needs to know about; the spec is passed through unchanged.
+type nomadJob struct {
+	ID     string `json:"ID"`
+	Status string `json:"Status"`
+	Stop   bool   `json:"Stop"`
+}
+
+func (n *Nomad) address() string {
+	addr := n.Address
+	if addr == "" {
+		if addr = os.Getenv("NOMAD_ADDR"); addr == "" {
+			addr = "http://127.0.0.1:4646"
+		}
+	}
+	return strings.TrimSuffix(addr, "/")
+}
+
+// httpClient honors NOMAD_CACERT, NOMAD_CLIENT_CERT, NOMAD_CLIENT_KEY and NOMAD_SKIP_VERIFY like the nomad CLI.
+func (n *Nomad) httpClient() (*http.Client, error) {
+	n.once.Do(func() {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: os.Getenv("NOMAD_SKIP_VERIFY") == "true"}
+		if file := os.Getenv("NOMAD_CACERT"); file != "" {
+			ca, err := os.ReadFile(file)
+			if err != nil {
+				n.err = err
+				return
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+				n.err = fmt.Errorf("%s: no certificates found", file)
+				return
+			}
+		}
+		if certFile := os.Getenv("NOMAD_CLIENT_CERT"); certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("NOMAD_CLIENT_KEY"))
+			if err != nil {
+				n.err = fmt.Errorf("failed to load client certificate: %w", err)
+				return
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		n.client = &http.Client{Timeout: time.Minute, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: cfg}}
+	})
+	return n.client, n.err
+}
+
+// do sends a request to the Nomad API and decodes the JSON response into v unless it is nil. A missing job is
+// reported as errJobNotFound.
+func (n *Nomad) do(ctx context.Context, method, endpoint string, query url.Values, body, v any) error {
+	client, err := n.httpClient()
+	if err != nil {
+		return err
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	if namespace := cmp.Or(n.Namespace, os.Getenv("NOMAD_NAMESPACE")); namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	if region := cmp.Or(n.Region, os.Getenv("NOMAD_REGION")); region != "" {
+		query.Set("region", region)
+	}
+	u := n.address() + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := cmp.Or(n.Token, os.Getenv("NOMAD_TOKEN")); token != "" {
+		req.Header.Set("X-Nomad-Token", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("nomad: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(endpoint, "/v1/job/") {
+		return errJobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("nomad: %s %s: %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("nomad: %s %s: invalid response: %w", method, endpoint, err)
+	}
+	return nil
+}
+
+var errJobNotFound = errors.New("job not found")
+
+// jobFiles lists the job specs of the deployment.
+func jobFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && (strings.HasSuffix(name, ".nomad") || strings.HasSuffix(name, ".nomad.hcl") || strings.HasSuffix(name, ".nomad.json")) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files, nil
+}
+
+// jobs returns the specs of the deployment in the JSON representation of the API.
+func (n *Nomad) jobs(ctx context.Context, dir string) ([]json.RawMessage, error) {
+	files, err := jobFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []json.RawMessage
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var job json.RawMessage
+		if strings.HasSuffix(file, ".json") {
+			// the output of nomad job run -output wraps the job
+			var wrapped struct {
+				Job json.RawMessage `json:"Job"`
+			}
+			if err := json.Unmarshal(b, &wrapped); err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+			}
+			job = wrapped.Job
+			if job == nil {
+				job = b
+			}
+		} else if err := n.do(ctx, http.MethodPost, "/v1/jobs/parse", nil, map[string]any{"JobHCL": string(b), "Canonicalize": true}, &job); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// jobIDs returns the IDs of the jobs of the deployment.
+func (n *Nomad) jobIDs(ctx context.Context, dir string) ([]string, error) {
+	jobs, err := n.jobs(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		var j nomadJob
+		if err := json.Unmarshal(job, &j); err != nil {
+			return nil, err
+		}
+		if j.ID == "" {
+			return nil, fmt.Errorf("%s: job without ID", filepath.Base(dir))
+		}
+		ids = append(ids, j.ID)
+	}
+	return ids, nil
+}
+
+// Load does nothing, as Nomad clients pull the images of the jobs themselves.
+func (n *Nomad) Load(context.Context, string) error {
+	return nil
+}
+
+// EnsureRunning registers the jobs unless they are running already.
+func (n *Nomad) EnsureRunning(ctx context.Context, dir string) error {
+	status, err := n.Status(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if status == StatusRunning {
+		return nil
+	}
+	jobs, err := n.jobs(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("%s: no Nomad job spec found", filepath.Base(dir))
+	}
+	log.Printf("%s: registering Nomad jobs", filepath.Base(dir))
+	for _, job := range jobs {
+		if err := n.do(ctx, http.MethodPost, "/v1/jobs", nil, map[string]any{"Job": job}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deregister stops the jobs, purging them from the cluster state if requested.
+func (n *Nomad) deregister(ctx context.Context, dir string, purge bool) error {
+	ids, err := n.jobIDs(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		query := url.Values{}
+		if purge {
+			query.Set("purge", "true")
+		}
+		if err := n.do(ctx, http.MethodDelete, "/v1/job/"+url.PathEscape(id), query, nil, nil); err != nil && !errors.Is(err, errJobNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop deregisters the jobs, which keeps them in the cluster state until garbage collection.
+func (n *Nomad) Stop(ctx context.Context, dir string) error {
+	return n.deregister(ctx, dir, false)
+}
+
+// Remove deregisters and purges the jobs.
+func (n *Nomad) Remove(ctx context.Context, dir string) error {
+	return n.deregister(ctx, dir, true)
+}
+
+// Status reports the deployment as running if none of its jobs is stopped or dead.
+func (n *Nomad) Status(ctx context.Context, dir string) (Status, error) {
+	ids, err := n.jobIDs(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return StatusStopped, nil
+	}
+	for _, id := range ids {
+		var job nomadJob
+		if err := n.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(id), nil, nil, &job); err != nil {
+			if errors.Is(err, errJobNotFound) {
+				return StatusStopped, nil
+			}
+			return "", err
+		}
+		if job.Stop || job.Status == "dead" {
+			return StatusStopped, nil
+		}
+	}
+	return StatusRunning, nil
+}
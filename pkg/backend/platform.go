@@ -0,0 +1,317 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/regclient/regclient/types/platform"
+)
+
+// ErrPlatformMismatch is returned for bundled images which the host of the daemon cannot run, e.g. amd64 images on an
+// arm64 gateway.
+var ErrPlatformMismatch = errors.New("image platform does not match the host")
+
+// maxTarballMetadata bounds the size of the manifests, indexes and configs read from image tarballs.
+const maxTarballMetadata = 4 << 20
+
+// Platform returns the platform of the daemon's host. For Windows containers, it includes the version of Windows,
+// e.g. 10.0.17763, which the version of Windows images must not exceed.
+func (d Daemon) Platform(ctx context.Context) (platform.Platform, error) {
+	ep, err := d.endpoint()
+	if err != nil {
+		return platform.Platform{}, err
+	}
+	var osName, arch, kernel string
+	if ep.ssh() {
+		cmd := newCommand(ctx, []string{"docker"}, "version", "--format", "{{.Server.Os}}/{{.Server.Arch}} {{.Server.KernelVersion}}")
+		cmd.Env = append(os.Environ(), d.Env()...)
+		out, err := runOutput(cmd, "docker")
+		if err != nil {
+			return platform.Platform{}, err
+		}
+		var p string
+		p, kernel, _ = strings.Cut(strings.TrimSpace(string(out)), " ")
+		osName, arch, _ = strings.Cut(p, "/")
+	} else {
+		cli, err := ep.client(ctx)
+		if err != nil {
+			return platform.Platform{}, err
+		}
+		v, err := cli.ServerVersion(ctx)
+		if err != nil {
+			return platform.Platform{}, err
+		}
+		osName, arch, kernel = v.Os, v.Arch, v.KernelVersion
+	}
+	p, err := platform.Parse(osName + "/" + arch)
+	if err != nil {
+		return platform.Platform{}, err
+	}
+	if p.OS == "windows" {
+		p.OSVersion = windowsVersion(kernel)
+	}
+	return p, nil
+}
+
+var windowsKernelRe = regexp.MustCompile(`^(\d+)\.(\d+) (\d+) `)
+
+// windowsVersion returns the version of Windows, e.g. 10.0.17763, from the kernel version reported by the daemon,
+// e.g. "10.0 17763 (17763.1.amd64fre.rs5_release.180914-1434)".
+func windowsVersion(kernel string) string {
+	m := windowsKernelRe.FindStringSubmatch(kernel + " ")
+	if m == nil {
+		return ""
+	}
+	return m[1] + "." + m[2] + "." + m[3]
+}
+
+// selectTarballs returns the image tarballs to load into the daemon, so mismatched images are rejected rather than
+// loaded and crash-looping. Packages may bundle images for several platforms, e.g. for Linux and Windows
+// containers: tarballs the host cannot run are skipped unless the compose file in dir references their images, which
+// is an error wrapping ErrPlatformMismatch, as are services whose platform the host cannot run. The checks are
+// skipped if the platform of the host is unknown.
+func (d Daemon) selectTarballs(ctx context.Context, dir string, tarballs []string) ([]string, error) {
+	host, err := d.Platform(ctx)
+	if err != nil {
+		return tarballs, nil
+	}
+	var referenced map[string]bool
+	if files := ComposeFiles(dir); len(files) > 0 {
+		services, err := composeServices(dir, files)
+		if err != nil {
+			return nil, err
+		}
+		referenced = make(map[string]bool)
+		for name, service := range services {
+			if service.Platform != "" && !strings.Contains(service.Platform, "$") {
+				target, err := platform.Parse(service.Platform)
+				if err != nil {
+					return nil, fmt.Errorf("service %s: invalid platform: %w", name, err)
+				}
+				if !runsOn(host, target) {
+					return nil, fmt.Errorf("service %s: %w: service is for %s, the host is %s", name, ErrPlatformMismatch, platformString(target), platformString(host))
+				}
+			}
+			referenced[service.Image] = true
+		}
+	}
+	var selected []string
+	for _, file := range tarballs {
+		err := checkTarballPlatform(file, host)
+		if errors.Is(err, ErrPlatformMismatch) && referenced != nil && !referencesImages(file, referenced) {
+			log.Printf("%s: skipping load, %s", filepath.Base(file), err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		selected = append(selected, file)
+	}
+	return selected, nil
+}
+
+// referencesImages reports whether one of the images of the tarball is referenced by its tag or ID.
+func referencesImages(file string, references map[string]bool) bool {
+	images, err := tarballImages(file)
+	if err != nil {
+		return true
+	}
+	for _, img := range images {
+		if references[img.ID()] || slices.ContainsFunc(img.RepoTags, func(tag string) bool { return references[tag] }) {
+			return true
+		}
+	}
+	return false
+}
+
+// runsOn reports whether the host can run images of the target platform. Besides the platforms considered
+// compatible by regclient, 64-bit hosts run the 32-bit images of their architecture. Windows hosts run Windows
+// images of their version, and those of older versions with Hyper-V isolation, but no Linux images: Docker runs
+// either Windows or Linux containers, and reports the Linux VM as host for the latter.
+func runsOn(host, target platform.Platform) bool {
+	if host.OS == "windows" {
+		return target.OS == "windows" && host.Architecture == target.Architecture &&
+			(host.OSVersion == "" || target.OSVersion == "" || windowsBuild(target.OSVersion) <= windowsBuild(host.OSVersion))
+	}
+	if platform.Compatible(host, target) {
+		return true
+	}
+	return host.OS == target.OS &&
+		(host.Architecture == "arm64" && target.Architecture == "arm" ||
+			host.Architecture == "amd64" && target.Architecture == "386")
+}
+
+// platformString formats the platform including the version of Windows, e.g. windows/amd64 10.0.17763.
+func platformString(p platform.Platform) string {
+	if p.OS == "windows" && p.OSVersion != "" {
+		return p.String() + " " + p.OSVersion
+	}
+	return p.String()
+}
+
+// windowsBuild returns the build number of a Windows version, e.g. 17763 for 10.0.17763.1234.
+func windowsBuild(version string) int {
+	parts := strings.Split(version, ".")
+	if len(parts) < 3 {
+		return 0
+	}
+	build, _ := strconv.Atoi(parts[2])
+	return build
+}
+
+// tarballDescriptor is a descriptor of the OCI layout of an image tarball.
+type tarballDescriptor struct {
+	MediaType string             `json:"mediaType"`
+	Digest    string             `json:"digest"`
+	Platform  *platform.Platform `json:"platform,omitempty"`
+}
+
+// blob returns the name of the descriptor's blob in the tarball.
+func (desc tarballDescriptor) blob() string {
+	algorithm, encoded, _ := strings.Cut(desc.Digest, ":")
+	return path.Join("blobs", algorithm, encoded)
+}
+
+// checkTarballPlatform checks that the host can run each image of the tarball. For multi-arch images, one of the
+// platforms must match, which the daemon selects when loading the tarball. Images whose platform cannot be
+// determined are accepted.
+func checkTarballPlatform(file string, host platform.Platform) error {
+	metadata, err := tarballMetadata(file)
+	if err != nil {
+		return err
+	}
+	var images [][]platform.Platform
+	if b, found := metadata["index.json"]; found {
+		var index struct {
+			Manifests []tarballDescriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(b, &index); err != nil {
+			return fmt.Errorf("invalid index.json: %w", err)
+		}
+		for _, desc := range index.Manifests {
+			images = append(images, descriptorPlatforms(metadata, desc, 0))
+		}
+	}
+	// older versions of docker save write the manifest.json only
+	if b, found := metadata["manifest.json"]; found && !slices.ContainsFunc(images, func(p []platform.Platform) bool { return len(p) > 0 }) {
+		var tarball []tarballImage
+		if err := json.Unmarshal(b, &tarball); err != nil {
+			return fmt.Errorf("invalid manifest.json: %w", err)
+		}
+		for _, img := range tarball {
+			if p, ok := configPlatform(metadata[path.Clean(img.Config)]); ok {
+				images = append(images, []platform.Platform{p})
+			}
+		}
+	}
+	for _, platforms := range images {
+		if len(platforms) == 0 || slices.ContainsFunc(platforms, func(p platform.Platform) bool { return runsOn(host, p) }) {
+			continue
+		}
+		var available []string
+		for _, p := range platforms {
+			available = append(available, platformString(p))
+		}
+		slices.Sort(available)
+		return fmt.Errorf("%w: image is for %s, the host is %s", ErrPlatformMismatch, strings.Join(slices.Compact(available), ", "), platformString(host))
+	}
+	return nil
+}
+
+// descriptorPlatforms returns the platforms of the image of the descriptor, resolving nested indexes. Manifests
+// whose blobs are missing from the tarball cannot be loaded and are ignored, as are attestations.
+func descriptorPlatforms(metadata map[string][]byte, desc tarballDescriptor, depth int) []platform.Platform {
+	b, found := metadata[desc.blob()]
+	if !found || depth > 2 {
+		return nil
+	}
+	var content struct {
+		Manifests []tarballDescriptor `json:"manifests"`
+		Config    *tarballDescriptor  `json:"config"`
+	}
+	if err := json.Unmarshal(b, &content); err != nil {
+		return nil
+	}
+	if content.Config != nil {
+		if p, ok := configPlatform(metadata[content.Config.blob()]); ok {
+			return []platform.Platform{p}
+		}
+		if desc.Platform != nil && desc.Platform.OS != "unknown" {
+			return []platform.Platform{*desc.Platform}
+		}
+		return nil
+	}
+	var platforms []platform.Platform
+	for _, child := range content.Manifests {
+		if child.Platform != nil && child.Platform.OS == "unknown" {
+			continue
+		}
+		platforms = append(platforms, descriptorPlatforms(metadata, child, depth+1)...)
+	}
+	return platforms
+}
+
+// configPlatform returns the platform of an image config.
+func configPlatform(config []byte) (platform.Platform, bool) {
+	var p platform.Platform
+	if len(config) == 0 || json.Unmarshal(config, &p) != nil || p.OS == "" || p.Architecture == "" || p.OS == "unknown" {
+		return platform.Platform{}, false
+	}
+	return p, true
+}
+
+// tarballMetadata reads the manifest.json, the index.json and the small blobs, i.e. manifests, indexes and configs,
+// of an image tarball. Layers are skipped.
+func tarballMetadata(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	metadata := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return metadata, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || hdr.Size > maxTarballMetadata {
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") && !strings.HasPrefix(name, "blobs/") {
+			continue
+		}
+		// layers are not JSON, which is told by their first byte
+		first := make([]byte, 1)
+		if _, err := io.ReadFull(tr, first); err != nil || first[0] != '{' && first[0] != '[' {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		metadata[name] = append(first, b...)
+	}
+}
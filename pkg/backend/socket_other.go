@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build !unix
+
+package backend
+
+// rootlessSocket returns the empty string, as rootless daemons are specific to Linux.
+func rootlessSocket() string {
+	return ""
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build unix
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// systemSocket is the socket of the system-wide Docker daemon.
+const systemSocket = "/var/run/docker.sock"
+
+// rootlessSocket returns the socket of the rootless Docker or Podman daemon of the user, in $XDG_RUNTIME_DIR, if the
+// watcher runs unprivileged and may not use the system-wide daemon, e.g. as member of the docker group.
+func rootlessSocket() string {
+	if os.Geteuid() == 0 || unix.Access(systemSocket, unix.W_OK) == nil {
+		return ""
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = filepath.Join("/run/user", strconv.Itoa(os.Getuid()))
+	}
+	for _, socket := range []string{filepath.Join(runtimeDir, "docker.sock"), filepath.Join(runtimeDir, "podman", "podman.sock")} {
+		if info, err := os.Stat(socket); err == nil && info.Mode().Type() == os.ModeSocket {
+			return "unix://" + socket
+		}
+	}
+	return ""
+}
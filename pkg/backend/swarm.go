@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Swarm runs deployments as Docker Swarm stacks named after their directory. Bundled image tarballs are loaded into
+// the daemon, i.e. the manager node; multi-node swarms need the images in a registry.
+type Swarm struct {
+	// Command invokes the docker CLI, defaults to docker.
+	Command []string
+	// Daemon is a manager of the swarm.
+	Daemon Daemon
+	// Purge selects the resources deleted on removal. By default, volumes and images are kept.
+	Purge Purge
+	// Timeouts limit the invocations of docker.
+	Timeouts Timeouts
+}
+
+var (
+	_ Backend     = (*Swarm)(nil)
+	_ ImagePruner = (*Swarm)(nil)
+)
+
+// stackLabel is set by docker stack deploy on all resources of a stack.
+const stackLabel = "com.docker.stack.namespace"
+
+func (s *Swarm) command(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	command := s.Command
+	if len(command) == 0 {
+		command = []string{"docker"}
+	}
+	cmd := newCommand(ctx, command, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), s.Daemon.Env()...)
+	return cmd
+}
+
+// run runs the docker CLI and returns its output.
+func (s *Swarm) run(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := s.command(ctx, dir, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// RuntimeVersion returns the version of the Docker daemon.
+func (s *Swarm) RuntimeVersion(ctx context.Context) (string, string, error) {
+	ctx, cancel := s.Timeouts.status(ctx)
+	defer cancel()
+	version, err := s.Daemon.Version(ctx)
+	return "docker", version, err
+}
+
+// WithDaemon returns a copy running the deployments on the daemon.
+func (s *Swarm) WithDaemon(d Daemon) Backend {
+	copied := *s
+	copied.Daemon = d
+	return &copied
+}
+
+func (s *Swarm) Load(ctx context.Context, dir string) error {
+	return s.Daemon.loadImages(ctx, dir)
+}
+
+func (s *Swarm) EnsureRunning(ctx context.Context, dir string) error {
+	ctx, cancel := s.Timeouts.start(ctx)
+	defer cancel()
+	status, err := s.Status(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if status == StatusRunning {
+		return nil
+	}
+
+	log.Printf("%s: deploying stack", filepath.Base(dir))
+	// unlike compose, stack deploy does not read the .env file
+	env, err := readEnvFile(path.Join(dir, ".env"))
+	if err != nil {
+		return err
+	}
+	args := []string{"stack", "deploy"}
+	for _, file := range ComposeFiles(dir) {
+		args = append(args, "--compose-file", file)
+	}
+	cmd := s.command(ctx, dir, append(args, "--prune", "--with-registry-auth", filepath.Base(dir))...)
+	cmd.Env = append(cmd.Env, env...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker stack deploy: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Stop removes the stack, keeping its volumes. Directories without compose file are ignored.
+func (s *Swarm) Stop(ctx context.Context, dir string) error {
+	ctx, cancel := s.Timeouts.stop(ctx)
+	defer cancel()
+	if FindComposeFile(dir) == "" {
+		return nil
+	}
+	_, err := s.run(ctx, dir, "stack", "rm", filepath.Base(dir))
+	return err
+}
+
+// Remove removes the stack including its volumes unless they are kept. Volumes and images are only removed from the
+// manager. Directories without compose file are ignored.
+func (s *Swarm) Remove(ctx context.Context, dir string) error {
+	ctx, cancel := s.Timeouts.stop(ctx)
+	defer cancel()
+	if err := s.Stop(ctx, dir); err != nil {
+		return err
+	}
+	if s.Purge.Volumes {
+		out, err := s.run(ctx, dir, "volume", "ls", "--quiet", "--filter", "label="+stackLabel+"="+filepath.Base(dir))
+		if err != nil {
+			return err
+		}
+		if volumes := strings.Fields(out); len(volumes) > 0 {
+			// volumes stay in use until the containers of the stack are gone
+			if _, err := s.run(ctx, dir, append([]string{"volume", "rm"}, volumes...)...); err != nil {
+				log.Printf("WARN: %s: failed to remove volumes: %s", filepath.Base(dir), err)
+			}
+		}
+	}
+	if s.Purge.Images {
+		images, err := deploymentImages(dir)
+		if err != nil {
+			return err
+		}
+		return s.Daemon.removeImages(ctx, images)
+	}
+	return nil
+}
+
+func (s *Swarm) Status(ctx context.Context, dir string) (Status, error) {
+	ctx, cancel := s.Timeouts.status(ctx)
+	defer cancel()
+	out, err := s.run(ctx, dir, "service", "ls", "--quiet", "--filter", "label="+stackLabel+"="+filepath.Base(dir))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(out) != "" {
+		return StatusRunning, nil
+	}
+	return StatusStopped, nil
+}
+
+// readEnvFile reads the variables of a dotenv file as written by the reconciler, ignoring a missing file.
+func readEnvFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s: invalid line %q", file, line)
+		}
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `$$`, `$`).Replace(value[1 : len(value)-1])
+		}
+		env = append(env, strings.TrimSpace(name)+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// Images lists the images of the compose file and the bundled tarballs.
+func (s *Swarm) Images(_ context.Context, dir string) ([]string, error) {
+	return deploymentImages(dir)
+}
+
+// RemoveImages removes the images from the manager unless containers use them. Images pulled by other nodes are kept.
+func (s *Swarm) RemoveImages(ctx context.Context, images []string) error {
+	return s.Daemon.removeImages(ctx, images)
+}
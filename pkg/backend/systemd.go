@@ -0,0 +1,305 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// quadletServices maps the Quadlet file types to the suffix of the generated service.
+var quadletServices = map[string]string{
+	".container": "",
+	".kube":      "",
+	".pod":       "-pod",
+	".volume":    "-volume",
+	".network":   "-network",
+	".image":     "-image",
+	".build":     "-build",
+}
+
+// unitTypes are the plain systemd units installed from deployments.
+var unitTypes = []string{".service", ".socket", ".timer", ".path"}
+
+// Systemd runs deployments consisting of systemd units or Podman Quadlet files (.container, .pod, .kube, .volume,
+// .network, .image, .build) in the top-level directory of the app. Quadlet files are copied to a subdirectory of
+// QuadletDir, plain units are linked with systemctl link. Bundled image tarballs are loaded with podman.
+type Systemd struct {
+	// User manages the units of the user's service manager instead of the system's.
+	User bool
+	// QuadletDir defaults to /etc/containers/systemd, or ~/.config/containers/systemd for User.
+	QuadletDir string
+	// Command invokes systemctl, defaults to systemctl.
+	Command []string
+	// PodmanCommand defaults to podman.
+	PodmanCommand []string
+	// Purge selects the resources deleted on removal. By default, volumes and images are kept.
+	Purge Purge
+	// Timeouts limit the invocations of systemctl and podman.
+	Timeouts Timeouts
+}
+
+var _ Backend = (*Systemd)(nil)
+
+// units lists the Quadlet files and plain units of the deployment.
+func (s *Systemd) units(dir string) (quadlets, units []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := path.Ext(entry.Name())
+		if _, found := quadletServices[ext]; found {
+			quadlets = append(quadlets, entry.Name())
+		} else if slices.Contains(unitTypes, ext) {
+			units = append(units, entry.Name())
+		}
+	}
+	return quadlets, units, nil
+}
+
+// services returns the units to start: the services generated for containers, pods and kube files, and the plain
+// units except services activated by a timer, socket or path unit.
+func (s *Systemd) services(quadlets, units []string) []string {
+	var services []string
+	for _, q := range quadlets {
+		ext := path.Ext(q)
+		if ext == ".container" || ext == ".kube" || ext == ".pod" {
+			services = append(services, strings.TrimSuffix(q, ext)+quadletServices[ext]+".service")
+		}
+	}
+	for _, u := range units {
+		name := strings.TrimSuffix(u, path.Ext(u))
+		if path.Ext(u) == ".service" && slices.ContainsFunc(units, func(other string) bool {
+			return other != u && strings.TrimSuffix(other, path.Ext(other)) == name
+		}) {
+			continue
+		}
+		services = append(services, u)
+	}
+	return services
+}
+
+func (s *Systemd) quadletDir(dir string) string {
+	base := s.QuadletDir
+	if base == "" {
+		base = "/etc/containers/systemd"
+		if s.User {
+			if home, err := os.UserHomeDir(); err == nil {
+				base = filepath.Join(home, ".config", "containers", "systemd")
+			}
+		}
+	}
+	return filepath.Join(base, "oci-watcher-"+filepath.Base(dir))
+}
+
+func (s *Systemd) systemctl(ctx context.Context, args ...string) (string, error) {
+	command := s.Command
+	if len(command) == 0 {
+		command = []string{"systemctl"}
+	}
+	if s.User {
+		args = append([]string{"--user"}, args...)
+	}
+	return run(ctx, command, args...)
+}
+
+func run(ctx context.Context, command []string, args ...string) (string, error) {
+	cmd := newCommand(ctx, command, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w: %s", filepath.Base(command[0]), strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (s *Systemd) podman() []string {
+	if len(s.PodmanCommand) == 0 {
+		return []string{"podman"}
+	}
+	return s.PodmanCommand
+}
+
+// Load loads all *.tar files in dir with podman.
+func (s *Systemd) Load(ctx context.Context, dir string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".tar") {
+			if _, err := run(ctx, s.podman(), "load", "--input", file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// EnsureRunning installs the units and starts them unless they are active already.
+func (s *Systemd) EnsureRunning(ctx context.Context, dir string) error {
+	ctx, cancel := s.Timeouts.start(ctx)
+	defer cancel()
+	status, err := s.Status(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if status == StatusRunning {
+		return nil
+	}
+	quadlets, units, err := s.units(dir)
+	if err != nil {
+		return err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%s: starting units", filepath.Base(dir))
+	if len(quadlets) > 0 {
+		quadletDir := s.quadletDir(dir)
+		if err := os.MkdirAll(quadletDir, 0o755); err != nil {
+			return err
+		}
+		for _, q := range quadlets {
+			b, err := os.ReadFile(filepath.Join(absDir, q))
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(quadletDir, q), b, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	for _, u := range units {
+		if _, err := s.systemctl(ctx, "link", filepath.Join(absDir, u)); err != nil {
+			return err
+		}
+	}
+	// generates the services of the Quadlet files
+	if _, err := s.systemctl(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	// generated services are started on boot via the [Install] section of Quadlet files and cannot be enabled
+	for _, u := range units {
+		if _, err := s.systemctl(ctx, "enable", u); err != nil {
+			return err
+		}
+	}
+	_, err = s.systemctl(ctx, append([]string{"start"}, s.services(quadlets, units)...)...)
+	return err
+}
+
+// Stop stops and uninstalls the units, which are installed again when starting the deployment. Directories without
+// units are ignored.
+func (s *Systemd) Stop(ctx context.Context, dir string) error {
+	ctx, cancel := s.Timeouts.stop(ctx)
+	defer cancel()
+	quadlets, units, err := s.units(dir)
+	if err != nil || len(quadlets)+len(units) == 0 {
+		return nil
+	}
+	// units which failed to install are not loaded
+	if _, err := s.systemctl(ctx, append([]string{"stop"}, s.services(quadlets, units)...)...); err != nil {
+		log.Printf("WARN: %s: %s", filepath.Base(dir), err)
+	}
+	if len(units) > 0 {
+		// disabling removes the links as well
+		if _, err := s.systemctl(ctx, append([]string{"disable"}, units...)...); err != nil {
+			log.Printf("WARN: %s: %s", filepath.Base(dir), err)
+		}
+	}
+	if err := os.RemoveAll(s.quadletDir(dir)); err != nil {
+		return err
+	}
+	_, err = s.systemctl(ctx, "daemon-reload")
+	return err
+}
+
+// Remove stops and uninstalls the units and removes the volumes of Quadlet .volume files unless they are kept, and
+// the images of .container files if requested.
+func (s *Systemd) Remove(ctx context.Context, dir string) error {
+	ctx, cancel := s.Timeouts.stop(ctx)
+	defer cancel()
+	if err := s.Stop(ctx, dir); err != nil {
+		return err
+	}
+	quadlets, _, _ := s.units(dir)
+	for _, q := range quadlets {
+		var args []string
+		switch path.Ext(q) {
+		case ".volume":
+			if !s.Purge.Volumes {
+				continue
+			}
+			name := quadletKey(filepath.Join(dir, q), "VolumeName")
+			if name == "" {
+				name = "systemd-" + strings.TrimSuffix(q, ".volume")
+			}
+			args = []string{"volume", "rm", "--force", name}
+		case ".container":
+			image := quadletKey(filepath.Join(dir, q), "Image")
+			if !s.Purge.Images || image == "" {
+				continue
+			}
+			args = []string{"image", "rm", image}
+		default:
+			continue
+		}
+		if _, err := run(ctx, s.podman(), args...); err != nil {
+			log.Printf("WARN: %s: %s", filepath.Base(dir), err)
+		}
+	}
+	return nil
+}
+
+// Status reports the deployment as running if all units to start are active.
+func (s *Systemd) Status(ctx context.Context, dir string) (Status, error) {
+	ctx, cancel := s.Timeouts.status(ctx)
+	defer cancel()
+	quadlets, units, err := s.units(dir)
+	if err != nil {
+		return "", err
+	}
+	services := s.services(quadlets, units)
+	if len(services) == 0 {
+		return StatusStopped, nil
+	}
+	// is-active exits non-zero unless all units are active
+	if _, err := s.systemctl(ctx, append([]string{"is-active", "--quiet"}, services...)...); err != nil {
+		return StatusStopped, nil
+	}
+	return StatusRunning, nil
+}
+
+// quadletKey returns the value of the key in the Quadlet file, empty if it is missing.
+func quadletKey(file, key string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if k, v, found := strings.Cut(scanner.Text(), "="); found && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
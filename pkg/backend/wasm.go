@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// SpinManifest is the manifest of Spin applications.
+const SpinManifest = "spin.toml"
+
+// Wasm runs WebAssembly components as systemd services: Spin applications with spin up if the app has a spin.toml,
+// otherwise the single *.wasm file in its top-level directory with wasmtime serve. The services read the parameters
+// of the deployment from its .env file.
+type Wasm struct {
+	// Systemd manages the generated services.
+	Systemd *Systemd
+	// SpinCommand defaults to spin, WasmtimeCommand to wasmtime.
+	SpinCommand     []string
+	WasmtimeCommand []string
+}
+
+var _ Backend = (*Wasm)(nil)
+
+func (w *Wasm) unitFile(dir string) string {
+	return filepath.Join(dir, "oci-watcher-wasm-"+filepath.Base(dir)+".service")
+}
+
+// execStart returns the command line running the component.
+func (w *Wasm) execStart(dir string) ([]string, error) {
+	if fsutil.FileExists(filepath.Join(dir, SpinManifest)) {
+		command := w.SpinCommand
+		if len(command) == 0 {
+			command = []string{"spin"}
+		}
+		return append(slices.Clone(command), "up", "--from", filepath.Join(dir, SpinManifest)), nil
+	}
+	modules, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) != 1 {
+		return nil, fmt.Errorf("%s: expected %s or a single *.wasm file, found %d", filepath.Base(dir), SpinManifest, len(modules))
+	}
+	command := w.WasmtimeCommand
+	if len(command) == 0 {
+		command = []string{"wasmtime"}
+	}
+	return append(slices.Clone(command), "serve", "-S", "cli", modules[0]), nil
+}
+
+// writeUnit generates the service of the component.
+func (w *Wasm) writeUnit(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	command, err := w.execStart(absDir)
+	if err != nil {
+		return err
+	}
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = strconv.Quote(arg)
+	}
+	wantedBy := "multi-user.target"
+	if w.Systemd.User {
+		wantedBy = "default.target"
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=WebAssembly component %s deployed by oci-watcher
+
+[Service]
+WorkingDirectory=%s
+EnvironmentFile=-%s
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=%s
+`, filepath.Base(dir), absDir, filepath.Join(absDir, ".env"), strings.Join(quoted, " "), wantedBy)
+	return os.WriteFile(w.unitFile(dir), []byte(unit), 0o644)
+}
+
+// Load does nothing, as the components are part of the app.
+func (w *Wasm) Load(context.Context, string) error {
+	return nil
+}
+
+// EnsureRunning generates and starts the service unless it is active already.
+func (w *Wasm) EnsureRunning(ctx context.Context, dir string) error {
+	if !fsutil.FileExists(w.unitFile(dir)) {
+		if err := w.writeUnit(dir); err != nil {
+			return err
+		}
+	}
+	return w.Systemd.EnsureRunning(ctx, dir)
+}
+
+func (w *Wasm) Stop(ctx context.Context, dir string) error {
+	return w.Systemd.Stop(ctx, dir)
+}
+
+func (w *Wasm) Remove(ctx context.Context, dir string) error {
+	return w.Systemd.Remove(ctx, dir)
+}
+
+func (w *Wasm) Status(ctx context.Context, dir string) (Status, error) {
+	return w.Systemd.Status(ctx, dir)
+}
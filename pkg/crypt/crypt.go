@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package crypt encrypts packages at rest and decrypts them on the device.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// Format is the encryption format of a package.
+type Format string
+
+const (
+	Plain Format = ""
+	Age   Format = "age"
+	GPG   Format = "gpg"
+)
+
+// Detect returns the format of the content, which must be buffered as it is peeked at. Unencrypted packages are
+// gzip-compressed tarballs.
+func Detect(br *bufio.Reader) (Format, error) {
+	head, err := br.Peek(40)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return Plain, err
+	}
+	switch {
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		return Plain, nil
+	case bytes.HasPrefix(head, []byte("age-encryption.org/")), bytes.HasPrefix(head, []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		return Age, nil
+	case bytes.HasPrefix(head, []byte("-----BEGIN PGP MESSAGE-----")):
+		return GPG, nil
+	case len(head) > 0 && head[0]&0x80 != 0:
+		// binary OpenPGP packet
+		return GPG, nil
+	}
+	return Plain, nil
+}
+
+// Keys decrypts packages with device-local private keys. Packages which are not encrypted pass through unchanged.
+type Keys struct {
+	// AgeIdentities are identity files for the age CLI (https://age-encryption.org). These include plugin identities,
+	// e.g. for keys held in a TPM (age-plugin-tpm) or a KMS.
+	AgeIdentities []string
+	// GPGKeys are files with armored GPG private keys.
+	GPGKeys []string
+	// GPGPassphrase unlocks protected GPG keys.
+	GPGPassphrase []byte
+	// AgeCommand defaults to age.
+	AgeCommand []string
+}
+
+// Decrypt returns the plaintext of the package. Encrypted packages are decrypted into a temporary file, so their
+// integrity is checked before extraction starts; the file is removed on Close. A nil Keys rejects encrypted packages.
+func (k *Keys) Decrypt(ctx context.Context, pkg io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(pkg)
+	format, err := Detect(br)
+	if err != nil {
+		return nil, err
+	}
+	if format == Plain {
+		return io.NopCloser(br), nil
+	}
+	if k == nil || (format == Age && len(k.AgeIdentities) == 0) || (format == GPG && len(k.GPGKeys) == 0) {
+		return nil, fmt.Errorf("package is %s-encrypted, but no %s decryption key is configured", format, format)
+	}
+	log.Printf("Decrypting %s-encrypted package", format)
+
+	f, err := os.CreateTemp("", "package-")
+	if err != nil {
+		return nil, err
+	}
+	plaintext := &tempFile{f}
+	err = k.decrypt(ctx, format, br, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		plaintext.Close()
+		return nil, fmt.Errorf("failed to decrypt package: %w", err)
+	}
+	return plaintext, nil
+}
+
+// DecryptBytes decrypts small content, such as credentials, in memory.
+func (k *Keys) DecryptBytes(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(ciphertext))
+	format, err := Detect(br)
+	if err != nil {
+		return nil, err
+	}
+	if format == Plain {
+		return nil, errors.New("content is not encrypted")
+	}
+	var plaintext bytes.Buffer
+	if err := k.decrypt(ctx, format, br, &plaintext); err != nil {
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+// EncryptBytes encrypts the content for the keys themselves, i.e. the recipients of the age identities or the GPG
+// keys, so it can only be decrypted with them.
+func (k *Keys) EncryptBytes(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var ciphertext bytes.Buffer
+	if len(k.AgeIdentities) > 0 {
+		args := append(slices.Clone(k.ageCommand()[1:]), "--encrypt")
+		for _, identity := range k.AgeIdentities {
+			args = append(args, "--identity", identity)
+		}
+		if err := k.runAge(ctx, args, bytes.NewReader(plaintext), &ciphertext); err != nil {
+			return nil, err
+		}
+		return ciphertext.Bytes(), nil
+	}
+	keyring, err := k.gpgKeyring()
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring) == 0 {
+		return nil, errors.New("no encryption key configured")
+	}
+	w, err := openpgp.Encrypt(&ciphertext, keyring, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return ciphertext.Bytes(), nil
+}
+
+func (k *Keys) decrypt(ctx context.Context, format Format, ciphertext *bufio.Reader, plaintext io.Writer) error {
+	if format == Age {
+		return k.decryptAge(ctx, ciphertext, plaintext)
+	}
+	return k.decryptGPG(ciphertext, plaintext)
+}
+
+func (k *Keys) ageCommand() []string {
+	if len(k.AgeCommand) == 0 {
+		return []string{"age"}
+	}
+	return k.AgeCommand
+}
+
+func (k *Keys) runAge(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	cmd := exec.CommandContext(ctx, k.ageCommand()[0], args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (k *Keys) decryptAge(ctx context.Context, ciphertext io.Reader, plaintext io.Writer) error {
+	args := append(slices.Clone(k.ageCommand()[1:]), "--decrypt")
+	for _, identity := range k.AgeIdentities {
+		args = append(args, "--identity", identity)
+	}
+	return k.runAge(ctx, args, ciphertext, plaintext)
+}
+
+// gpgKeyring reads and unlocks the GPG keys.
+func (k *Keys) gpgKeyring() (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, file := range k.GPGKeys {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, e := range keys {
+			if err := e.DecryptPrivateKeys(k.GPGPassphrase); err != nil {
+				return nil, fmt.Errorf("%s: failed to unlock private key: %w", file, err)
+			}
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring, nil
+}
+
+func (k *Keys) decryptGPG(ciphertext *bufio.Reader, plaintext io.Writer) error {
+	keyring, err := k.gpgKeyring()
+	if err != nil {
+		return err
+	}
+
+	var msg io.Reader = ciphertext
+	if head, _ := ciphertext.Peek(5); string(head) == "-----" {
+		block, err := armor.Decode(ciphertext)
+		if err != nil {
+			return err
+		}
+		msg = block.Body
+	}
+	md, err := openpgp.ReadMessage(msg, keyring, nil, nil)
+	if err != nil {
+		return err
+	}
+	// reading to the end checks the integrity of the message
+	_, err = io.Copy(plaintext, md.UnverifiedBody)
+	return err
+}
+
+// Encrypt encrypts the package for the recipients, which are either files with armored GPG public keys or age
+// recipients (see age(1)), but not both.
+func Encrypt(ctx context.Context, plaintext io.Reader, ciphertext io.Writer, gpgRecipients, ageRecipients []string) error {
+	if (len(gpgRecipients) == 0) == (len(ageRecipients) == 0) {
+		return errors.New("either GPG or age recipients are required")
+	}
+	if len(ageRecipients) > 0 {
+		args := []string{"--encrypt"}
+		for _, recipient := range ageRecipients {
+			args = append(args, "--recipient", recipient)
+		}
+		cmd := exec.CommandContext(ctx, "age", args...)
+		cmd.Stdin, cmd.Stdout = plaintext, ciphertext
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("age: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	var to openpgp.EntityList
+	for _, file := range gpgRecipients {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		to = append(to, keys...)
+	}
+	w, err := openpgp.Encrypt(ciphertext, to, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, plaintext); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	_ = os.Remove(f.Name())
+	return err
+}
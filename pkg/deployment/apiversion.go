@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentAPIVersion is the apiVersion of the internal model (ApplicationDeployment).
+const CurrentAPIVersion = "application.margo.org/v1alpha1"
+
+// apiVersionConverters upgrade a raw document of the given apiVersion to the shape of the next newer version. They
+// are chained until CurrentAPIVersion is reached, so supporting a new version only requires converting the previous
+// one.
+var apiVersionConverters = map[string]struct {
+	next    string
+	convert func(doc map[string]any) error
+}{
+	"margo.org/v1-alpha1": {next: CurrentAPIVersion, convert: convertV1Alpha1},
+}
+
+// Decode decodes a desired-state document of any supported apiVersion into the internal model.
+func Decode(b []byte) (*ApplicationDeployment, error) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, errors.New("empty document")
+	}
+	apiVersion, _ := doc["apiVersion"].(string)
+	for apiVersion != CurrentAPIVersion {
+		conv, found := apiVersionConverters[apiVersion]
+		if !found {
+			if apiVersion == "" {
+				return nil, errors.New("apiVersion: missing")
+			}
+			return nil, fmt.Errorf("apiVersion: unsupported version %q (supported: %s)", apiVersion, strings.Join(SupportedAPIVersions(), ", "))
+		}
+		if err := conv.convert(doc); err != nil {
+			return nil, fmt.Errorf("converting from %s: %w", apiVersion, err)
+		}
+		apiVersion = conv.next
+		doc["apiVersion"] = apiVersion
+	}
+
+	b, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var appDeployment ApplicationDeployment
+	if err := yaml.Unmarshal(b, &appDeployment); err != nil {
+		return nil, err
+	}
+	return &appDeployment, nil
+}
+
+// SupportedAPIVersions lists all apiVersions accepted by Decode.
+func SupportedAPIVersions() []string {
+	versions := []string{CurrentAPIVersion}
+	return append(versions, sortedKeys(apiVersionConverters)...)
+}
+
+// convertV1Alpha1 upgrades the pre-release schema, which used `docker-compose` as the deployment profile type.
+func convertV1Alpha1(doc map[string]any) error {
+	spec, _ := doc["spec"].(map[string]any)
+	profile, _ := spec["deploymentProfile"].(map[string]any)
+	if profile != nil && profile["type"] == "docker-compose" {
+		profile["type"] = "compose"
+	}
+	return nil
+}
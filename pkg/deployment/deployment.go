@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package deployment contains the Margo ApplicationDeployment model and operations on desired-state documents.
+package deployment
+
+import (
+	"bytes"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ApplicationDeployment struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Annotations map[string]string `yaml:"annotations"`
+		Name        string            `yaml:"name"`
+		Namespace   string            `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Spec struct {
+		DeploymentProfile struct {
+			Type       string      `yaml:"type"`
+			Components []Component `yaml:"components"`
+		} `yaml:"deploymentProfile"`
+		Parameters map[string]struct {
+			Value string `yaml:"value"`
+			// SecretRef references a secret resolved on the device instead of a plaintext value, e.g.
+			// vault:secret/data/db#password. The pointers of its targets are environment variable names.
+			SecretRef string `yaml:"secretRef"`
+			Targets   []struct {
+				Pointer    string   `yaml:"pointer"`
+				Components []string `yaml:"components"`
+			} `yaml:"targets"`
+		} `yaml:"parameters"`
+	} `yaml:"spec"`
+}
+
+type Component struct {
+	Name        string            `yaml:"name"`
+	Annotations map[string]string `yaml:"annotations"`
+	Properties  struct {
+		KeyLocation     string `yaml:"keyLocation"`
+		PackageLocation string `yaml:"packageLocation"`
+		// Deltas are optional patches leading from earlier packages to the package, which devices having one of them
+		// download instead of the package.
+		Deltas []Delta `yaml:"deltas"`
+	} `yaml:"properties"`
+}
+
+// Delta leads from the package with the digest From to the package of the component, see
+// registry.Client.DownloadDelta.
+type Delta struct {
+	From     string `yaml:"from"`
+	Location string `yaml:"location"`
+}
+
+// AnnotationPrefix is used for all annotations interpreted by the watcher.
+const AnnotationPrefix = "watcher.margo.org/"
+
+// Annotation returns the value of the watcher annotation on the component, falling back to the deployment's metadata.
+func (d *ApplicationDeployment) Annotation(c Component, key string) string {
+	if v, found := c.Annotations[AnnotationPrefix+key]; found {
+		return v
+	}
+	return d.Metadata.Annotations[AnnotationPrefix+key]
+}
+
+// SplitDocuments decodes all documents of a multi-document YAML stream.
+func SplitDocuments(b []byte) ([]any, error) {
+	var docs []any
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var doc any
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// DocumentIdentity returns kind and metadata.name of a raw document.
+func DocumentIdentity(doc any) (kind, name string) {
+	m, _ := doc.(map[string]any)
+	kind, _ = m["kind"].(string)
+	metadata, _ := m["metadata"].(map[string]any)
+	name, _ = metadata["name"].(string)
+	return kind, name
+}
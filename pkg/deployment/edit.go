@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package deployment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SetComponent points a component of the named deployment at the given key and package, adding the component and
+// the deployment if they are missing. If name is empty, the only document of the desired state is used. Deltas of the
+// component are removed, as they lead to the previous package.
+func SetComponent(docs []any, name, component, keyLocation, packageLocation string) ([]any, error) {
+	var doc map[string]any
+	for _, d := range docs {
+		if _, docName := DocumentIdentity(d); docName == name || (name == "" && len(docs) == 1) {
+			doc, _ = d.(map[string]any)
+			break
+		}
+	}
+	if doc == nil {
+		if name == "" {
+			if len(docs) > 1 {
+				return nil, errors.New("desired state has several deployments, the deployment name is required")
+			}
+			name = component
+		}
+		doc = map[string]any{
+			"apiVersion": CurrentAPIVersion,
+			"kind":       "ApplicationDeployment",
+			"metadata":   map[string]any{"name": name},
+			"spec": map[string]any{
+				"deploymentProfile": map[string]any{"type": "compose"},
+			},
+		}
+		docs = append(docs, doc)
+	}
+
+	profile, err := childMap(doc, "spec", "deploymentProfile")
+	if err != nil {
+		return nil, err
+	}
+	components, _ := profile["components"].([]any)
+	var c map[string]any
+	for _, item := range components {
+		if m, ok := item.(map[string]any); ok && m["name"] == component {
+			c = m
+			break
+		}
+	}
+	if c == nil {
+		c = map[string]any{"name": component}
+		profile["components"] = append(components, c)
+	}
+	properties, err := childMap(c, "properties")
+	if err != nil {
+		return nil, err
+	}
+	properties["keyLocation"] = keyLocation
+	properties["packageLocation"] = packageLocation
+	delete(properties, "deltas")
+	return docs, nil
+}
+
+// childMap returns the nested map at the path, creating missing levels.
+func childMap(m map[string]any, path ...string) (map[string]any, error) {
+	for _, key := range path {
+		switch child := m[key].(type) {
+		case map[string]any:
+			m = child
+		case nil:
+			created := make(map[string]any)
+			m[key], m = created, created
+		default:
+			return nil, fmt.Errorf("%s: expected a mapping", key)
+		}
+	}
+	return m, nil
+}
+
+// JoinDocuments encodes the documents as multi-document YAML stream.
+func JoinDocuments(docs []any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
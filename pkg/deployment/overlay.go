@@ -0,0 +1,300 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MergeOverlay applies an overlay document to base using strategic-merge semantics: maps are merged recursively,
+// lists of named objects (such as components) are merged by name, and all other values are replaced. A null value
+// removes a key, and a list item containing `$patch: delete` removes the item with the same name.
+func MergeOverlay(base, overlay any) any {
+	switch o := overlay.(type) {
+	case map[string]any:
+		b, ok := base.(map[string]any)
+		if !ok {
+			return StripDirectives(o)
+		}
+		for k, v := range o {
+			if v == nil {
+				delete(b, k)
+				continue
+			}
+			if existing, found := b[k]; found {
+				b[k] = MergeOverlay(existing, v)
+			} else {
+				b[k] = StripDirectives(v)
+			}
+		}
+		return b
+	case []any:
+		b, ok := base.([]any)
+		if !ok || !isNamedList(b) || !isNamedList(o) {
+			return StripDirectives(o)
+		}
+		for _, item := range o {
+			m := item.(map[string]any)
+			idx := -1
+			for i, existing := range b {
+				if existing.(map[string]any)["name"] == m["name"] {
+					idx = i
+					break
+				}
+			}
+			switch {
+			case m["$patch"] == "delete":
+				if idx >= 0 {
+					b = append(b[:idx], b[idx+1:]...)
+				}
+			case idx >= 0:
+				b[idx] = MergeOverlay(b[idx], m)
+			default:
+				b = append(b, StripDirectives(m))
+			}
+		}
+		return b
+	default:
+		return overlay
+	}
+}
+
+func isNamedList(l []any) bool {
+	for _, item := range l {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return false
+		}
+		if _, ok := m["name"].(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// StripDirectives removes merge directives from values which are added rather than merged.
+func StripDirectives(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		delete(t, "$patch")
+		for k, child := range t {
+			t[k] = StripDirectives(child)
+		}
+	case []any:
+		for i, child := range t {
+			t[i] = StripDirectives(child)
+		}
+	}
+	return v
+}
+
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from"`
+	Value any    `json:"value"`
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON patch to doc.
+func ApplyJSONPatch(doc any, patch []byte) (any, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+	var err error
+	for i, op := range ops {
+		switch op.Op {
+		case "add":
+			doc, err = patchAdd(doc, op.Path, op.Value)
+		case "remove":
+			doc, _, err = patchRemove(doc, op.Path)
+		case "replace":
+			if doc, _, err = patchRemove(doc, op.Path); err == nil {
+				doc, err = patchAdd(doc, op.Path, op.Value)
+			}
+		case "move":
+			var v any
+			if doc, v, err = patchRemove(doc, op.From); err == nil {
+				doc, err = patchAdd(doc, op.Path, v)
+			}
+		case "copy":
+			var v any
+			if v, err = patchGet(doc, op.From); err == nil {
+				doc, err = patchAdd(doc, op.Path, DeepCopy(v))
+			}
+		case "test":
+			var v any
+			if v, err = patchGet(doc, op.Path); err == nil && !reflect.DeepEqual(normalizeJSON(v), normalizeJSON(op.Value)) {
+				err = fmt.Errorf("test failed for %s", op.Path)
+			}
+		default:
+			err = fmt.Errorf("unsupported operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func patchGet(doc any, pointer string) (any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, t := range tokens {
+		switch c := cur.(type) {
+		case map[string]any:
+			v, found := c[t]
+			if !found {
+				return nil, fmt.Errorf("path %s not found", pointer)
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, fmt.Errorf("path %s not found", pointer)
+			}
+			cur = c[i]
+		default:
+			return nil, fmt.Errorf("path %s not found", pointer)
+		}
+	}
+	return cur, nil
+}
+
+// patchAdd sets the value at pointer. Since inserting into a list may reallocate it, the (possibly new) root is
+// returned.
+func patchAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parentPtr := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := patchGet(doc, parentPtr)
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+		return doc, nil
+	case []any:
+		i := len(p)
+		if last != "-" {
+			if i, err = strconv.Atoi(last); err != nil || i < 0 || i > len(p) {
+				return nil, fmt.Errorf("invalid index in %s", pointer)
+			}
+		}
+		p = append(p[:i], append([]any{value}, p[i:]...)...)
+		return patchSet(doc, parentPtr, p)
+	default:
+		return nil, fmt.Errorf("path %s not found", pointer)
+	}
+}
+
+// patchSet overwrites the existing value at pointer, e.g. a list which was reallocated. Unlike patchAdd, it never
+// inserts into a list. The (possibly new) root is returned.
+func patchSet(doc any, pointer string, value any) (any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := patchGet(doc, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+	case []any:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(p) {
+			return nil, fmt.Errorf("path %s not found", pointer)
+		}
+		p[i] = value
+	default:
+		return nil, fmt.Errorf("path %s not found", pointer)
+	}
+	return doc, nil
+}
+
+func patchRemove(doc any, pointer string) (any, any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parentPtr := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := patchGet(doc, parentPtr)
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		v, found := p[last]
+		if !found {
+			return nil, nil, fmt.Errorf("path %s not found", pointer)
+		}
+		delete(p, last)
+		return doc, v, nil
+	case []any:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(p) {
+			return nil, nil, fmt.Errorf("path %s not found", pointer)
+		}
+		v := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		doc, err = patchSet(doc, parentPtr, p)
+		return doc, v, err
+	default:
+		return nil, nil, fmt.Errorf("path %s not found", pointer)
+	}
+}
+
+// DeepCopy returns a copy of a raw document.
+func DeepCopy(v any) any {
+	b, _ := json.Marshal(v)
+	var c any
+	_ = json.Unmarshal(b, &c)
+	return c
+}
+
+// normalizeJSON makes values decoded from YAML and JSON comparable (e.g. int vs. float64).
+func normalizeJSON(v any) any {
+	return DeepCopy(v)
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package deployment
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// ParseLabels parses labels of the form `key=value,key2=value2` and adds the built-in labels `arch`, `os` and
+// `hostname` unless they are set explicitly.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{
+		"arch": runtime.GOARCH,
+		"os":   runtime.GOOS,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		labels["hostname"] = hostname
+	}
+	for _, kv := range splitSelector(s) {
+		k, v, found := strings.Cut(kv, "=")
+		if !found || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid label %q", kv)
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
+// MatchSelector reports whether the labels satisfy the selector. The selector is a comma-separated list of
+// requirements, all of which must be met:
+//
+//	key=value, key==value, key!=value, key in (a,b), key notin (a,b), key, !key
+//
+// An empty selector matches everything.
+func MatchSelector(selector string, labels map[string]string) (bool, error) {
+	for _, req := range splitSelector(selector) {
+		ok, err := matchRequirement(req, labels)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchRequirement(req string, labels map[string]string) (bool, error) {
+	if k, v, found := strings.Cut(req, "!="); found {
+		return labels[strings.TrimSpace(k)] != strings.TrimSpace(v), nil
+	}
+	if k, v, found := strings.Cut(req, "=="); found {
+		return labels[strings.TrimSpace(k)] == strings.TrimSpace(v), nil
+	}
+	if k, v, found := strings.Cut(req, "="); found {
+		return labels[strings.TrimSpace(k)] == strings.TrimSpace(v), nil
+	}
+	if fields := strings.Fields(req); len(fields) >= 2 && (fields[1] == "in" || fields[1] == "notin") {
+		rest := strings.TrimSpace(strings.TrimSpace(req)[len(fields[0]):])
+		set := strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+			return false, fmt.Errorf("invalid selector requirement %q", req)
+		}
+		value, exists := labels[fields[0]]
+		member := false
+		for _, candidate := range strings.Split(set[1:len(set)-1], ",") {
+			if exists && strings.TrimSpace(candidate) == value {
+				member = true
+				break
+			}
+		}
+		if fields[1] == "in" {
+			return member, nil
+		}
+		return !member, nil
+	}
+	if key, negated := strings.CutPrefix(req, "!"); negated {
+		_, exists := labels[strings.TrimSpace(key)]
+		return !exists, nil
+	}
+	if strings.ContainsAny(req, " ()") {
+		return false, fmt.Errorf("invalid selector requirement %q", req)
+	}
+	_, exists := labels[req]
+	return exists, nil
+}
+
+// splitSelector splits at commas which are not enclosed in parentheses.
+func splitSelector(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+
+	result := parts[:0]
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
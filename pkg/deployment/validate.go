@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+)
+
+var (
+	// component names and namespaces are used as directory names, so they must be safe path elements
+	componentNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	// secret parameters are stored in files named after them
+	secretNameRe = componentNameRe
+	envNameRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidName reports whether the name is a valid component name or namespace.
+func ValidName(name string) bool {
+	return componentNameRe.MatchString(name)
+}
+
+// Validate checks the desired state for everything the reconciler relies on. All problems are reported at once,
+// each prefixed with the path of the offending field.
+func (d *ApplicationDeployment) Validate() error {
+	var errs []error
+	fail := func(path, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if d.APIVersion != CurrentAPIVersion {
+		// Decode converts all supported versions
+		fail("apiVersion", "unsupported version %q", d.APIVersion)
+	}
+	switch d.Kind {
+	case "":
+		fail("kind", "missing")
+	case "ApplicationDeployment":
+	default:
+		fail("kind", "unsupported kind %q, expected ApplicationDeployment", d.Kind)
+	}
+	if d.Metadata.Name == "" {
+		fail("metadata.name", "missing")
+	}
+	if ns := d.Metadata.Namespace; ns != "" && !componentNameRe.MatchString(ns) {
+		fail("metadata.namespace", "invalid namespace %q, only letters, digits, '.', '_' and '-' are allowed", ns)
+	}
+	if d.Spec.DeploymentProfile.Type == "" {
+		fail("spec.deploymentProfile.type", "missing")
+	}
+
+	// no components are valid, purging all deployments
+	components := d.Spec.DeploymentProfile.Components
+	names := make(map[string]bool, len(components))
+	for i, c := range components {
+		path := fmt.Sprintf("spec.deploymentProfile.components[%d]", i)
+		switch {
+		case c.Name == "":
+			fail(path+".name", "missing")
+		case !componentNameRe.MatchString(c.Name):
+			fail(path+".name", "invalid name %q, only letters, digits, '.', '_' and '-' are allowed", c.Name)
+		case names[c.Name]:
+			fail(path+".name", "duplicate component %q", c.Name)
+		}
+		names[c.Name] = true
+
+		for _, loc := range []struct{ field, value string }{
+			{"keyLocation", c.Properties.KeyLocation},
+			{"packageLocation", c.Properties.PackageLocation},
+		} {
+			switch {
+			case loc.value == "":
+				fail(path+".properties."+loc.field, "missing")
+			default:
+				if _, dgst, err := registry.ParseBlobLocation(loc.value); err != nil {
+					fail(path+".properties."+loc.field, "unsupported location %q", loc.value)
+				} else if err := dgst.Validate(); err != nil {
+					fail(path+".properties."+loc.field, "invalid digest %q: %s", dgst, err)
+				}
+			}
+		}
+		for j, delta := range c.Properties.Deltas {
+			deltaPath := fmt.Sprintf("%s.properties.deltas[%d]", path, j)
+			if err := digest.Digest(delta.From).Validate(); err != nil {
+				fail(deltaPath+".from", "invalid digest %q: %s", delta.From, err)
+			}
+			if _, dgst, err := registry.ParseBlobLocation(delta.Location); err != nil {
+				fail(deltaPath+".location", "unsupported location %q", delta.Location)
+			} else if err := dgst.Validate(); err != nil {
+				fail(deltaPath+".location", "invalid digest %q: %s", dgst, err)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(d.Spec.Parameters) {
+		param := d.Spec.Parameters[name]
+		if param.SecretRef != "" {
+			path := fmt.Sprintf("spec.parameters.%s", name)
+			if param.Value != "" {
+				fail(path, "value and secretRef are mutually exclusive")
+			}
+			if !secretNameRe.MatchString(name) {
+				fail(path, "invalid name of secret parameter, only letters, digits, '.', '_' and '-' are allowed")
+			}
+			scheme, rest, _ := strings.Cut(param.SecretRef, ":")
+			location, key, found := strings.Cut(rest, "#")
+			switch {
+			case scheme != "vault" && scheme != "sops":
+				fail(path+".secretRef", "unsupported reference %q, expected vault:<path>#<field> or sops:<location>#<key>", param.SecretRef)
+			case !found || location == "" || key == "":
+				fail(path+".secretRef", "invalid reference %q, expected %s:<...>#<key>", param.SecretRef, scheme)
+			case scheme == "sops":
+				if _, _, err := registry.ParseBlobLocation(location); err != nil {
+					fail(path+".secretRef", "unsupported location %q", location)
+				}
+			}
+		}
+		for i, target := range param.Targets {
+			path := fmt.Sprintf("spec.parameters.%s.targets[%d]", name, i)
+			if target.Pointer == "" {
+				fail(path+".pointer", "missing")
+			} else if param.SecretRef != "" && !envNameRe.MatchString(target.Pointer) {
+				fail(path+".pointer", "invalid environment variable name %q", target.Pointer)
+			}
+			for j, component := range target.Components {
+				if !names[component] {
+					fail(fmt.Sprintf("%s.components[%d]", path, j), "unknown component %q", component)
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package deployment
+
+import (
+	"strings"
+	"testing"
+)
+
+const validDeployment = `apiVersion: application.margo.org/v1alpha1
+kind: ApplicationDeployment
+metadata:
+  name: demo
+  namespace: apps
+spec:
+  deploymentProfile:
+    type: compose
+    components:
+      - name: web
+        properties:
+          keyLocation: ghcr.io/org/web@sha256:1111111111111111111111111111111111111111111111111111111111111111
+          packageLocation: ghcr.io/org/web@sha256:2222222222222222222222222222222222222222222222222222222222222222
+          deltas:
+            - from: sha256:3333333333333333333333333333333333333333333333333333333333333333
+              location: ghcr.io/org/web@sha256:4444444444444444444444444444444444444444444444444444444444444444
+      - name: db
+        properties:
+          keyLocation: http://ghcr.io/v2/org/db/blobs/sha256:5555555555555555555555555555555555555555555555555555555555555555
+          packageLocation: http://ghcr.io/v2/org/db/blobs/sha256:6666666666666666666666666666666666666666666666666666666666666666
+  parameters:
+    greeting:
+      value: hello
+      targets:
+        - pointer: GREETING
+          components: [web]
+    password:
+      secretRef: vault:secret/data/db#password
+      targets:
+        - pointer: DB_PASSWORD
+          components: [web, db]
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		// doc replaces validDeployment
+		doc string
+		// replacements are applied to validDeployment in pairs of old and new
+		replacements []string
+		// wantErrs are the paths of the expected errors, none if empty
+		wantErrs []string
+	}{
+		{name: "valid"},
+		// purges all deployments
+		{name: "no components", doc: "apiVersion: application.margo.org/v1alpha1\nkind: ApplicationDeployment\nmetadata:\n  name: demo\nspec:\n  deploymentProfile:\n    type: compose\n"},
+		{name: "missing kind", replacements: []string{"kind: ApplicationDeployment\n", ""}, wantErrs: []string{"kind: missing"}},
+		{name: "unsupported kind", replacements: []string{"kind: ApplicationDeployment", "kind: Deployment"}, wantErrs: []string{"kind: unsupported"}},
+		{name: "missing name", replacements: []string{"  name: demo\n", ""}, wantErrs: []string{"metadata.name: missing"}},
+		{name: "invalid namespace", replacements: []string{"namespace: apps", "namespace: ../apps"}, wantErrs: []string{"metadata.namespace: invalid"}},
+		{name: "missing profile type", replacements: []string{"type: compose", "type: ''"}, wantErrs: []string{"spec.deploymentProfile.type: missing"}},
+		{name: "invalid component name", replacements: []string{"name: db", "name: db/x"}, wantErrs: []string{
+			"spec.deploymentProfile.components[1].name: invalid",
+			"spec.parameters.password.targets[0].components[1]: unknown component",
+		}},
+		{name: "duplicate component", replacements: []string{"name: db", "name: web"}, wantErrs: []string{
+			"spec.deploymentProfile.components[1].name: duplicate",
+			"spec.parameters.password.targets[0].components[1]: unknown component",
+		}},
+		{name: "missing package location", replacements: []string{"packageLocation: http://ghcr.io/v2/org/db/blobs/sha256:6666666666666666666666666666666666666666666666666666666666666666", "packageLocation: ''"}, wantErrs: []string{
+			"spec.deploymentProfile.components[1].properties.packageLocation: missing",
+		}},
+		{name: "location without digest", replacements: []string{"keyLocation: ghcr.io/org/web@sha256:1111111111111111111111111111111111111111111111111111111111111111", "keyLocation: ghcr.io/org/web:latest"}, wantErrs: []string{
+			"spec.deploymentProfile.components[0].properties.keyLocation: unsupported location",
+		}},
+		{name: "truncated digest", replacements: []string{"@sha256:2222222222222222222222222222222222222222222222222222222222222222", "@sha256:22"}, wantErrs: []string{
+			"spec.deploymentProfile.components[0].properties.packageLocation: unsupported location",
+		}},
+		{name: "invalid delta", replacements: []string{"from: sha256:3333333333333333333333333333333333333333333333333333333333333333", "from: latest"}, wantErrs: []string{
+			"spec.deploymentProfile.components[0].properties.deltas[0].from: invalid digest",
+		}},
+		{name: "value and secret", replacements: []string{"secretRef: vault:secret/data/db#password", "secretRef: vault:secret/data/db#password\n      value: secret"}, wantErrs: []string{
+			"spec.parameters.password: value and secretRef are mutually exclusive",
+		}},
+		{name: "unsupported secret", replacements: []string{"secretRef: vault:", "secretRef: env:"}, wantErrs: []string{"spec.parameters.password.secretRef: unsupported reference"}},
+		{name: "secret without key", replacements: []string{"#password", ""}, wantErrs: []string{"spec.parameters.password.secretRef: invalid reference"}},
+		{name: "secret in invalid variable", replacements: []string{"pointer: DB_PASSWORD", "pointer: db-password"}, wantErrs: []string{
+			"spec.parameters.password.targets[0].pointer: invalid environment variable name",
+		}},
+		{name: "missing pointer", replacements: []string{"pointer: GREETING", "pointer: ''"}, wantErrs: []string{"spec.parameters.greeting.targets[0].pointer: missing"}},
+		{name: "unknown target", replacements: []string{"components: [web]", "components: [api]"}, wantErrs: []string{
+			"spec.parameters.greeting.targets[0].components[0]: unknown component",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := validDeployment
+			if tt.doc != "" {
+				doc = tt.doc
+			}
+			for i := 0; i < len(tt.replacements); i += 2 {
+				if !strings.Contains(doc, tt.replacements[i]) {
+					t.Fatalf("%q not found", tt.replacements[i])
+				}
+				doc = strings.Replace(doc, tt.replacements[i], tt.replacements[i+1], 1)
+			}
+			d, err := Decode([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = d.Validate()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() = nil")
+			}
+			// all problems are reported at once, one per line
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.wantErrs) {
+				t.Fatalf("Validate() = %v, want %d errors", err, len(tt.wantErrs))
+			}
+			for i, want := range tt.wantErrs {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("error %d = %q, want prefix %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package errdefs classifies the errors of reconciliations, so operators can tell e.g. signature failures from an
+// unreachable registry. The class of an error is reported as its code in logs, status, events and metrics.
+package errdefs
+
+import (
+	"errors"
+
+	"github.com/regclient/regclient/types/errs"
+)
+
+// Codes of the error classes.
+const (
+	CodeAuth         = "auth"
+	CodeNotFound     = "not_found"
+	CodeVerification = "verification"
+	CodeRuntime      = "runtime"
+	CodeDisk         = "disk"
+	CodePlatform     = "platform"
+	CodeOther        = "other"
+)
+
+// AuthError is returned if the watcher is not authorized to access the registry or another service.
+type AuthError struct{ Err error }
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// NotFoundError is returned for missing desired states, packages, keys and other artifacts.
+type NotFoundError struct{ Err error }
+
+func (e *NotFoundError) Error() string { return e.Err.Error() }
+func (e *NotFoundError) Unwrap() error { return e.Err }
+
+// VerificationError is returned for artifacts which failed their signature or digest verification.
+type VerificationError struct{ Err error }
+
+func (e *VerificationError) Error() string { return e.Err.Error() }
+func (e *VerificationError) Unwrap() error { return e.Err }
+
+// RuntimeError is returned if the container runtime or another backend failed to run a deployment.
+type RuntimeError struct{ Err error }
+
+func (e *RuntimeError) Error() string { return e.Err.Error() }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// DiskError is returned for insufficient disk space and exceeded quotas.
+type DiskError struct{ Err error }
+
+func (e *DiskError) Error() string { return e.Err.Error() }
+func (e *DiskError) Unwrap() error { return e.Err }
+
+// PlatformError is returned for packages whose images the host cannot run, e.g. amd64 images on an arm64 gateway.
+type PlatformError struct{ Err error }
+
+func (e *PlatformError) Error() string { return e.Err.Error() }
+func (e *PlatformError) Unwrap() error { return e.Err }
+
+// Code returns the code of the error's class, CodeOther if it is not classified. Of several joined errors, the first
+// classified one determines the code.
+func Code(err error) string {
+	var (
+		authErr         *AuthError
+		notFoundErr     *NotFoundError
+		verificationErr *VerificationError
+		runtimeErr      *RuntimeError
+		diskErr         *DiskError
+		platformErr     *PlatformError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &verificationErr):
+		return CodeVerification
+	case errors.As(err, &authErr):
+		return CodeAuth
+	case errors.As(err, &notFoundErr):
+		return CodeNotFound
+	case errors.As(err, &diskErr):
+		return CodeDisk
+	case errors.As(err, &platformErr):
+		return CodePlatform
+	case errors.As(err, &runtimeErr):
+		return CodeRuntime
+	}
+	return CodeOther
+}
+
+// FromRegistry classifies the errors of registry requests.
+func FromRegistry(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errs.ErrHTTPUnauthorized):
+		return &AuthError{Err: err}
+	case errors.Is(err, errs.ErrNotFound):
+		return &NotFoundError{Err: err}
+	case errors.Is(err, errs.ErrDigestMismatch):
+		return &VerificationError{Err: err}
+	}
+	return err
+}
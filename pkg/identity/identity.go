@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package identity authenticates the device with a hardware-bound key, which obtains short-lived registry tokens from
+// an OAuth 2.0 / OIDC identity service instead of long-lived access tokens stored on the device.
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Identity is the device identity created by enrollment.
+type Identity struct {
+	DeviceID string `json:"deviceId"`
+	// TokenURL is the token endpoint of the identity service.
+	TokenURL string `json:"tokenUrl"`
+	// ClientID defaults to the device ID.
+	ClientID string `json:"clientId,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Registries the token is used for. The token is presented to all registries if empty.
+	Registries []string `json:"registries,omitempty"`
+	// RegistryUser is the username presented along with the token, "<token>" presents it as bearer token.
+	RegistryUser string `json:"registryUser"`
+	Key          Key    `json:"key"`
+}
+
+// DefaultPath is the location of the identity if not configured otherwise.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "oci-watcher", "identity.json")
+}
+
+// Load reads the identity.
+func Load(path string) (*Identity, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var id Identity
+	if err := json.Unmarshal(b, &id); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if id.DeviceID == "" || id.TokenURL == "" {
+		return nil, fmt.Errorf("invalid %s: deviceId and tokenUrl are required", path)
+	}
+	return &id, nil
+}
+
+// Save writes the identity, which holds no secrets.
+func (id *Identity) Save(path string) error {
+	b, err := json.MarshalIndent(id, "", "\t")
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(path), 0o700)
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+// Covers reports whether the token should be presented to the registry.
+func (id *Identity) Covers(registry string) bool {
+	return len(id.Registries) == 0 || slices.Contains(id.Registries, registry)
+}
+
+// Token obtains a short-lived access token with the client credentials grant, authenticating with a JWT signed by the
+// device key (RFC 7523).
+func (id *Identity) Token(ctx context.Context) (string, time.Duration, error) {
+	assertion, err := id.assertion(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	clientID := id.ClientID
+	if clientID == "" {
+		clientID = id.DeviceID
+	}
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+	}
+	if id.Scope != "" {
+		form.Set("scope", id.Scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, id.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("invalid token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", 0, fmt.Errorf("token request failed: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// assertion returns the client assertion, an ES256 JWT valid for five minutes.
+func (id *Identity) assertion(ctx context.Context) (string, error) {
+	signer, err := id.Key.signer()
+	if err != nil {
+		return "", err
+	}
+	pub, err := signer.publicKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	clientID := id.ClientID
+	if clientID == "" {
+		clientID = id.DeviceID
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": Thumbprint(pub)})
+	claims, _ := json.Marshal(map[string]any{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": id.TokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"jti": hex.EncodeToString(jti),
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := signer.sign(ctx, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	if len(sig) != 64 {
+		return "", errors.New("unexpected signature size, expected an ECDSA P-256 signature")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
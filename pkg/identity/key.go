@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Key is the ECDSA P-256 device key. It is either resident in the TPM at a persistent handle and used via
+// tpm2-tools, or, for devices without TPM, a PEM file.
+type Key struct {
+	TPMHandle string `json:"tpmHandle,omitempty"`
+	File      string `json:"file,omitempty"`
+}
+
+type signer interface {
+	publicKey(ctx context.Context) (*ecdsa.PublicKey, error)
+	// sign returns the signature of the SHA-256 digest as r||s.
+	sign(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+func (k Key) signer() (signer, error) {
+	switch {
+	case k.TPMHandle != "":
+		return tpmKey{handle: k.TPMHandle}, nil
+	case k.File != "":
+		return fileKey{path: k.File}, nil
+	}
+	return nil, errors.New("no device key configured")
+}
+
+// Create creates the key unless it exists already.
+func (k Key) Create(ctx context.Context) error {
+	switch {
+	case k.TPMHandle != "":
+		return tpmKey{handle: k.TPMHandle}.create(ctx)
+	case k.File != "":
+		return fileKey{path: k.File}.create()
+	}
+	return errors.New("no device key configured")
+}
+
+// PublicKey returns the public part of the key.
+func (k Key) PublicKey(ctx context.Context) (*ecdsa.PublicKey, error) {
+	s, err := k.signer()
+	if err != nil {
+		return nil, err
+	}
+	return s.publicKey(ctx)
+}
+
+// JWK returns the public key as JSON Web Key, as registered with the identity service.
+func JWK(pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// Thumbprint returns the JWK thumbprint (RFC 7638), used as key ID.
+func Thumbprint(pub *ecdsa.PublicKey) string {
+	jwk := JWK(pub)
+	// members in lexicographic order, as required for the thumbprint
+	b := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(b))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// MarshalJWK encodes the public key as JSON Web Key.
+func MarshalJWK(pub *ecdsa.PublicKey) ([]byte, error) {
+	jwk := JWK(pub)
+	jwk["kid"] = Thumbprint(pub)
+	jwk["use"] = "sig"
+	jwk["alg"] = "ES256"
+	return json.Marshal(jwk)
+}
+
+// PublicKeyPEM encodes the public key as PEM.
+func PublicKeyPEM(pub *ecdsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func parsePublicKeyPEM(b []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM-encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, errors.New("device key is not an ECDSA P-256 key")
+	}
+	return pub, nil
+}
+
+type fileKey struct {
+	path string
+}
+
+func (k fileKey) load() (*ecdsa.PrivateKey, error) {
+	b, err := os.ReadFile(k.path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM-encoded key", k.path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func (k fileKey) create() error {
+	if _, err := os.Stat(k.path); err == nil {
+		return nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(k.path), 0o700)
+	return os.WriteFile(k.path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+func (k fileKey) publicKey(context.Context) (*ecdsa.PublicKey, error) {
+	key, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	return &key.PublicKey, nil
+}
+
+func (k fileKey) sign(_ context.Context, digest []byte) ([]byte, error) {
+	key, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return nil, err
+	}
+	return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), nil
+}
+
+// tpmKey uses a key at a persistent TPM handle, e.g. 0x81010002. The private key never leaves the TPM.
+type tpmKey struct {
+	handle string
+}
+
+func (k tpmKey) create(ctx context.Context) error {
+	if _, err := k.publicKey(ctx); err == nil {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "oci-watcher-tpm")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	primary, pub, priv, key := filepath.Join(dir, "primary.ctx"), filepath.Join(dir, "key.pub"), filepath.Join(dir, "key.priv"), filepath.Join(dir, "key.ctx")
+	for _, args := range [][]string{
+		{"tpm2_createprimary", "--hierarchy", "o", "--hash-algorithm", "sha256", "--key-algorithm", "ecc256", "--key-context", primary},
+		{"tpm2_create", "--parent-context", primary, "--key-algorithm", "ecc256:ecdsa-sha256", "--public", pub, "--private", priv,
+			"--attributes", "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign"},
+		{"tpm2_load", "--parent-context", primary, "--public", pub, "--private", priv, "--key-context", key},
+		{"tpm2_evictcontrol", "--hierarchy", "o", "--object-context", key, k.handle},
+	} {
+		if _, err := tpm2(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k tpmKey) publicKey(ctx context.Context) (*ecdsa.PublicKey, error) {
+	dir, err := os.MkdirTemp("", "oci-watcher-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "key.pem")
+	if _, err := tpm2(ctx, "tpm2_readpublic", "--object-context", k.handle, "--format", "pem", "--output", out); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	return parsePublicKeyPEM(b)
+}
+
+func (k tpmKey) sign(ctx context.Context, digest []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "oci-watcher-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "digest"), filepath.Join(dir, "sig")
+	if err := os.WriteFile(in, digest, 0o600); err != nil {
+		return nil, err
+	}
+	// the plain format of ECDSA signatures is r||s
+	if _, err := tpm2(ctx, "tpm2_sign", "--key-context", k.handle, "--hash-algorithm", "sha256", "--scheme", "ecdsa",
+		"--format", "plain", "--digest", "--signature", out, in); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+func tpm2(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
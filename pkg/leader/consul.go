@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ConsulLock is a key in Consul's key/value store locked with a session. The session expires unless renewed within
+// its TTL, so the lock of a failed leader is released eventually.
+type ConsulLock struct {
+	// Address of the Consul API, e.g. http://127.0.0.1:8500.
+	Address string
+	Key     string
+	Token   string
+	// ID identifies the watcher holding the lock.
+	ID  string
+	TTL time.Duration
+
+	Client  *http.Client
+	session string
+}
+
+func newConsulLock(spec, id string, ttl time.Duration) (*ConsulLock, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Trim(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid lock %q, expected consul://<host>:<port>/<key>", spec)
+	}
+	return &ConsulLock{
+		Address: "http://" + u.Host,
+		Key:     key,
+		Token:   os.Getenv("CONSUL_HTTP_TOKEN"),
+		ID:      id,
+		TTL:     ttl,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (l *ConsulLock) Acquire(ctx context.Context) (bool, error) {
+	if l.session != "" {
+		// the session is gone once expired or invalidated, and the lock with it
+		var sessions []json.RawMessage
+		err := l.do(ctx, http.MethodPut, "/v1/session/renew/"+l.session, nil, &sessions)
+		if err != nil && !errors.Is(err, errNotFound) {
+			return false, err
+		}
+		if err != nil || len(sessions) == 0 {
+			l.session = ""
+		}
+	}
+	if l.session == "" {
+		var created struct{ ID string }
+		session := map[string]string{
+			"Name":      "oci-watcher " + l.ID,
+			"TTL":       fmt.Sprintf("%ds", int(max(l.TTL, 10*time.Second).Seconds())),
+			"Behavior":  "release",
+			"LockDelay": "0s",
+		}
+		if err := l.do(ctx, http.MethodPut, "/v1/session/create", session, &created); err != nil {
+			return false, err
+		}
+		l.session = created.ID
+	}
+	var acquired bool
+	if err := l.do(ctx, http.MethodPut, "/v1/kv/"+l.Key+"?acquire="+url.QueryEscape(l.session), l.ID, &acquired); err != nil {
+		return false, err
+	}
+	return acquired, nil
+}
+
+func (l *ConsulLock) Release(ctx context.Context) error {
+	if l.session == "" {
+		return nil
+	}
+	session := l.session
+	l.session = ""
+	return l.do(ctx, http.MethodPut, "/v1/session/destroy/"+session, nil, nil)
+}
+
+// errNotFound is returned by the Consul API for unknown sessions.
+var errNotFound = errors.New("not found")
+
+// do calls the Consul API with the JSON encoded body and decodes the response into result.
+func (l *ConsulLock) do(ctx context.Context, method, path string, body, result any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, l.Address+path, r)
+	if err != nil {
+		return err
+	}
+	if l.Token != "" {
+		req.Header.Set("X-Consul-Token", l.Token)
+	}
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package leader
+
+import (
+	"context"
+	"errors"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// FileLock is a lock file on a filesystem shared by the watchers, e.g. a volume mounted into several containers. The
+// filesystem must support flock across its clients.
+type FileLock struct {
+	Path string
+
+	lock *fsutil.Lock
+}
+
+func (l *FileLock) Acquire(context.Context) (bool, error) {
+	if l.lock != nil {
+		return true, nil
+	}
+	lock, err := fsutil.AcquireLock(l.Path)
+	if errors.Is(err, fsutil.ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	l.lock = lock
+	return true, nil
+}
+
+func (l *FileLock) Release(context.Context) error {
+	if l.lock == nil {
+		return nil
+	}
+	err := l.lock.Release()
+	l.lock = nil
+	return err
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package leader elects which of several redundant watchers applies changes, while the others stand by.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotLeader is the cause of the cancellation of contexts returned by Election.Lead.
+var ErrNotLeader = errors.New("not the leader")
+
+// Lock is held by the leader.
+type Lock interface {
+	// Acquire takes or renews the lock without blocking and reports whether it is held.
+	Acquire(ctx context.Context) (bool, error)
+	// Release gives up the lock.
+	Release(ctx context.Context) error
+}
+
+// New returns the lock described by spec: file:<path> for a lock file on a filesystem shared by the watchers, or
+// consul://<host>:<port>/<key> for a lock in Consul's key/value store. The token for Consul is read from
+// CONSUL_HTTP_TOKEN.
+func New(spec, id string, ttl time.Duration) (Lock, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		return &FileLock{Path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "consul://"):
+		return newConsulLock(spec, id, ttl)
+	}
+	return nil, fmt.Errorf("unsupported lock %q, expected file:<path> or consul://<host>:<port>/<key>", spec)
+}
+
+// Election campaigns for the lock until it is stopped.
+type Election struct {
+	Lock Lock
+	// Interval between attempts to acquire or renew the lock.
+	Interval time.Duration
+	// OnElected is called when the watcher became the leader.
+	OnElected func()
+
+	leader atomic.Bool
+	mu     sync.Mutex
+	lost   chan struct{} // closed when the lock is lost
+}
+
+// Leader reports whether the watcher currently holds the lock.
+func (e *Election) Leader() bool {
+	return e.leader.Load()
+}
+
+// Lead returns a context which is cancelled with ErrNotLeader as soon as the watcher loses the lock, e.g. because it
+// failed to renew it, so a standby watcher taking over does not apply changes concurrently. It is cancelled right
+// away if the watcher is not the leader.
+func (e *Election) Lead(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	e.mu.Lock()
+	lost := e.lost
+	e.mu.Unlock()
+	if lost == nil || !e.Leader() {
+		cancel(ErrNotLeader)
+		return ctx, func() {}
+	}
+	go func() {
+		select {
+		case <-lost:
+			cancel(ErrNotLeader)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// setLeader records whether the lock is held and reports whether it was held before.
+func (e *Election) setLeader(held bool) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	was := e.leader.Swap(held)
+	switch {
+	case held && !was:
+		e.lost = make(chan struct{})
+	case !held && was:
+		close(e.lost)
+		e.lost = nil
+	}
+	return was
+}
+
+// Run campaigns until ctx is done, then releases the lock.
+func (e *Election) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	for {
+		held, err := e.Lock.Acquire(ctx)
+		if err != nil {
+			log.Printf("WARN: Leader election: %s", err)
+		}
+		switch was := e.setLeader(held); {
+		case held && !was:
+			log.Println("Leader election: elected, applying changes")
+			if e.OnElected != nil {
+				e.OnElected()
+			}
+		case !held && was:
+			log.Println("WARN: Leader election: lost the lock, standing by")
+		}
+		select {
+		case <-ctx.Done():
+			if e.setLeader(false) {
+				// ctx is done already
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+				defer cancel()
+				if err := e.Lock.Release(ctx); err != nil {
+					log.Printf("WARN: Leader election: failed to release the lock: %s", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLock is held as long as held is set.
+type testLock struct {
+	held atomic.Bool
+}
+
+func (l *testLock) Acquire(context.Context) (bool, error) { return l.held.Load(), nil }
+func (l *testLock) Release(context.Context) error         { return nil }
+
+func TestElectionLead(t *testing.T) {
+	lock := &testLock{}
+	elected := make(chan struct{}, 1)
+	e := &Election{Lock: lock, Interval: 10 * time.Millisecond, OnElected: func() { elected <- struct{}{} }}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	standby, stop := e.Lead(ctx)
+	stop()
+	if !errors.Is(context.Cause(standby), ErrNotLeader) {
+		t.Fatalf("context of a standby watcher: %v, want %v", context.Cause(standby), ErrNotLeader)
+	}
+
+	lock.held.Store(true)
+	select {
+	case <-elected:
+	case <-time.After(time.Second):
+		t.Fatal("not elected")
+	}
+	leading, stop := e.Lead(ctx)
+	defer stop()
+	if err := leading.Err(); err != nil {
+		t.Fatalf("context of the leader: %v", err)
+	}
+
+	// failing to renew the lock aborts what the leader does
+	lock.held.Store(false)
+	select {
+	case <-leading.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after losing the lock")
+	}
+	if !errors.Is(context.Cause(leading), ErrNotLeader) {
+		t.Fatalf("context after losing the lock: %v, want %v", context.Cause(leading), ErrNotLeader)
+	}
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package logforward
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileSink writes the lines of every component to <component>.log in a directory. Files reaching the maximum size
+// are rotated to <component>.log.1, whose predecessor becomes <component>.log.2 and so on.
+type fileSink struct {
+	dir     string
+	maxSize int64
+	keep    int
+	files   map[string]*logFile
+}
+
+type logFile struct {
+	f    *os.File
+	size int64
+}
+
+func newFiles(dir string, opts Options) (*fileSink, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file URL lacks the directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileSink{
+		dir:     dir,
+		maxSize: int64(cmp.Or(opts.MaxSize, 10<<20)),
+		keep:    cmp.Or(opts.Keep, 5),
+		files:   make(map[string]*logFile),
+	}, nil
+}
+
+func (s *fileSink) Write(_ context.Context, lines []Line) error {
+	writers := make(map[string]*bufio.Writer)
+	for _, line := range lines {
+		name := strings.ReplaceAll(cmp.Or(line.Component, "unknown"), string(filepath.Separator), "_")
+		lf, err := s.open(name)
+		if err != nil {
+			return err
+		}
+		if lf.size >= s.maxSize {
+			if w := writers[name]; w != nil {
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				delete(writers, name)
+			}
+			if lf, err = s.rotate(name); err != nil {
+				return err
+			}
+		}
+		w := writers[name]
+		if w == nil {
+			w = bufio.NewWriter(lf.f)
+			writers[name] = w
+		}
+		n, err := fmt.Fprintf(w, "%s %s %s\n", line.Time.UTC().Format(time.RFC3339Nano), line.Container, line.Message)
+		if err != nil {
+			return err
+		}
+		lf.size += int64(n)
+	}
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns the log file of the component, opening it for appending if needed.
+func (s *fileSink) open(name string) (*logFile, error) {
+	if lf := s.files[name]; lf != nil {
+		return lf, nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, name+".log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	lf := &logFile{f: f, size: info.Size()}
+	s.files[name] = lf
+	return lf, nil
+}
+
+// rotate renames the log files of the component, removing the oldest one, and opens a new one.
+func (s *fileSink) rotate(name string) (*logFile, error) {
+	s.files[name].f.Close()
+	delete(s.files, name)
+	base := filepath.Join(s.dir, name+".log")
+	_ = os.Remove(fmt.Sprintf("%s.%d", base, s.keep))
+	for i := s.keep - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", base, i), fmt.Sprintf("%s.%d", base, i+1))
+	}
+	if s.keep > 0 {
+		if err := os.Rename(base, base+".1"); err != nil {
+			return nil, err
+		}
+	} else if err := os.Remove(base); err != nil {
+		return nil, err
+	}
+	return s.open(name)
+}
+
+func (s *fileSink) Close() error {
+	for name, lf := range s.files {
+		lf.f.Close()
+		delete(s.files, name)
+	}
+	return nil
+}
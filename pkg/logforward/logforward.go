@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package logforward forwards the logs of the managed containers to syslog, Loki or rotated files, giving fleets
+// basic observability of their applications without further agents on the devices.
+package logforward
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// batchSize is the maximum number of lines written to the sink at once.
+	batchSize = 500
+	// flushInterval is the maximum delay of lines.
+	flushInterval = 2 * time.Second
+	// retryDelay is the delay before a batch is written again after the sink failed.
+	retryDelay = 10 * time.Second
+)
+
+// Line is a line logged by a container of a component.
+type Line struct {
+	Component string
+	Container string
+	Time      time.Time
+	Message   string
+}
+
+// Sink receives the forwarded lines.
+type Sink interface {
+	Write(ctx context.Context, lines []Line) error
+	Close() error
+}
+
+// Options configure the sinks.
+type Options struct {
+	// DeviceID labels the lines.
+	DeviceID string
+	// MaxSize is the size in bytes after which log files are rotated, defaults to 10 MiB.
+	MaxSize uint64
+	// Keep is the number of rotated log files kept per component, defaults to 5.
+	Keep int
+}
+
+// New returns the sink selected by the URL:
+//
+//   - syslog://host:514 and syslog+tcp://host:601 send RFC 5424 messages via UDP or TCP,
+//   - loki://host:3100 and loki+https://host push to the Loki push API,
+//   - file:///var/log/apps writes a file per component which is rotated by size.
+func New(rawURL string, opts Options) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		return newSyslog(u, opts)
+	case "loki", "loki+http", "loki+https":
+		return newLoki(u, opts), nil
+	case "file":
+		return newFiles(u.Path, opts)
+	}
+	return nil, fmt.Errorf("unsupported log destination %q", rawURL)
+}
+
+// Forwarder buffers lines and writes them to the sink in batches. Lines are dropped while the buffer is full, e.g. if
+// the sink is unreachable, so containers are never slowed down.
+type Forwarder struct {
+	sink  Sink
+	lines chan Line
+	// dropping is set while lines are dropped, so it is logged once per outage.
+	dropping atomic.Bool
+}
+
+// NewForwarder returns a forwarder buffering up to buffer lines.
+func NewForwarder(sink Sink, buffer int) *Forwarder {
+	return &Forwarder{sink: sink, lines: make(chan Line, max(buffer, batchSize))}
+}
+
+// Forward queues the line unless the buffer is full. It may be called concurrently.
+func (f *Forwarder) Forward(line Line) {
+	select {
+	case f.lines <- line:
+		f.dropping.Store(false)
+	default:
+		if !f.dropping.Swap(true) {
+			log.Printf("WARN: Dropping logs of %s, the buffer of forwarded logs is full", line.Component)
+		}
+	}
+}
+
+// Run writes the queued lines to the sink until the context is done, then closes it.
+func (f *Forwarder) Run(ctx context.Context) {
+	defer f.sink.Close()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []Line
+	for {
+		flush := false
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-f.lines:
+			batch = append(batch, line)
+			flush = len(batch) >= batchSize
+		case <-ticker.C:
+			flush = len(batch) > 0
+		}
+		if !flush {
+			continue
+		}
+		if err := f.sink.Write(ctx, batch); err != nil {
+			log.Printf("WARN: Failed to forward %d log lines: %s", len(batch), err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			// the batch is retried unless newer lines fill the buffer meanwhile
+			if len(f.lines) < cap(f.lines)/2 {
+				continue
+			}
+		}
+		batch = batch[:0]
+	}
+}
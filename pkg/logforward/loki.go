@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package logforward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiPushPath is the push API of Loki, used if the URL has no path.
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiSink pushes the lines to Loki as streams labeled with the device, component and container. The credentials of
+// the URL are sent with basic authentication, its tenant parameter as X-Scope-OrgID.
+type lokiSink struct {
+	url      string
+	username string
+	password string
+	tenant   string
+	deviceID string
+	client   *http.Client
+}
+
+func newLoki(u *url.URL, opts Options) *lokiSink {
+	scheme := strings.TrimPrefix(strings.TrimPrefix(u.Scheme, "loki"), "+")
+	if scheme == "" {
+		scheme = "http"
+	}
+	s := &lokiSink{deviceID: opts.DeviceID, tenant: u.Query().Get("tenant"), client: &http.Client{Timeout: 30 * time.Second}}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	endpoint := url.URL{Scheme: scheme, Host: u.Host, Path: u.Path}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = lokiPushPath
+	}
+	s.url = endpoint.String()
+	return s
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) Write(ctx context.Context, lines []Line) error {
+	streams := make(map[[2]string]*lokiStream)
+	var push struct {
+		Streams []*lokiStream `json:"streams"`
+	}
+	for _, line := range lines {
+		key := [2]string{line.Component, line.Container}
+		stream := streams[key]
+		if stream == nil {
+			stream = &lokiStream{Stream: map[string]string{"job": "oci-watcher", "device": s.deviceID, "component": line.Component, "container": line.Container}}
+			streams[key] = stream
+			push.Streams = append(push.Streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(line.Time.UnixNano(), 10), line.Message})
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	if s.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", s.tenant)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("loki push: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *lokiSink) Close() error {
+	return nil
+}
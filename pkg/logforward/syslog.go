@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package logforward
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// syslogPriority is the priority of forwarded lines: facility local0, severity informational.
+const syslogPriority = 16*8 + 6
+
+// syslogSink sends the lines as RFC 5424 messages, one datagram per line via UDP, or octet-counted via TCP.
+type syslogSink struct {
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslog(u *url.URL, opts Options) (*syslogSink, error) {
+	network := "udp"
+	if u.Scheme == "syslog+tcp" {
+		network = "tcp"
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("syslog URL %q lacks the host", u)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "514")
+	}
+	return &syslogSink{network: network, address: address, hostname: cmp.Or(opts.DeviceID, "-")}, nil
+}
+
+func (s *syslogSink) Write(ctx context.Context, lines []Line) error {
+	if s.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, s.network, s.address)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		// APP-NAME is limited to 48 printable characters
+		app := strings.Map(func(r rune) rune {
+			if r <= ' ' || r > '~' {
+				return '_'
+			}
+			return r
+		}, line.Component)
+		msg := fmt.Sprintf("<%d>1 %s %s %.48s - - [container name=%q] %s", syslogPriority, line.Time.UTC().Format(time.RFC3339Nano), s.hostname, cmp.Or(app, "-"), line.Container, line.Message)
+		if s.network == "tcp" {
+			fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+			continue
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if buf.Len() > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if _, err := s.conn.Write(buf.Bytes()); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
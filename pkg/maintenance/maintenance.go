@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package maintenance restricts changes of deployments to maintenance windows.
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// searchLimit bounds the search for the next window.
+const searchLimit = 366 * 24 * time.Hour
+
+// Config defines when deployments may be changed. A nil Config allows changes at any time.
+type Config struct {
+	// Timezone of the schedules, e.g. Europe/Berlin, defaults to the local time zone of the device.
+	Timezone string `yaml:"timezone"`
+	// Windows are open from their start for their duration. Changes are allowed at any time unless windows are
+	// configured.
+	Windows []Window `yaml:"windows"`
+	// Freezes are periods in which no changes are applied, even within a window.
+	Freezes []Freeze `yaml:"freezes"`
+
+	location *time.Location
+}
+
+// Window is a recurring period in which changes may be applied.
+type Window struct {
+	// Schedule is a cron expression of the starts of the window, e.g. "0 2 * * 1-5" for 2am on workdays.
+	Schedule string `yaml:"schedule"`
+	// Duration of the window, e.g. 2h.
+	Duration time.Duration `yaml:"duration"`
+
+	cron *cron
+}
+
+// Freeze is a one-off period in which changes are deferred, e.g. during the holidays.
+type Freeze struct {
+	From time.Time `yaml:"from"`
+	To   time.Time `yaml:"to"`
+	// Reason is reported for deferred changes.
+	Reason string `yaml:"reason"`
+}
+
+// LoadConfig reads the configuration from a YAML file of the form:
+//
+//	timezone: Europe/Berlin
+//	windows:
+//	  - schedule: "0 2 * * 1-5"
+//	    duration: 2h
+//	freezes:
+//	  - from: 2025-12-20T00:00:00+01:00
+//	    to: 2026-01-06T00:00:00+01:00
+//	    reason: holidays
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, c.init()
+}
+
+func (c *Config) init() error {
+	var errs []error
+	c.location = time.Local
+	if c.Timezone != "" {
+		var err error
+		if c.location, err = time.LoadLocation(c.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("timezone: %w", err))
+		}
+	}
+	for i := range c.Windows {
+		w := &c.Windows[i]
+		var err error
+		if w.cron, err = parseCron(w.Schedule); err != nil {
+			errs = append(errs, fmt.Errorf("windows[%d].schedule: %w", i, err))
+		}
+		if w.Duration < time.Minute {
+			errs = append(errs, fmt.Errorf("windows[%d].duration: must be at least 1m", i))
+		}
+	}
+	for i, f := range c.Freezes {
+		if !f.To.After(f.From) {
+			errs = append(errs, fmt.Errorf("freezes[%d]: to must follow from", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Allowed reports whether changes may be applied at t. Otherwise it returns the reason.
+func (c *Config) Allowed(t time.Time) (bool, string) {
+	if c == nil {
+		return true, ""
+	}
+	for _, f := range c.Freezes {
+		if !t.Before(f.From) && t.Before(f.To) {
+			reason := "deployment freeze until " + f.To.Format(time.RFC3339)
+			if f.Reason != "" {
+				reason += " (" + f.Reason + ")"
+			}
+			return false, reason
+		}
+	}
+	if len(c.Windows) == 0 || c.inWindow(t) {
+		return true, ""
+	}
+	if next, found := c.nextWindow(t); found {
+		return false, "outside maintenance window, next one starts " + next.Format(time.RFC3339)
+	}
+	return false, "outside maintenance window"
+}
+
+// inWindow reports whether a window started within its duration before t.
+func (c *Config) inWindow(t time.Time) bool {
+	t = t.In(c.location).Truncate(time.Minute)
+	for _, w := range c.Windows {
+		for start := t; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+			if w.cron.matches(start) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextWindow returns the start of the next window after t.
+func (c *Config) nextWindow(t time.Time) (time.Time, bool) {
+	t = t.In(c.location).Truncate(time.Minute)
+	for start := t.Add(time.Minute); start.Sub(t) < searchLimit; start = start.Add(time.Minute) {
+		for _, w := range c.Windows {
+			if w.cron.matches(start) {
+				return start, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package notify informs external endpoints about changes of local deployments.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EventApplied    = "applied"
+	EventFailed     = "failed"
+	EventRolledBack = "rolledBack"
+	EventPurged     = "purged"
+	EventDrifted    = "drifted"
+	EventHookFailed = "hookFailed"
+	EventDeferred   = "deferred"
+)
+
+// Event describes a change (or failed change) of a local deployment.
+type Event struct {
+	Type       string `json:"type"`
+	DeviceID   string `json:"deviceId"`
+	Host       string `json:"host,omitempty"`
+	Deployment string `json:"deployment,omitempty"`
+	Component  string `json:"component"`
+	Package    string `json:"package,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Code classifies the error, see errdefs.Code.
+	Code string `json:"code,omitempty"`
+	// Logs are the last lines of the logs of the services of a failed component, if captured.
+	Logs string    `json:"logs,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Webhook is an HTTP endpoint notified about deploy events.
+type Webhook struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Events restricts the notified event types, all events are sent if empty.
+	Events []string `yaml:"events"`
+	// Template renders the payload from the event, the event is sent as JSON if empty.
+	Template string `yaml:"template"`
+
+	tmpl *template.Template
+}
+
+// LoadWebhooks reads the webhook configuration, a YAML document of the form:
+//
+//	webhooks:
+//	  - url: https://hooks.slack.com/services/...
+//	    events: [failed, rolledBack]
+//	    template: '{"text": "{{.Component}} on {{.DeviceID}}: {{.Type}} {{.Error}}"}'
+func LoadWebhooks(path string) ([]*Webhook, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Webhooks []*Webhook `yaml:"webhooks"`
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	for i, wh := range cfg.Webhooks {
+		if wh.URL == "" {
+			return nil, fmt.Errorf("webhooks[%d].url: missing", i)
+		}
+		if wh.Template != "" {
+			if wh.tmpl, err = template.New(wh.URL).Funcs(template.FuncMap{"json": toJSON}).Parse(wh.Template); err != nil {
+				return nil, fmt.Errorf("webhooks[%d].template: %w", i, err)
+			}
+		}
+	}
+	return cfg.Webhooks, nil
+}
+
+// toJSON is available in templates to safely embed values, e.g. {"text": {{json .Error}}}.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notifier sends events to the configured webhooks. A nil Notifier discards all events.
+type Notifier struct {
+	DeviceID string
+	Webhooks []*Webhook
+	// Client defaults to a client with a 30s timeout.
+	Client *http.Client
+	// Listeners are called with every event before the webhooks are notified. They must not block.
+	Listeners []func(Event)
+}
+
+// Emit notifies all interested webhooks in the background.
+func (n *Notifier) Emit(ctx context.Context, e Event) {
+	if n == nil {
+		return
+	}
+	client := n.Client
+	if client == nil {
+		client = defaultClient
+	}
+	e.DeviceID, e.Time = n.DeviceID, time.Now().UTC()
+	for _, l := range n.Listeners {
+		l(e)
+	}
+	for _, wh := range n.Webhooks {
+		if len(wh.Events) > 0 && !slices.Contains(wh.Events, e.Type) {
+			continue
+		}
+		go wh.send(ctx, client, e)
+	}
+}
+
+func (wh *Webhook) send(ctx context.Context, client *http.Client, e Event) {
+	var body bytes.Buffer
+	if wh.tmpl != nil {
+		if err := wh.tmpl.Execute(&body, e); err != nil {
+			log.Printf("WARN: Failed to render webhook payload for %s: %s", wh.URL, err)
+			return
+		}
+	} else {
+		_ = json.NewEncoder(&body).Encode(e)
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 5 * time.Second)
+		}
+		if err = wh.post(ctx, client, body.Bytes()); err == nil {
+			return
+		}
+	}
+	log.Printf("WARN: Failed to notify %s about %s event: %s", wh.URL, e.Type, err)
+}
+
+func (wh *Webhook) post(ctx context.Context, client *http.Client, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
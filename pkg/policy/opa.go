@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package policy evaluates operator-provided admission policies before a component is deployed.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/verify"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultQuery is the Rego query evaluated if none is configured. It yields the set of reasons for rejecting the
+// component, e.g.
+//
+//	package oci_watcher
+//
+//	deny contains msg if {
+//		some name, service in input.compose.services
+//		service.privileged
+//		msg := sprintf("service %s must not be privileged", [name])
+//	}
+const DefaultQuery = "data.oci_watcher.deny"
+
+// Input is the document available as input to the policies. Field names are those of the YAML representation.
+type Input struct {
+	Deployment *deployment.ApplicationDeployment `yaml:"deployment"`
+	Component  deployment.Component              `yaml:"component"`
+	Package    Package                           `yaml:"package"`
+	// Signature describes the verification the package passed.
+	Signature Signature `yaml:"signature"`
+	// Compose is the parsed compose file of the app, if any.
+	Compose any `yaml:"compose,omitempty"`
+	// Labels of the device.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// Package describes the downloaded package.
+type Package struct {
+	Location string `yaml:"location"`
+	Digest   string `yaml:"digest"`
+	// App is the name of the app file in the package.
+	App string `yaml:"app"`
+}
+
+// Signature describes the verification of a package.
+type Signature struct {
+	Verified bool `yaml:"verified"`
+	// Rule is the verification rule applied to the component, if the verifier is a verify.Chain.
+	Rule *verify.Rule `yaml:"rule,omitempty"`
+}
+
+// OPA evaluates Rego policies with the Open Policy Agent CLI (https://www.openpolicyagent.org).
+type OPA struct {
+	// Policies are Rego files or directories holding them.
+	Policies []string
+	// Query defaults to DefaultQuery. It must yield a collection of messages, the component is rejected if it is not
+	// empty.
+	Query string
+	// Command defaults to opa.
+	Command []string
+}
+
+// Evaluate rejects the input if the query yields any message.
+func (o *OPA) Evaluate(ctx context.Context, input Input) error {
+	// convert via YAML so policies see the same field names as the desired state
+	b, err := yaml.Marshal(input)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	inputJSON, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode policy input: %w", err)
+	}
+
+	command := o.Command
+	if len(command) == 0 {
+		command = []string{"opa"}
+	}
+	query := o.Query
+	if query == "" {
+		query = DefaultQuery
+	}
+	args := []string{"eval", "--format", "json", "--stdin-input"}
+	for _, p := range o.Policies {
+		args = append(args, "--data", p)
+	}
+	cmd := exec.CommandContext(ctx, command[0], append(slices.Clone(command[1:]), append(args, query)...)...)
+	cmd.Stdin = bytes.NewReader(inputJSON)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("policy evaluation failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value any `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("invalid policy result: %w", err)
+	}
+	var violations []string
+	for _, r := range result.Result {
+		for _, expr := range r.Expressions {
+			violations = append(violations, messages(expr.Value)...)
+		}
+	}
+	if len(violations) > 0 {
+		slices.Sort(violations)
+		return fmt.Errorf("rejected by policy: %s", strings.Join(slices.Compact(violations), "; "))
+	}
+	log.Printf("%s: admitted by policy", input.Component.Name)
+	return nil
+}
+
+// messages flattens the value of a deny rule, which is usually a set of strings.
+func messages(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case bool:
+		if v {
+			return []string{"denied"}
+		}
+		return nil
+	case []any:
+		var msgs []string
+		for _, e := range v {
+			msgs = append(msgs, messages(e)...)
+		}
+		return msgs
+	case map[string]any:
+		var msgs []string
+		for k, e := range v {
+			if s, ok := e.(string); ok {
+				msgs = append(msgs, s)
+			} else if e == true {
+				msgs = append(msgs, k)
+			}
+		}
+		return msgs
+	default:
+		b, _ := json.Marshal(v)
+		return []string{string(b)}
+	}
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+)
+
+// backupTimeFormat names the backups of a component, so they sort chronologically.
+const backupTimeFormat = "20060102T150405Z"
+
+// backup snapshots the stopped deployment of the component in dir before it is replaced or purged, see BackupDir.
+// The reason, e.g. update, is appended to the name of the backup.
+func (r *Reconciler) backup(ctx context.Context, component, dir, reason string) error {
+	if r.BackupDir == "" {
+		return nil
+	}
+	backupDir := path.Join(r.BackupDir, component, time.Now().UTC().Format(backupTimeFormat)+"-"+reason)
+	if err := os.MkdirAll(backupDir, 0o700); err != nil {
+		return err
+	}
+	if err := r.snapshot(ctx, dir, backupDir); err != nil {
+		_ = os.RemoveAll(backupDir)
+		return fmt.Errorf("failed to back up %s: %w", component, err)
+	}
+	log.Printf("%s: backed up deployment to %s", component, backupDir)
+	r.pruneBackups(component)
+	return nil
+}
+
+// backupStale stops the stale deployment of the component in dir and backs it up before it is purged. It is
+// restarted if the backup fails, so the purge is retried in the next reconciliation.
+func (r *Reconciler) backupStale(ctx context.Context, component, dir string) error {
+	if r.BackupDir == "" {
+		return nil
+	}
+	b := r.DeploymentBackend(dir)
+	err := b.Stop(ctx, dir)
+	if err == nil {
+		err = r.backup(ctx, component, dir, "purge")
+	}
+	if err != nil {
+		if err := b.EnsureRunning(ctx, dir); err != nil {
+			log.Printf("ERROR: %s: failed to restart deployment: %s", component, err)
+		}
+	}
+	return err
+}
+
+// snapshot writes the files of the deployment in dir, including its secrets, to deployment.tgz in backupDir and its
+// volumes to the volumes directory if requested.
+func (r *Reconciler) snapshot(ctx context.Context, dir, backupDir string) error {
+	f, err := os.OpenFile(path.Join(backupDir, "deployment.tgz"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	err = fsutil.PackTgz(f, dir)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !r.BackupVolumes {
+		return nil
+	}
+	backuper, ok := r.DeploymentBackend(dir).(backend.VolumeBackuper)
+	if !ok {
+		return nil
+	}
+	return backuper.BackupVolumes(ctx, dir, path.Join(backupDir, "volumes"))
+}
+
+// pruneBackups removes the oldest backups of the component beyond KeepBackups.
+func (r *Reconciler) pruneBackups(component string) {
+	if r.KeepBackups <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(path.Join(r.BackupDir, component))
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names[:max(len(names)-r.KeepBackups, 0)] {
+		if err := os.RemoveAll(path.Join(r.BackupDir, component, name)); err != nil {
+			log.Printf("WARN: %s: failed to remove backup %s: %s", component, name, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/sysinfo"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+)
+
+// ErrUnschedulable is returned for components whose requirements the device does not satisfy.
+var ErrUnschedulable = errors.New("unschedulable")
+
+// Capabilities describe what a host offers to deployments, so the fleet backend can decide which devices to deploy
+// components to. The hardware of remote hosts is unknown.
+type Capabilities struct {
+	Host string `json:"host,omitempty"`
+	OS   string `json:"os,omitempty"`
+	Arch string `json:"arch,omitempty"`
+	CPUs int    `json:"cpus,omitempty"`
+	// Memory is the total memory in bytes.
+	Memory uint64 `json:"memory,omitempty"`
+	// GPUs are the device nodes of the GPUs.
+	GPUs []string `json:"gpus,omitempty"`
+	// DiskFree is the free space in bytes on the filesystem of the deploy directory.
+	DiskFree uint64 `json:"diskFree,omitempty"`
+	// Runtimes maps the runtimes of the backends, e.g. docker, to their versions. Unreachable runtimes are missing.
+	Runtimes map[string]string `json:"runtimes,omitempty"`
+}
+
+// Capabilities collects the capabilities of the host.
+func (r *Reconciler) Capabilities(ctx context.Context) Capabilities {
+	c := Capabilities{Host: r.Host, Runtimes: make(map[string]string)}
+	if r.Host == "" {
+		c.OS, c.Arch, c.CPUs = runtime.GOOS, runtime.GOARCH, runtime.NumCPU()
+		c.Memory, _, _ = sysinfo.Memory()
+		c.GPUs = sysinfo.GPUs()
+	}
+	c.DiskFree, _, _ = fsutil.FreeSpace(r.DeployDir)
+	backends := []backend.Backend{r.Backend}
+	for _, b := range r.Backends {
+		backends = append(backends, b)
+	}
+	for _, b := range backends {
+		v, ok := b.(backend.Versioner)
+		if !ok {
+			continue
+		}
+		if name, version, err := v.RuntimeVersion(ctx); err == nil && version != "" {
+			c.Runtimes[name] = version
+		}
+	}
+	return c
+}
+
+// String summarizes the capabilities in a line.
+func (c Capabilities) String() string {
+	var parts []string
+	if c.OS != "" {
+		parts = append(parts, c.OS+"/"+c.Arch, fmt.Sprintf("%d CPUs", c.CPUs))
+	}
+	if c.Memory > 0 {
+		parts = append(parts, fsutil.FormatBytes(c.Memory)+" memory")
+	}
+	if len(c.GPUs) > 0 {
+		parts = append(parts, fmt.Sprintf("%d GPUs", len(c.GPUs)))
+	}
+	if c.DiskFree > 0 {
+		parts = append(parts, fsutil.FormatBytes(c.DiskFree)+" free")
+	}
+	var runtimes []string
+	for name, version := range c.Runtimes {
+		runtimes = append(runtimes, name+" "+version)
+	}
+	slices.Sort(runtimes)
+	return strings.Join(append(parts, runtimes...), ", ")
+}
+
+// unschedulableFile records why a component which is held back cannot run on the device.
+func (r *Reconciler) unschedulableFile(component string) string {
+	return path.Join(r.DeployDir, ".unschedulable-"+component)
+}
+
+// Unschedulable returns why the deployment in dir is not updated to its desired state on this device, or the empty
+// string.
+func Unschedulable(dir string) string {
+	b, _ := os.ReadFile(path.Join(filepath.Dir(dir), ".unschedulable-"+filepath.Base(dir)))
+	return string(b)
+}
+
+// checkRequirements checks the capabilities of the host against the requirements of the component, so components are
+// not started on devices where they would fail right away, e.g. run out of memory. The resources required are
+// declared with the annotations watcher.margo.org/min-memory and watcher.margo.org/min-disk (e.g. 512MiB), the free
+// space of the deploy directory, watcher.margo.org/min-cpus (e.g. 2 or 0.5) and watcher.margo.org/requires-gpu
+// (true or the number of GPUs). Resources which cannot be determined, e.g. of remote hosts, are assumed to suffice.
+// If EnforceRequirements is set, the annotation watcher.margo.org/requires-arch, a comma-separated list of
+// architectures (e.g. amd64,arm64 or linux/arm64), and watcher.margo.org/requires-runtime, a runtime optionally
+// followed by a version constraint (e.g. "docker >= 24"), are checked as well. Components the device cannot run are
+// left as they are. The capabilities are collected by capabilities once they are needed.
+func (r *Reconciler) checkRequirements(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, capabilities func() Capabilities) error {
+	reasons, err := r.checkResources(deployments, component, capabilities)
+	if err != nil {
+		return err
+	}
+	if archs := deployments.Annotation(component, "requires-arch"); archs != "" && r.EnforceRequirements {
+		if c := capabilities(); c.Arch != "" && !slices.ContainsFunc(strings.Split(archs, ","), func(arch string) bool {
+			arch = strings.TrimSpace(arch)
+			return arch == c.Arch || arch == c.OS+"/"+c.Arch
+		}) {
+			reasons = append(reasons, fmt.Sprintf("requires architecture %s, device is %s/%s", archs, c.OS, c.Arch))
+		}
+	}
+	if required := deployments.Annotation(component, "requires-runtime"); required != "" && r.EnforceRequirements {
+		name, spec, _ := strings.Cut(strings.TrimSpace(required), " ")
+		version, found := capabilities().Runtimes[name]
+		switch {
+		case !found:
+			reasons = append(reasons, fmt.Sprintf("requires runtime %s, which is unavailable", name))
+		case strings.TrimSpace(spec) != "":
+			constraint, err := semver.NewConstraint(spec)
+			if err != nil {
+				return fmt.Errorf("invalid requires-runtime %q: %w", required, err)
+			}
+			if v, err := semver.NewVersion(version); err != nil || !constraint.Check(v) {
+				reasons = append(reasons, fmt.Sprintf("requires %s, device runs %s %s", required, name, version))
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		r.clearUnschedulable(component.Name)
+		return nil
+	}
+	reason := strings.Join(reasons, "; ")
+	err = fmt.Errorf("%w: %s", ErrUnschedulable, reason)
+	// reported once per reason
+	if b, _ := os.ReadFile(r.unschedulableFile(component.Name)); string(b) != reason {
+		if err := os.WriteFile(r.unschedulableFile(component.Name), []byte(reason), 0o644); err != nil {
+			log.Printf("WARN: %s: failed to record unschedulable component: %s", component.Name, err)
+		}
+		r.emit(ctx, notify.Event{Type: notify.EventFailed, Deployment: deployments.Metadata.Name, Component: component.Name, Package: component.Properties.PackageLocation, Error: err.Error(), Code: errdefs.Code(err)})
+	}
+	return err
+}
+
+// checkResources returns why the host lacks the resources required by the component.
+func (r *Reconciler) checkResources(deployments *deployment.ApplicationDeployment, component deployment.Component, capabilities func() Capabilities) ([]string, error) {
+	var reasons []string
+	if value := deployments.Annotation(component, "min-memory"); value != "" {
+		required, err := fsutil.ParseBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min-memory %q: %w", value, err)
+		}
+		if c := capabilities(); c.Memory > 0 && c.Memory < required {
+			reasons = append(reasons, fmt.Sprintf("requires %s memory, device has %s", fsutil.FormatBytes(required), fsutil.FormatBytes(c.Memory)))
+		}
+	}
+	if value := deployments.Annotation(component, "min-cpus"); value != "" {
+		required, err := strconv.ParseFloat(value, 64)
+		if err != nil || required < 0 {
+			return nil, fmt.Errorf("invalid min-cpus %q", value)
+		}
+		if c := capabilities(); c.CPUs > 0 && float64(c.CPUs) < required {
+			reasons = append(reasons, fmt.Sprintf("requires %s CPUs, device has %d", value, c.CPUs))
+		}
+	}
+	if value := deployments.Annotation(component, "min-disk"); value != "" {
+		required, err := fsutil.ParseBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min-disk %q: %w", value, err)
+		}
+		if free, _, err := fsutil.FreeSpace(r.DeployDir); err == nil && free < required {
+			reasons = append(reasons, fmt.Sprintf("requires %s free disk space, device has %s", fsutil.FormatBytes(required), fsutil.FormatBytes(free)))
+		}
+	}
+	if value := deployments.Annotation(component, "requires-gpu"); value != "" {
+		required, err := strconv.Atoi(value)
+		if err != nil {
+			var gpu bool
+			if gpu, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid requires-gpu %q", value)
+			}
+			required = 0
+			if gpu {
+				required = 1
+			}
+		}
+		if c := capabilities(); c.Host == "" && len(c.GPUs) < required {
+			reasons = append(reasons, fmt.Sprintf("requires %d GPUs, device has %d", required, len(c.GPUs)))
+		}
+	}
+	return reasons, nil
+}
+
+// clearUnschedulable forgets why the component could not run once it can or is no longer desired.
+func (r *Reconciler) clearUnschedulable(component string) {
+	_ = os.Remove(r.unschedulableFile(component))
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+)
+
+// ErrIncompatible is returned for components requiring a newer watcher.
+var ErrIncompatible = errors.New("requires a newer watcher")
+
+// incompatibleFile records the watcher version required by a component which is held back.
+func (r *Reconciler) incompatibleFile(component string) string {
+	return path.Join(r.DeployDir, ".incompatible-"+component)
+}
+
+// Incompatible returns the watcher version required by the deployment in dir if the running watcher is too old for
+// its desired state, or the empty string.
+func Incompatible(dir string) string {
+	b, _ := os.ReadFile(path.Join(filepath.Dir(dir), ".incompatible-"+filepath.Base(dir)))
+	return string(b)
+}
+
+// checkCompatible checks the watcher version against the annotation watcher.margo.org/min-watcher-version, either a
+// minimum version (e.g. 1.4.0) or a constraint (e.g. ">= 1.4, < 2"). Newer fields of the desired state would be
+// ignored silently by older watchers, so components requiring a newer one are left as they are. Development builds
+// without version satisfy every requirement.
+func (r *Reconciler) checkCompatible(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component) error {
+	required := deployments.Annotation(component, "min-watcher-version")
+	if required == "" || isSelfUpdate(deployments, component) {
+		r.clearIncompatible(component.Name)
+		return nil
+	}
+	spec := required
+	if _, err := semver.NewVersion(required); err == nil {
+		spec = ">= " + required
+	}
+	constraint, err := semver.NewConstraint(spec)
+	if err != nil {
+		return fmt.Errorf("invalid min-watcher-version %q: %w", required, err)
+	}
+	current, err := semver.NewVersion(r.Version)
+	if err != nil {
+		r.clearIncompatible(component.Name)
+		return nil
+	}
+	if constraint.Check(current) {
+		r.clearIncompatible(component.Name)
+		return nil
+	}
+	err = fmt.Errorf("%w: version %s does not satisfy %q", ErrIncompatible, r.Version, required)
+	// reported once per requirement
+	if b, _ := os.ReadFile(r.incompatibleFile(component.Name)); string(b) != required {
+		if err := os.WriteFile(r.incompatibleFile(component.Name), []byte(required), 0o644); err != nil {
+			log.Printf("WARN: %s: failed to record incompatibility: %s", component.Name, err)
+		}
+		r.emit(ctx, notify.Event{Type: notify.EventFailed, Deployment: deployments.Metadata.Name, Component: component.Name, Package: component.Properties.PackageLocation, Error: err.Error(), Code: errdefs.Code(err)})
+	}
+	return err
+}
+
+// clearIncompatible forgets the incompatibility of the component once it is resolved or no longer desired.
+func (r *Reconciler) clearIncompatible(component string) {
+	_ = os.Remove(r.incompatibleFile(component))
+}
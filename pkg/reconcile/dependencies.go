@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+)
+
+// readinessPollInterval is the interval in which the readiness of dependencies is checked.
+const readinessPollInterval = 2 * time.Second
+
+// plannedComponent is a component of the desired state deployed on this device.
+type plannedComponent struct {
+	deployments *deployment.ApplicationDeployment
+	component   deployment.Component
+	hold        hold
+}
+
+// dependsOn returns the components the component depends on according to its annotation watcher.margo.org/depends-on,
+// a comma-separated list of components of the same namespace. An annotation of the deployment applies to all its
+// components except those it names.
+func (p plannedComponent) dependsOn() []string {
+	var names []string
+	for _, name := range strings.Split(p.deployments.Annotation(p.component, "depends-on"), ",") {
+		if name = strings.TrimSpace(name); name != "" && name != p.component.Name && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// orderComponents sorts the components so that every component follows those it depends on, keeping the order of the
+// desired state otherwise. Dependencies on components which are not deployed on this device and cycles are errors.
+func orderComponents(planned []plannedComponent) ([]plannedComponent, error) {
+	index := make(map[string]int, len(planned))
+	for i, p := range planned {
+		index[p.component.Name] = i
+	}
+	pending := make([]int, len(planned))
+	dependents := make([][]int, len(planned))
+	for i, p := range planned {
+		for _, name := range p.dependsOn() {
+			j, found := index[name]
+			if !found {
+				return nil, fmt.Errorf("%s: depends on component %s, which is not deployed on this device", p.component.Name, name)
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	ordered := make([]plannedComponent, 0, len(planned))
+	done := make([]bool, len(planned))
+	for len(ordered) < len(planned) {
+		// the first component whose dependencies are deployed
+		next := -1
+		for i := range planned {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i, p := range planned {
+				if !done[i] {
+					cycle = append(cycle, p.component.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between components %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		ordered = append(ordered, planned[next])
+		for _, i := range dependents[next] {
+			pending[i]--
+		}
+	}
+	return ordered, nil
+}
+
+// waitForDependencies waits until the components the component depends on are ready, at most DependencyTimeout.
+func (r *Reconciler) waitForDependencies(ctx context.Context, p plannedComponent) error {
+	dependencies := p.dependsOn()
+	if len(dependencies) == 0 {
+		return nil
+	}
+	timeout := cmp.Or(r.DependencyTimeout, 5*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, name := range dependencies {
+		dir := path.Join(r.DeployDir, name)
+		logged := false
+		for {
+			ready, err := r.ready(ctx, dir)
+			if err != nil {
+				log.Printf("WARN: %s: failed to check readiness of %s: %s", p.component.Name, name, err)
+			}
+			if ready {
+				break
+			}
+			if !logged {
+				log.Printf("%s: waiting for %s to become ready", p.component.Name, name)
+				logged = true
+			}
+			select {
+			case <-ctx.Done():
+				r.captureLogs(ctx, name, dir)
+				return fmt.Errorf("%s: dependency %s is not ready after %s", p.component.Name, name, timeout)
+			case <-time.After(readinessPollInterval):
+			}
+		}
+	}
+	return nil
+}
+
+// ready reports whether the deployment in dir is ready, see backend.ReadinessChecker.
+func (r *Reconciler) ready(ctx context.Context, dir string) (bool, error) {
+	b := r.DeploymentBackend(dir)
+	if checker, ok := b.(backend.ReadinessChecker); ok {
+		return checker.Ready(ctx, dir)
+	}
+	status, err := b.Status(ctx, dir)
+	return status == backend.StatusRunning, err
+}
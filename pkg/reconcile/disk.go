@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+)
+
+var (
+	// ErrInsufficientDisk is returned if a package does not fit on the filesystems it is downloaded and extracted to.
+	ErrInsufficientDisk = errors.New("insufficient disk space")
+	// ErrQuotaExceeded is returned if an extracted package would exceed the disk quota of deployments.
+	ErrQuotaExceeded = errors.New("disk quota exceeded")
+)
+
+// checkDiskSpace fails early if the package of the component does not fit on the filesystems of the temporary
+// directory, the deploy directory and the cache, rather than running out of space while extracting it. The
+// extracted package is estimated as ExtractionFactor times its size.
+func (r *Reconciler) checkDiskSpace(ctx context.Context, component deployment.Component, tempDir string) error {
+	if r.ExtractionFactor <= 0 {
+		return nil
+	}
+	location := component.Properties.PackageLocation
+	size, err := r.Registry.Size(ctx, location)
+	if err != nil || size <= 0 {
+		log.Printf("WARN: %s: skipping disk space check, size of package unknown: %v", component.Name, err)
+		return nil
+	}
+	extracted := uint64(float64(size) * r.ExtractionFactor)
+
+	type filesystem struct {
+		dirs     []string
+		free     uint64
+		required uint64
+	}
+	var filesystems []*filesystem
+	devices := make(map[uint64]*filesystem)
+	require := func(dir string, required uint64) error {
+		free, device, err := fsutil.FreeSpace(dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to determine free space of %s: %w", dir, err)
+		}
+		fs, found := devices[device]
+		if !found {
+			fs = &filesystem{free: free}
+			devices[device] = fs
+			filesystems = append(filesystems, fs)
+		}
+		if !slices.Contains(fs.dirs, dir) {
+			fs.dirs = append(fs.dirs, dir)
+		}
+		fs.required += required
+		return nil
+	}
+	if err := require(tempDir, extracted); err != nil {
+		return err
+	}
+	if err := require(r.DeployDir, extracted); err != nil {
+		return err
+	}
+	if r.Registry.Cache != nil {
+		if _, dgst, err := registry.ParseBlobLocation(location); err == nil && !r.Registry.Cache.Has(dgst) {
+			if err := require(r.Registry.Cache.Dir(), uint64(size)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fs := range filesystems {
+		if fs.required > fs.free {
+			return &errdefs.DiskError{Err: fmt.Errorf("%w for package of %s on the filesystem of %s: %s free, about %s required", ErrInsufficientDisk, fsutil.FormatBytes(uint64(size)), strings.Join(fs.dirs, ", "), fsutil.FormatBytes(fs.free), fsutil.FormatBytes(fs.required))}
+		}
+	}
+	return nil
+}
+
+// checkQuota rejects the app if its extracted files exceed the disk quota of deployments.
+func (r *Reconciler) checkQuota(app string) error {
+	if r.DiskQuota == 0 {
+		return nil
+	}
+	f, err := os.Open(app)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := fsutil.TgzSize(f)
+	if err != nil {
+		return err
+	}
+	if size > r.DiskQuota {
+		return &errdefs.DiskError{Err: fmt.Errorf("%w: app extracts to %s, the quota is %s", ErrQuotaExceeded, fsutil.FormatBytes(size), fsutil.FormatBytes(r.DiskQuota))}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+)
+
+// checksumsFile records the files unpacked from the app of a deployment.
+const checksumsFile = ".checksums"
+
+// fileChecksum describes a file unpacked from the app. Files are only hashed again if their size or modification time
+// changed.
+type fileChecksum struct {
+	Digest  string    `json:"digest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func fileDigest(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksums records the files of the app unpacked in dir. Hidden top-level entries hold internal state and
+// secrets, they are not part of the app. Neither are the persistent paths, which belong to the application's data.
+func writeChecksums(dir string) error {
+	persistent := readPersistentPaths(dir)
+	checksums := make(map[string]fileChecksum)
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		if (strings.HasPrefix(rel, ".") && rel != ".") || isPersistent(persistent, rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		digest, err := fileDigest(file)
+		if err != nil {
+			return err
+		}
+		checksums[filepath.ToSlash(rel)] = fileChecksum{Digest: digest, Size: info.Size(), ModTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(checksums, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, checksumsFile), b, 0o644)
+}
+
+// readChecksums returns the recorded files of the deployment in dir, nil if it was installed without checksums.
+func readChecksums(dir string) (map[string]fileChecksum, error) {
+	b, err := os.ReadFile(path.Join(dir, checksumsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checksums map[string]fileChecksum
+	if err := json.Unmarshal(b, &checksums); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// drift describes the files of the deployment in dir which were modified or deleted since it was installed, sorted
+// by name. Files added to the deployment, e.g. data written by the containers, are not drift.
+func drift(dir string) ([]string, error) {
+	checksums, err := readChecksums(dir)
+	if err != nil {
+		return nil, err
+	}
+	var drifted []string
+	for name, recorded := range checksums {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := os.Stat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			drifted = append(drifted, name+" deleted")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() == recorded.Size && info.ModTime().Equal(recorded.ModTime) {
+			continue
+		}
+		if digest, err := fileDigest(file); err != nil {
+			return nil, err
+		} else if digest != recorded.Digest {
+			drifted = append(drifted, name+" modified")
+		}
+	}
+	slices.Sort(drifted)
+	return drifted, nil
+}
+
+// restoreDrift restores the files of the app from its package and restarts the deployment. Files added to the
+// deployment and the persistent paths are kept.
+func (r *Reconciler) restoreDrift(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, destDir string, drifted []string) error {
+	log.Printf("WARN: %s: deployment drifted from its package: %s", component.Name, strings.Join(drifted, ", "))
+	r.emit(ctx, notify.Event{Type: notify.EventDrifted, Deployment: deployments.Metadata.Name, Component: component.Name, Package: component.Properties.PackageLocation, Error: strings.Join(drifted, ", ")})
+
+	tempDir, err := os.MkdirTemp("", component.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	app, _, err := r.fetch(ctx, component, tempDir)
+	if err != nil {
+		return err
+	}
+
+	b := r.DeploymentBackend(destDir)
+	if err := b.Stop(ctx, destDir); err != nil {
+		return err
+	}
+	// unpacking does not truncate existing files
+	checksums, err := readChecksums(destDir)
+	if err != nil {
+		return err
+	}
+	for name := range checksums {
+		_ = os.Remove(filepath.Join(destDir, filepath.FromSlash(name)))
+	}
+	// the package must not overwrite the data, so it is set aside while unpacking
+	persistent := readPersistentPaths(destDir)
+	stash := path.Join(r.DeployDir, ".restore-"+component.Name)
+	_ = os.RemoveAll(stash)
+	if err := movePersistent(destDir, stash, persistent); err != nil {
+		return err
+	}
+	err = unpackApp(app, destDir)
+	if err := movePersistent(stash, destDir, persistent); err != nil {
+		return err
+	}
+	_ = os.RemoveAll(stash)
+	if err != nil {
+		return err
+	}
+	if err := writeChecksums(destDir); err != nil {
+		return err
+	}
+	log.Printf("%s: restored deployment from its package", component.Name)
+	return b.EnsureRunning(ctx, destDir)
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+)
+
+const (
+	// envFile is read by docker compose to interpolate the compose file.
+	envFile = ".env"
+	// localEnvFile holds environment variables of the device, which override those of the desired state. The watcher
+	// never modifies it and carries it over to new versions of the deployment.
+	localEnvFile = ".env.local"
+)
+
+// envNameRe matches the names of environment variables. Parameters targeting other pointers are left to other
+// backends.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envVar is a parameter value passed to a component as environment variable.
+type envVar struct {
+	name  string
+	value string
+}
+
+// resolveParameters returns the values of the parameters of the desired state targeting the component as
+// environment variables, named after the pointers of their targets.
+func resolveParameters(deployments *deployment.ApplicationDeployment, component deployment.Component) ([]envVar, error) {
+	var vars []envVar
+	for name, param := range deployments.Spec.Parameters {
+		if param.SecretRef != "" {
+			continue
+		}
+		for _, target := range param.Targets {
+			if !slices.Contains(target.Components, component.Name) || !envNameRe.MatchString(target.Pointer) {
+				continue
+			}
+			if strings.ContainsAny(param.Value, "\n\r") {
+				return nil, fmt.Errorf("parameter %s: multi-line values cannot be passed as environment variables", name)
+			}
+			vars = append(vars, envVar{name: target.Pointer, value: param.Value})
+		}
+	}
+	slices.SortFunc(vars, func(a, b envVar) int { return strings.Compare(a.name, b.name) })
+	return vars, nil
+}
+
+// parametersDigest returns the digest of the parameters targeting the component, the empty string if there are
+// none. A component is installed again if it changes, as its environment changes.
+func parametersDigest(deployments *deployment.ApplicationDeployment, component deployment.Component) string {
+	var names []string
+	for name, param := range deployments.Spec.Parameters {
+		for _, target := range param.Targets {
+			if slices.Contains(target.Components, component.Name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	slices.Sort(names)
+	h := sha256.New()
+	for _, name := range names {
+		param := deployments.Spec.Parameters[name]
+		fmt.Fprintf(h, "%q=%q/%q\n", name, param.Value, param.SecretRef)
+		for _, target := range param.Targets {
+			if slices.Contains(target.Components, component.Name) {
+				fmt.Fprintf(h, "  %q\n", target.Pointer)
+			}
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// writeEnv writes the .env file of the deployment in dir from the .env of the package, the parameters, the secrets and
+// the device-local .env.local, which take precedence in this order as later assignments win. The file is only
+// readable by the watcher. It is left alone if there is nothing to add.
+func writeEnv(dir string, params []envVar, secrets []secret) error {
+	pkgEnv, err := os.ReadFile(path.Join(dir, envFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	local, err := os.ReadFile(path.Join(dir, localEnvFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(params) == 0 && len(secrets) == 0 && len(local) == 0 {
+		return nil
+	}
+	var env strings.Builder
+	env.WriteString("# generated by oci-watcher from the .env of the package, parameters, secrets and " + localEnvFile + "\n")
+	writeLines(&env, pkgEnv)
+	for _, v := range params {
+		fmt.Fprintf(&env, "%s=%s\n", v.name, quoteEnv(v.value))
+	}
+	for _, s := range secrets {
+		for _, name := range s.env {
+			fmt.Fprintf(&env, "%s=%s\n", name, quoteEnv(s.value))
+		}
+	}
+	if len(local) > 0 {
+		env.WriteString("# " + localEnvFile + "\n")
+		writeLines(&env, local)
+	}
+	if err := os.WriteFile(path.Join(dir, envFile), []byte(env.String()), 0o600); err != nil {
+		return err
+	}
+	// the package may have shipped its .env readable by everyone
+	return os.Chmod(path.Join(dir, envFile), 0o600)
+}
+
+// writeLines writes b, terminating its last line.
+func writeLines(w *strings.Builder, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	w.Write(b)
+	if b[len(b)-1] != '\n' {
+		w.WriteByte('\n')
+	}
+}
+
+// keepLocalEnv copies the device-local .env.local of the installed version in previousDir to dir.
+func keepLocalEnv(previousDir, dir string) error {
+	if previousDir == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path.Join(previousDir, localEnvFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, localEnvFile), b, 0o600)
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+)
+
+// Failures counts the failed reconciliations of components by error code. A nil Failures counts nothing.
+type Failures struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// Counts returns the number of failed reconciliations of components by error code, see errdefs.Code.
+func (f *Failures) Counts() map[string]int {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.counts)
+}
+
+func (f *Failures) add(code string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+	}
+	f.counts[code]++
+}
+
+// failureFile records the error of the last failed reconciliation of a component.
+func (r *Reconciler) failureFile(component string) string {
+	return path.Join(r.DeployDir, ".failure-"+component)
+}
+
+// LastFailure returns the code and message of the error the last reconciliation of the deployment in dir failed
+// with, empty strings if it succeeded.
+func LastFailure(dir string) (code, message string) {
+	b, _ := os.ReadFile(path.Join(filepath.Dir(dir), ".failure-"+filepath.Base(dir)))
+	code, message, _ = strings.Cut(string(b), "\n")
+	return code, message
+}
+
+// failedAttempts records the failed reconciliations of a component with the same package and parameters, so they are
+// retried with a growing delay instead of being downloaded and installed in every reconciliation.
+type failedAttempts struct {
+	Package    string    `json:"package"`
+	Parameters string    `json:"parameters"`
+	Attempts   int       `json:"attempts"`
+	Failed     time.Time `json:"failed"`
+}
+
+// maxRetryDelay limits the delay between retries of a failed component.
+const maxRetryDelay = time.Hour
+
+func (r *Reconciler) attemptsFile(component string) string {
+	return path.Join(r.DeployDir, ".attempts-"+component)
+}
+
+// retryIn returns how long the component, which failed with the same package and parameters before, is left alone.
+// The delay starts at a minute and doubles with every attempt. Redeploys are not delayed.
+func (r *Reconciler) retryIn(deployments *deployment.ApplicationDeployment, component deployment.Component) (time.Duration, int) {
+	b, err := os.ReadFile(r.attemptsFile(component.Name))
+	if err != nil || fsutil.FileExists(path.Join(r.DeployDir, component.Name, redeployFile)) {
+		return 0, 0
+	}
+	var a failedAttempts
+	if json.Unmarshal(b, &a) != nil || a.Package != component.Properties.PackageLocation || a.Parameters != parametersDigest(deployments, component) {
+		return 0, 0
+	}
+	delay := maxRetryDelay
+	if a.Attempts <= 6 {
+		delay = min(time.Minute<<(a.Attempts-1), maxRetryDelay)
+	}
+	return time.Until(a.Failed.Add(delay)), a.Attempts
+}
+
+// fail records and reports the failed reconciliation of the component.
+func (r *Reconciler) fail(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, err error) {
+	code := errdefs.Code(err)
+	r.Failures.add(code)
+	attempts := failedAttempts{Package: component.Properties.PackageLocation, Parameters: parametersDigest(deployments, component), Attempts: 1, Failed: time.Now()}
+	if b, err := os.ReadFile(r.attemptsFile(component.Name)); err == nil {
+		var previous failedAttempts
+		if json.Unmarshal(b, &previous) == nil && previous.Package == attempts.Package && previous.Parameters == attempts.Parameters {
+			attempts.Attempts = previous.Attempts + 1
+		}
+	}
+	if b, err := json.Marshal(attempts); err == nil {
+		_ = os.WriteFile(r.attemptsFile(component.Name), b, 0o644)
+	}
+	_ = os.WriteFile(r.failureFile(component.Name), []byte(code+"\n"+err.Error()), 0o644)
+	// logs are only captured for failures of the runtime
+	var logs string
+	if errors.As(err, new(*errdefs.RuntimeError)) {
+		logs = FailureLogs(path.Join(r.DeployDir, component.Name))
+	} else {
+		_ = os.Remove(r.logsFile(component.Name))
+	}
+	r.emit(ctx, notify.Event{Type: notify.EventFailed, Deployment: deployments.Metadata.Name, Component: component.Name, Package: component.Properties.PackageLocation, Error: err.Error(), Code: code, Logs: logs})
+}
+
+// clearFailure forgets the failure of the component once it was reconciled or is no longer desired.
+func (r *Reconciler) clearFailure(component string) {
+	_ = os.Remove(r.failureFile(component))
+	_ = os.Remove(r.logsFile(component))
+	_ = os.Remove(r.attemptsFile(component))
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/regclient/regclient/types/platform"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"gopkg.in/yaml.v3"
+)
+
+// HostConfig is a runtime host managed by the watcher in addition to the local one.
+type HostConfig struct {
+	// Name is referenced by the host annotation of components.
+	Name string `yaml:"name"`
+	// DockerHost, CertPath and Context select the Docker daemon, or the Docker-compatible API of Podman, see
+	// backend.Daemon.
+	DockerHost string `yaml:"dockerHost"`
+	CertPath   string `yaml:"certPath"`
+	Context    string `yaml:"context"`
+	// DeployDir holds the deployments of the host, defaults to .hosts/<name> in the local deploy directory.
+	DeployDir string `yaml:"deployDir"`
+	// Labels are matched against component selectors, taking precedence over the device labels.
+	Labels map[string]string `yaml:"labels"`
+	// Platform selects the packages of multi-arch components, e.g. linux/arm64, defaults to the one of the local
+	// host.
+	Platform string `yaml:"platform"`
+}
+
+// Daemon returns the Docker daemon of the host.
+func (h HostConfig) Daemon() backend.Daemon {
+	return backend.Daemon{Host: h.DockerHost, CertPath: h.CertPath, Context: h.Context}
+}
+
+var hostNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// LoadHosts reads the hosts from a YAML file of the form:
+//
+//	hosts:
+//	  - name: line1
+//	    dockerHost: tcp://10.0.1.10:2376
+//	    certPath: /etc/oci-watcher/line1
+//	    labels:
+//	      line: "1"
+//	  - name: line2
+//	    dockerHost: unix:///run/podman/podman.sock
+//	    platform: linux/arm64
+func LoadHosts(path string) ([]HostConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Hosts []HostConfig `yaml:"hosts"`
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, h := range cfg.Hosts {
+		if !hostNameRe.MatchString(h.Name) {
+			return nil, fmt.Errorf("hosts[%d].name: invalid name %q", i, h.Name)
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("hosts[%d].name: duplicate name %q", i, h.Name)
+		}
+		names[h.Name] = true
+		if h.DockerHost == "" && h.Context == "" {
+			return nil, fmt.Errorf("hosts[%d]: dockerHost or context is required", i)
+		}
+		if h.Platform != "" {
+			if _, err := platform.Parse(h.Platform); err != nil {
+				return nil, fmt.Errorf("hosts[%d].platform: %w", i, err)
+			}
+		}
+	}
+	return cfg.Hosts, nil
+}
+
+// Fleet reconciles the local host and further hosts from one desired state. Each host has its own deploy directory
+// and runtime.
+type Fleet struct {
+	// Reconcilers holds one reconciler per host, starting with the local one.
+	Reconcilers []*Reconciler
+}
+
+// NewFleet derives the reconcilers of the hosts from the local one.
+func NewFleet(local *Reconciler, hosts []HostConfig) *Fleet {
+	f := &Fleet{Reconcilers: []*Reconciler{local}}
+	for _, h := range hosts {
+		r := *local
+		r.Host = h.Name
+		if local.Progress != nil {
+			r.Progress = &Progress{}
+		}
+		if local.Failures != nil {
+			r.Failures = &Failures{}
+		}
+		if local.Timings != nil {
+			r.Timings = &InstallTimings{}
+		}
+		r.Backend = onDaemon(local.Backend, h.Daemon())
+		r.Backends = make(map[string]backend.Backend, len(local.Backends))
+		for profileType, b := range local.Backends {
+			r.Backends[profileType] = onDaemon(b, h.Daemon())
+		}
+		r.DeployDir = h.DeployDir
+		if r.DeployDir == "" {
+			r.DeployDir = path.Join(local.DeployDir, ".hosts", h.Name)
+		}
+		if local.BackupDir != "" {
+			r.BackupDir = path.Join(local.BackupDir, ".hosts", h.Name)
+		}
+		if h.Platform != "" {
+			r.Platform, _ = platform.Parse(h.Platform)
+		}
+		r.Labels = maps.Clone(local.Labels)
+		if r.Labels == nil {
+			r.Labels = make(map[string]string)
+		}
+		maps.Copy(r.Labels, h.Labels)
+		f.Reconcilers = append(f.Reconcilers, &r)
+	}
+	return f
+}
+
+// onDaemon returns the backend running deployments on the daemon. Backends which do not use a Docker daemon, such
+// as systemd, are only available on the local host.
+func onDaemon(b backend.Backend, d backend.Daemon) backend.Backend {
+	if db, ok := b.(interface {
+		WithDaemon(backend.Daemon) backend.Backend
+	}); ok {
+		return db.WithDaemon(d)
+	}
+	return localOnly{}
+}
+
+// localOnly rejects deployments on other hosts than the local one.
+type localOnly struct{}
+
+var errLocalOnly = errors.New("deployment profile is only supported on the local host")
+
+func (localOnly) Load(context.Context, string) error          { return errLocalOnly }
+func (localOnly) EnsureRunning(context.Context, string) error { return errLocalOnly }
+func (localOnly) Stop(context.Context, string) error          { return errLocalOnly }
+func (localOnly) Remove(context.Context, string) error        { return errLocalOnly }
+func (localOnly) Status(context.Context, string) (backend.Status, error) {
+	return "", errLocalOnly
+}
+
+// Reconcile loads the desired state once and reconciles every host. A failing host does not keep the others from
+// being reconciled.
+func (f *Fleet) Reconcile(ctx context.Context) error {
+	appDeployments, err := f.Reconcilers[0].Load(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, r := range f.Reconcilers {
+		if err := r.apply(ctx, appDeployments); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// apply applies the desired state, naming the host in errors unless it is the local one.
+func (r *Reconciler) apply(ctx context.Context, appDeployments []*deployment.ApplicationDeployment) error {
+	if r.Host != "" {
+		_ = os.MkdirAll(r.DeployDir, 0o755)
+	}
+	err := r.Apply(ctx, appDeployments)
+	if err != nil && r.Host != "" {
+		return fmt.Errorf("host %s: %w", r.Host, err)
+	}
+	return err
+}
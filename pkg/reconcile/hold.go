@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"gopkg.in/yaml.v3"
+)
+
+// hold is how a component is held back from reconciliation.
+type hold int
+
+const (
+	holdNone hold = iota
+	// holdPinned keeps the installed version of the component, but keeps it running.
+	holdPinned
+	// holdPaused leaves the component alone entirely. It is not purged either.
+	holdPaused
+)
+
+// Overrides are the components held back on the device, see Reconciler.OverridesFile. Components are named as in the
+// desired state, those of other namespaces than the default one as <namespace>/<component>.
+type Overrides struct {
+	Pin   []string `yaml:"pin"`
+	Pause []string `yaml:"pause"`
+}
+
+// loadOverrides reads the overrides file, which may be absent.
+func (r *Reconciler) loadOverrides() Overrides {
+	var o Overrides
+	if r.OverridesFile == "" {
+		return o
+	}
+	b, err := os.ReadFile(r.OverridesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return o
+	}
+	if err == nil {
+		err = yaml.Unmarshal(b, &o)
+	}
+	if err != nil {
+		log.Printf("WARN: ignoring overrides file %s: %s", r.OverridesFile, err)
+	}
+	return o
+}
+
+// hold returns how the component is held back: by the annotations watcher.margo.org/pause and
+// watcher.margo.org/pin set to true, or by the overrides file.
+func (r *Reconciler) hold(overrides Overrides, deployments *deployment.ApplicationDeployment, component deployment.Component) hold {
+	name := component.Name
+	if namespace := namespaceOf(deployments); namespace != defaultNamespace {
+		name = namespace + "/" + name
+	}
+	if paused, _ := strconv.ParseBool(deployments.Annotation(component, "pause")); paused || slices.Contains(overrides.Pause, name) {
+		return holdPaused
+	}
+	if pinned, _ := strconv.ParseBool(deployments.Annotation(component, "pin")); pinned || slices.Contains(overrides.Pin, name) {
+		return holdPinned
+	}
+	return holdNone
+}
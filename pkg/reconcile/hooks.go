@@ -0,0 +1,271 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/proc"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+	"gopkg.in/yaml.v3"
+)
+
+// Hook phases.
+const (
+	// HookPreStop runs before the deployment is taken down for an update or purge, e.g. to back up a database.
+	HookPreStop = "preStop"
+	// HookPostStart runs after a new version of the deployment was started, e.g. to migrate a database. A failure
+	// rolls the update back.
+	HookPostStart = "postStart"
+	// HookPostPurge runs after the runtime resources of a stale deployment were removed, before its directory is
+	// deleted.
+	HookPostPurge = "postPurge"
+)
+
+// HooksFile declares the hooks of a package, see Hooks.
+const HooksFile = "hooks.yaml"
+
+// hookResultsFile records the result of the last run of every hook phase of a deployment.
+const hookResultsFile = ".hooks"
+
+// hookOutputLimit is the number of bytes of the output of a hook kept in its result.
+const hookOutputLimit = 4096
+
+// Hook is a command run on the device in the directory of the deployment. The environment provides
+// OCI_WATCHER_COMPONENT, OCI_WATCHER_PHASE and COMPOSE_PROJECT_NAME.
+type Hook struct {
+	// Command is the executable and its arguments, or a single shell command run with sh -c (cmd /C on Windows).
+	Command []string `yaml:"command"`
+	// Timeout defaults to 5 minutes, after which the command is terminated.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Hooks are the hooks of a component per phase, run in order until one fails.
+type Hooks struct {
+	PreStop   []Hook `yaml:"preStop"`
+	PostStart []Hook `yaml:"postStart"`
+	PostPurge []Hook `yaml:"postPurge"`
+}
+
+func (h Hooks) phase(phase string) []Hook {
+	switch phase {
+	case HookPreStop:
+		return h.PreStop
+	case HookPostStart:
+		return h.PostStart
+	case HookPostPurge:
+		return h.PostPurge
+	}
+	return nil
+}
+
+func (h Hooks) validate() error {
+	for _, phase := range []string{HookPreStop, HookPostStart, HookPostPurge} {
+		for i, hook := range h.phase(phase) {
+			if len(hook.Command) == 0 || hook.Command[0] == "" {
+				return fmt.Errorf("%s[%d].command: missing", phase, i)
+			}
+			if hook.Timeout < 0 {
+				return fmt.Errorf("%s[%d].timeout: negative", phase, i)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadHooks reads the hooks configured on the device per component from a YAML file of the form:
+//
+//	components:
+//	  db:
+//	    preStop:
+//	      - command: [/usr/local/bin/backup-db, db]
+//	        timeout: 10m
+//	    postStart:
+//	      - command: ["docker compose exec -T db migrate"]
+//	  "*":
+//	    postPurge:
+//	      - command: [logger, purged]
+//
+// The hooks of "*" apply to every component and run after its own.
+func LoadHooks(path string) (map[string]Hooks, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Components map[string]Hooks `yaml:"components"`
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	for name, hooks := range cfg.Components {
+		if err := hooks.validate(); err != nil {
+			return nil, fmt.Errorf("components.%s.%w", name, err)
+		}
+	}
+	return cfg.Components, nil
+}
+
+// packageHooks reads the hooks declared by the package of the deployment in dir, if package hooks are enabled.
+func (r *Reconciler) packageHooks(dir string) (Hooks, error) {
+	var hooks Hooks
+	if !r.PackageHooks {
+		return hooks, nil
+	}
+	b, err := os.ReadFile(path.Join(dir, HooksFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return hooks, nil
+	}
+	if err != nil {
+		return hooks, err
+	}
+	if err := yaml.Unmarshal(b, &hooks); err != nil {
+		return hooks, fmt.Errorf("invalid %s: %w", HooksFile, err)
+	}
+	if err := hooks.validate(); err != nil {
+		return hooks, fmt.Errorf("invalid %s: %w", HooksFile, err)
+	}
+	return hooks, nil
+}
+
+// HookResult is the outcome of the last run of the hooks of a phase.
+type HookResult struct {
+	Command  []string      `json:"command"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	// Error is empty if all hooks of the phase succeeded, otherwise Command is the failed one.
+	Error string `json:"error,omitempty"`
+	// Output is the tail of the combined stdout and stderr of Command.
+	Output string `json:"output,omitempty"`
+}
+
+// HookResults returns the results of the hooks run for the deployment in dir, keyed by phase.
+func HookResults(dir string) (map[string]HookResult, error) {
+	b, err := os.ReadFile(path.Join(dir, hookResultsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var results map[string]HookResult
+	if err := json.Unmarshal(b, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// runHooks runs the hooks of the phase for the component deployed in dir: those of its package first, then those
+// configured on the device. The result is recorded with the deployment, and a failure is emitted as event.
+func (r *Reconciler) runHooks(ctx context.Context, component, phase, dir string) error {
+	pkgHooks, err := r.packageHooks(dir)
+	if err != nil {
+		return fmt.Errorf("%s hooks: %w", phase, err)
+	}
+	hooks := slices.Concat(pkgHooks.phase(phase), r.Hooks[component].phase(phase), r.Hooks["*"].phase(phase))
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	project := backend.ProjectName("", component)
+	if b, err := os.ReadFile(path.Join(dir, backend.ProjectFile)); err == nil {
+		project = strings.TrimSpace(string(b))
+	}
+	env := []string{"OCI_WATCHER_COMPONENT=" + component, "OCI_WATCHER_PHASE=" + phase, "COMPOSE_PROJECT_NAME=" + project}
+	start := time.Now()
+	var result HookResult
+	for _, hook := range hooks {
+		log.Printf("%s: running %s hook %s", component, phase, strings.Join(hook.Command, " "))
+		output, err := runHook(ctx, hook, absDir, env)
+		result = HookResult{Command: hook.Command, Output: output}
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+	}
+	result.Time, result.Duration = start.UTC(), time.Since(start).Round(time.Millisecond)
+	r.recordHookResult(component, phase, dir, result)
+	if result.Error != "" {
+		err := fmt.Errorf("%s hook %s: %s", phase, strings.Join(result.Command, " "), result.Error)
+		r.emit(ctx, notify.Event{Type: notify.EventHookFailed, Component: component, Error: err.Error()})
+		return err
+	}
+	return nil
+}
+
+func (r *Reconciler) recordHookResult(component, phase, dir string, result HookResult) {
+	if phase == HookPostPurge {
+		// the directory is removed afterwards
+		return
+	}
+	results, err := HookResults(dir)
+	if results == nil || err != nil {
+		results = make(map[string]HookResult)
+	}
+	results[phase] = result
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path.Join(dir, hookResultsFile), b, 0o644)
+	}
+	if err != nil {
+		log.Printf("WARN: %s: failed to record %s hook result: %s", component, phase, err)
+	}
+}
+
+// runHook runs the hook in dir and returns the tail of its output. It is terminated on timeout or shutdown.
+func runHook(ctx context.Context, hook Hook, dir string, env []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(hook.Timeout, 5*time.Minute))
+	defer cancel()
+	command := hook.Command
+	if len(command) == 1 && strings.ContainsAny(command[0], " \t") {
+		command = proc.Shell(command[0])
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Cancel = func() error {
+		return proc.Terminate(cmd.Process)
+	}
+	cmd.WaitDelay = 10 * time.Second
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	output := &outputTail{}
+	cmd.Stdout, cmd.Stderr = output, output
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", cmp.Or(hook.Timeout, 5*time.Minute))
+	}
+	return strings.TrimSpace(string(output.b)), err
+}
+
+// outputTail keeps the last hookOutputLimit bytes written to it.
+type outputTail struct {
+	b []byte
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.b = append(t.b, p...)
+	if len(t.b) > hookOutputLimit {
+		t.b = t.b[len(t.b)-hookOutputLimit:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+)
+
+// imageHistoryFile records the images of the superseded versions of a component, oldest first.
+func (r *Reconciler) imageHistoryFile(component string) string {
+	return path.Join(r.DeployDir, ".images-"+component)
+}
+
+// pruneImages records the images of the superseded version in previousDir and removes those of versions beyond the
+// retention count, unless a deployment still references them.
+func (r *Reconciler) pruneImages(ctx context.Context, component, previousDir string) {
+	pruner, ok := r.DeploymentBackend(previousDir).(backend.ImagePruner)
+	if !ok {
+		return
+	}
+	images, err := pruner.Images(ctx, previousDir)
+	if err != nil {
+		log.Printf("WARN: %s: failed to list images of previous version: %s", component, err)
+		return
+	}
+	var history [][]string
+	historyFile := r.imageHistoryFile(component)
+	if b, err := os.ReadFile(historyFile); err == nil {
+		_ = json.Unmarshal(b, &history)
+	}
+	history = append(history, images)
+	if len(history) <= r.KeepImages {
+		r.writeImageHistory(component, history)
+		return
+	}
+	superseded := history[:len(history)-r.KeepImages]
+	history = history[len(history)-r.KeepImages:]
+
+	referenced := slices.Concat(history...)
+	// deployments of all namespaces share the images
+	for _, dir := range r.DeploymentDirs() {
+		if p, ok := r.DeploymentBackend(dir).(backend.ImagePruner); ok {
+			inUse, err := p.Images(ctx, dir)
+			if err != nil {
+				log.Printf("WARN: %s: not pruning images, failed to list images of %s: %s", component, filepath.Base(dir), err)
+				return
+			}
+			referenced = append(referenced, inUse...)
+		}
+	}
+	var unused []string
+	for _, image := range slices.Concat(superseded...) {
+		if !slices.Contains(referenced, image) && !slices.Contains(unused, image) {
+			unused = append(unused, image)
+		}
+	}
+	if err := pruner.RemoveImages(ctx, unused); err != nil {
+		log.Printf("WARN: %s: failed to prune images: %s", component, err)
+		return
+	}
+	r.writeImageHistory(component, history)
+}
+
+func (r *Reconciler) writeImageHistory(component string, history [][]string) {
+	b, err := json.Marshal(history)
+	if err == nil {
+		err = os.WriteFile(r.imageHistoryFile(component), b, 0o644)
+	}
+	if err != nil {
+		log.Printf("WARN: %s: failed to record images: %s", component, err)
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+)
+
+// logsFile records the logs captured when a component failed.
+func (r *Reconciler) logsFile(component string) string {
+	return path.Join(r.DeployDir, ".logs-"+component)
+}
+
+// FailureLogs returns the logs of the services of the deployment in dir captured when it last failed, see
+// Reconciler.FailureLogLines.
+func FailureLogs(dir string) string {
+	b, _ := os.ReadFile(path.Join(filepath.Dir(dir), ".logs-"+filepath.Base(dir)))
+	return string(b)
+}
+
+// captureLogs records the last FailureLogLines lines of the logs of each service of the deployment in dir and writes
+// them to the log, so operators can diagnose the failure without access to the device. They are reported with the
+// failed event of the component.
+func (r *Reconciler) captureLogs(ctx context.Context, component, dir string) {
+	if r.FailureLogLines <= 0 {
+		return
+	}
+	reader, ok := r.DeploymentBackend(dir).(backend.LogReader)
+	if !ok {
+		return
+	}
+	// the failure may have been a timeout
+	logs, err := reader.Logs(context.WithoutCancel(ctx), dir, r.FailureLogLines)
+	if err != nil {
+		log.Printf("WARN: %s: failed to capture logs: %s", component, err)
+		return
+	}
+	if strings.TrimSpace(logs) == "" {
+		return
+	}
+	log.Printf("%s: last log lines of its services:\n%s", component, strings.TrimRight(logs, "\n"))
+	if err := os.WriteFile(r.logsFile(component), []byte(logs), 0o644); err != nil {
+		log.Printf("WARN: %s: failed to record logs: %s", component, err)
+	}
+}
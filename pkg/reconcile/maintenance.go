@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+)
+
+// pendingPurge is recorded for deferred purges instead of a package.
+const pendingPurge = "purge"
+
+// pendingFile records the change of a component deferred until the next maintenance window.
+func (r *Reconciler) pendingFile(component string) string {
+	return path.Join(r.DeployDir, ".pending-"+component)
+}
+
+// Pending returns the package of the deferred update of the deployment in dir, "purge" for a deferred purge, or
+// the empty string.
+func Pending(dir string) string {
+	b, _ := os.ReadFile(path.Join(filepath.Dir(dir), ".pending-"+filepath.Base(dir)))
+	return string(b)
+}
+
+// changeAllowed reports whether the component may be changed now: once its rollout is due and within a maintenance
+// window. Components with the annotation watcher.margo.org/emergency set to true are changed right away.
+func (r *Reconciler) changeAllowed(deployments *deployment.ApplicationDeployment, component deployment.Component) (bool, string) {
+	now := time.Now()
+	allowed, reason := r.Maintenance.Allowed(now)
+	if due := r.rolloutDue(deployments, component, now); now.Before(due) {
+		allowed, reason = false, "staged rollout, due "+due.Format(time.RFC3339)
+	}
+	if !allowed {
+		if emergency, _ := strconv.ParseBool(deployments.Annotation(component, "emergency")); emergency {
+			log.Printf("WARN: %s: applying emergency change: %s", component.Name, reason)
+			return true, ""
+		}
+	}
+	return allowed, reason
+}
+
+// rolloutDue returns when the component may be changed to its package, so that devices do not all update at once.
+// The change is delayed by RolloutStagger plus a share of the annotation watcher.margo.org/rollout-delay (e.g. 30m)
+// derived from the device ID and the package, counted from when the change was first deferred.
+func (r *Reconciler) rolloutDue(deployments *deployment.ApplicationDeployment, component deployment.Component, now time.Time) time.Time {
+	var delay time.Duration
+	if value := deployments.Annotation(component, "rollout-delay"); value != "" {
+		var err error
+		if delay, err = time.ParseDuration(value); err != nil || delay < 0 {
+			log.Printf("WARN: %s: ignoring invalid rollout delay %q", component.Name, value)
+			delay = 0
+		}
+	}
+	if delay == 0 && r.RolloutStagger <= 0 {
+		return time.Time{}
+	}
+	pkg := component.Properties.PackageLocation
+	seen := now
+	if info, err := os.Stat(r.pendingFile(component.Name)); err == nil && Pending(path.Join(r.DeployDir, component.Name)) == pkg {
+		seen = info.ModTime()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(r.DeviceID + "/" + pkg))
+	offset := max(r.RolloutStagger, 0)
+	if delay > 0 {
+		offset += time.Duration(h.Sum64() % uint64(delay))
+	}
+	return seen.Add(offset)
+}
+
+// deferChange records the pending change of the component. It is logged and emitted once per pending package.
+func (r *Reconciler) deferChange(ctx context.Context, deploymentName, component, pkg, reason string) {
+	if b, err := os.ReadFile(r.pendingFile(component)); err == nil && string(b) == pkg {
+		return
+	}
+	if err := os.WriteFile(r.pendingFile(component), []byte(pkg), 0o644); err != nil {
+		log.Printf("WARN: %s: failed to record pending change: %s", component, err)
+	}
+	ev := notify.Event{Type: notify.EventDeferred, Deployment: deploymentName, Component: component, Error: reason}
+	change := pendingPurge
+	if pkg != pendingPurge {
+		change, ev.Package = "update to "+pkg, pkg
+	}
+	log.Printf("%s: deferring %s: %s", component, change, reason)
+	r.emit(ctx, ev)
+}
+
+// clearPending forgets the deferred change of the component once it was applied or is no longer desired.
+func (r *Reconciler) clearPending(component string) {
+	_ = os.Remove(r.pendingFile(component))
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// metadataFile records the Metadata of a deployment.
+const metadataFile = ".metadata.json"
+
+// legacyHashFile recorded the hex-encoded sha256 digest of the package before Metadata was introduced.
+const legacyHashFile = ".hash"
+
+// Metadata describes what a deployment was installed from.
+type Metadata struct {
+	// Deployment is the name of the ApplicationDeployment of the component.
+	Deployment string `json:"deployment,omitempty"`
+	// Package is the packageLocation, Digest the digest of the package.
+	Package string `json:"package,omitempty"`
+	Digest  string `json:"digest"`
+	// DesiredState is the digest of the ApplicationDeployment the component was installed from.
+	DesiredState string `json:"desiredState,omitempty"`
+	// Parameters is the digest of the parameters of the desired state targeting the component, see
+	// parametersDigest.
+	Parameters string `json:"parameters,omitempty"`
+	// KeyFingerprints are those of the public key from keyLocation.
+	KeyFingerprints []string `json:"keyFingerprints,omitempty"`
+	// Version is taken from the annotation watcher.margo.org/version of the component.
+	Version string    `json:"version,omitempty"`
+	Applied time.Time `json:"applied"`
+	// Images are those of the deployment at the time it was installed, see backend.ImagePruner.
+	Images []string `json:"images,omitempty"`
+	// Timings are the durations of the phases of the installation.
+	Timings Timings `json:"timings,omitempty"`
+}
+
+// ReadMetadata returns the metadata of the deployment in dir, nil if it has none. Deployments installed before
+// metadata was recorded only provide the digest. Metadata without a valid digest is rejected.
+func ReadMetadata(dir string) (*Metadata, error) {
+	var m Metadata
+	b, err := os.ReadFile(path.Join(dir, metadataFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		hash, err := os.ReadFile(path.Join(dir, legacyHashFile))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m.Digest = "sha256:" + strings.TrimSpace(string(hash))
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("corrupt metadata: %w", err)
+		}
+		m.Digest = strings.TrimSpace(m.Digest)
+	}
+	if _, err := digest.Parse(m.Digest); err != nil {
+		return nil, fmt.Errorf("corrupt metadata: %w", err)
+	}
+	return &m, nil
+}
+
+// writeMetadata records the metadata of the deployment in dir, replacing the legacy hash file.
+func writeMetadata(dir string, m *Metadata) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, metadataFile), b, 0o644); err != nil {
+		return err
+	}
+	_ = os.Remove(path.Join(dir, legacyHashFile))
+	return nil
+}
+
+// desiredStateDigest returns the digest of the ApplicationDeployment, which changes with every change of the
+// desired state affecting it.
+func desiredStateDigest(deployments *deployment.ApplicationDeployment) string {
+	b, err := yaml.Marshal(deployments)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// packageDigest returns the digest of the package of the component, which is part of its packageLocation.
+func packageDigest(component deployment.Component) (digest.Digest, error) {
+	_, dgst, err := registry.ParseBlobLocation(component.Properties.PackageLocation)
+	if err != nil {
+		return "", err
+	}
+	if err := dgst.Validate(); err != nil {
+		return "", fmt.Errorf("invalid digest in %s: %w", component.Properties.PackageLocation, err)
+	}
+	return dgst, nil
+}
+
+// resolvePackage points the component at the package for the platform of the host if its packageLocation
+// references an image index, see registry.Client.ResolvePackage.
+func (r *Reconciler) resolvePackage(ctx context.Context, component deployment.Component) (deployment.Component, error) {
+	location, err := r.Registry.ResolvePackage(ctx, component.Properties.PackageLocation, r.platform())
+	if err != nil {
+		return component, err
+	}
+	component.Properties.PackageLocation = location
+	return component, nil
+}
+
+// platform returns the platform packages are resolved for.
+func (r *Reconciler) platform() platform.Platform {
+	if r.Platform.OS == "" {
+		return platform.Local()
+	}
+	return r.Platform
+}
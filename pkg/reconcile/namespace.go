@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"cmp"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+)
+
+// defaultNamespace is the namespace of deployments without one.
+const defaultNamespace = "default"
+
+// namespacesDir holds a deploy directory per namespace other than the default one.
+const namespacesDir = ".namespaces"
+
+func namespaceOf(deployments *deployment.ApplicationDeployment) string {
+	return cmp.Or(deployments.Metadata.Namespace, defaultNamespace)
+}
+
+// managedNamespaces returns the namespaces in which the desired state is applied, starting with the default one if
+// it is managed.
+func (r *Reconciler) managedNamespaces(appDeployments []*deployment.ApplicationDeployment) []string {
+	namespaces := slices.Clone(r.Namespaces)
+	if len(namespaces) == 0 {
+		namespaces = []string{defaultNamespace}
+		for _, deployments := range appDeployments {
+			namespaces = append(namespaces, namespaceOf(deployments))
+		}
+	}
+	slices.Sort(namespaces)
+	namespaces = slices.Compact(namespaces)
+	if i := slices.Index(namespaces, defaultNamespace); i > 0 {
+		namespaces = slices.Insert(slices.Delete(namespaces, i, i+1), 0, defaultNamespace)
+	}
+	return namespaces
+}
+
+// inNamespace returns the reconciler of the deployments in the namespace.
+func (r *Reconciler) inNamespace(namespace string) *Reconciler {
+	if namespace == defaultNamespace {
+		return r
+	}
+	n := *r
+	n.baseDir = r.DeployDir
+	n.DeployDir = path.Join(r.DeployDir, namespacesDir, namespace)
+	if r.BackupDir != "" {
+		n.BackupDir = path.Join(r.BackupDir, namespacesDir, namespace)
+	}
+	return &n
+}
+
+// DeploymentDirs lists the directories of the deployments in all namespaces.
+func (r *Reconciler) DeploymentDirs() []string {
+	base := cmp.Or(r.baseDir, r.DeployDir)
+	roots := []string{base}
+	if entries, err := os.ReadDir(path.Join(base, namespacesDir)); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				roots = append(roots, path.Join(base, namespacesDir, entry.Name()))
+			}
+		}
+	}
+	var dirs []string
+	for _, root := range roots {
+		entries, _ := os.ReadDir(root)
+		for _, entry := range entries {
+			// hidden directories hold internal state such as previous versions
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				dirs = append(dirs, path.Join(root, entry.Name()))
+			}
+		}
+	}
+	return dirs
+}
+
+// ComponentName returns the name of the component deployed in dir, one of DeploymentDirs. Components of other
+// namespaces than the default one are named <namespace>/<component>.
+func (r *Reconciler) ComponentName(dir string) string {
+	name, _ := filepath.Rel(cmp.Or(r.baseDir, r.DeployDir), dir)
+	return strings.TrimPrefix(filepath.ToSlash(name), namespacesDir+"/")
+}
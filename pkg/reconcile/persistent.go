@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+)
+
+// persistentFile records the persistent paths of a deployment, one per line.
+const persistentFile = ".persistent"
+
+// persistentPaths returns the paths of the deployment directory the component writes its data to, declared with the
+// annotation watcher.margo.org/persistent-paths, a comma-separated list of relative paths such as data/ or
+// config/local.yaml. They are carried over to new versions instead of being replaced by the package, which only
+// seeds them on the first installation, and are not checked for drift.
+func persistentPaths(deployments *deployment.ApplicationDeployment, component deployment.Component) ([]string, error) {
+	var paths []string
+	for _, p := range strings.Split(deployments.Annotation(component, "persistent-paths"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		cleaned := path.Clean(p)
+		if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.HasPrefix(cleaned, ".") {
+			return nil, fmt.Errorf("invalid persistent path %q, expected a path within the deployment", p)
+		}
+		paths = append(paths, cleaned)
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths), nil
+}
+
+// readPersistentPaths returns the persistent paths recorded for the deployment in dir.
+func readPersistentPaths(dir string) []string {
+	b, _ := os.ReadFile(path.Join(dir, persistentFile))
+	return strings.Fields(string(b))
+}
+
+// writePersistentPaths records the persistent paths of the deployment in dir.
+func writePersistentPaths(dir string, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	return os.WriteFile(path.Join(dir, persistentFile), []byte(strings.Join(paths, "\n")+"\n"), 0o644)
+}
+
+// isPersistent reports whether the file, relative to the deployment directory, is one of the persistent paths or
+// below one.
+func isPersistent(paths []string, file string) bool {
+	file = filepath.ToSlash(file)
+	return slices.ContainsFunc(paths, func(p string) bool {
+		return file == p || strings.HasPrefix(file, p+"/")
+	})
+}
+
+// movePersistent moves the persistent paths present in from to to, replacing what is there. Both directories must be
+// on the same filesystem.
+func movePersistent(from, to string, paths []string) error {
+	for _, p := range paths {
+		src := filepath.Join(from, filepath.FromSlash(p))
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(to, filepath.FromSlash(p))
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return fmt.Errorf("failed to keep %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// keepPersistent carries the data of the persistent paths over from the previous version of the deployment in
+// previousDir to the new one in dir. Paths which were persistent before remain so, so their data is not lost if the
+// annotation is removed by mistake.
+func keepPersistent(previousDir, dir string, paths []string) ([]string, error) {
+	if previousDir == "" || !fsutil.FileExists(previousDir) {
+		return paths, nil
+	}
+	paths = slices.Concat(paths, readPersistentPaths(previousDir))
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	return paths, movePersistent(previousDir, dir, paths)
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"sync"
+	"time"
+)
+
+// Phase is the step a reconciler is at.
+type Phase struct {
+	// Component is empty for steps concerning all components.
+	Component string
+	Step      string
+	Since     time.Time
+}
+
+// Progress tracks the phase of a reconciler, e.g. to debug hangs. A nil Progress tracks nothing.
+type Progress struct {
+	mu    sync.Mutex
+	phase Phase
+}
+
+// Current returns the current phase.
+func (p *Progress) Current() Phase {
+	if p == nil {
+		return Phase{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase
+}
+
+func (p *Progress) set(component, step string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phase = Phase{Component: component, Step: step, Since: time.Now()}
+}
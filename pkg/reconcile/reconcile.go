@@ -0,0 +1,954 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package reconcile converges the local deployments towards the desired state.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/regclient/regclient/types/platform"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/tracing"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/crypt"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/maintenance"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/notify"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/policy"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/sbom"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/scan"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/secrets"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/source"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/verify"
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v3"
+)
+
+// Reconciler installs, updates and purges the components of the desired state in DeployDir. Every component lives
+// in its own subdirectory; hidden directories hold internal state such as previous versions.
+type Reconciler struct {
+	Registry *registry.Client
+	Backend  backend.Backend
+	// Backends run the components of the deployment profile types they are registered for, e.g. swarm, instead of
+	// Backend.
+	Backends map[string]backend.Backend
+	// Verifier checks every package before it is installed, e.g. verify.DefaultChain().
+	Verifier  verify.Verifier
+	Source    source.Source
+	Overlays  []source.Source
+	DeployDir string
+	// Labels of this device, matched against component selectors.
+	Labels map[string]string
+	// Notifier is optional.
+	Notifier *notify.Notifier
+	// SBOM enables fetching the SBOMs attached to packages, which must satisfy the policy and are stored in the
+	// .sbom directory of the deployment. Optional.
+	SBOM *sbom.Policy
+	// Scanner blocks apps whose bundled images have vulnerabilities. Optional. Components may accept findings with
+	// the annotation watcher.margo.org/ignore-vulnerabilities, a comma-separated list of IDs or "*" to skip the scan.
+	Scanner *scan.Gate
+	// Policy admits or rejects components after verification, e.g. to allow only images from certain registries.
+	// Optional.
+	Policy *policy.OPA
+	// ComposeLint rejects or warns about dangerous constructs in compose files. Optional.
+	ComposeLint *backend.LintPolicy
+	// Decryption decrypts packages encrypted at rest. Encrypted packages are rejected if nil.
+	Decryption *crypt.Keys
+	// Secrets resolves secret parameters. Desired states referencing secrets are rejected if nil.
+	Secrets *secrets.Resolver
+	// Host names the runtime host if the watcher manages several, see Fleet. Only components whose host annotation
+	// (watcher.margo.org/host, a comma-separated list of host names) names it are deployed; components without the
+	// annotation belong to the unnamed local host.
+	Host string
+	// PruneImages removes the images of superseded versions of a component after an update, keeping those of the
+	// KeepImages most recent ones. Images still referenced by a deployment are kept.
+	PruneImages bool
+	KeepImages  int
+	// RestoreDrift restores the files of up-to-date deployments which were modified or deleted locally from their
+	// package, emitting a drifted event.
+	RestoreDrift bool
+	// Rollback restores the previous version of a component whose update failed, emitting a rolled-back event.
+	// Otherwise the failed version is left in place and retried.
+	Rollback bool
+	// Namespaces restricts the namespaces of deployments (metadata.namespace) managed by the watcher, e.g. if several
+	// orchestrators share the device; deployments of other namespaces are ignored. Stale components are only purged
+	// in managed namespaces: those listed, or else the default one and those of the desired state. Components of the
+	// default namespace, which is empty or "default", live in DeployDir, those of others in
+	// DeployDir/.namespaces/<namespace>.
+	Namespaces []string
+
+	// DependencyTimeout limits the wait for the components a component depends on (annotation
+	// watcher.margo.org/depends-on) to become ready before it is deployed, defaults to 5 minutes.
+	DependencyTimeout time.Duration
+	// Hooks are run per component before it is stopped, after it is started and after it is purged, see LoadHooks.
+	Hooks map[string]Hooks
+	// PackageHooks runs the hooks declared by packages in HooksFile as well. They run on the device with the
+	// privileges of the watcher.
+	PackageHooks bool
+	// Maintenance restricts updates and purges to maintenance windows. Deferred changes are recorded and emitted as
+	// deferred events. Optional.
+	Maintenance *maintenance.Config
+	// OverridesFile is a YAML file on the device listing components which are pinned to their installed version or
+	// whose reconciliation is paused, see Overrides. It is read in every reconciliation, so operators can hold back
+	// components without restarting the watcher. Components are held back by the annotations
+	// watcher.margo.org/pin and watcher.margo.org/pause as well.
+	OverridesFile string
+	// ExtractionFactor enables checking the free disk space before a package is downloaded, estimating the size of
+	// the extracted package as this multiple of its size. Disabled if zero.
+	ExtractionFactor float64
+	// DiskQuota limits the size of the files of every deployment in bytes. Packages which extract to more are
+	// rejected. Unlimited if zero.
+	DiskQuota uint64
+	// DeviceID spreads the staged rollouts of devices, see RolloutStagger.
+	DeviceID string
+	// RolloutStagger delays every change of this device, e.g. per device group, on top of the share of the rollout
+	// delay annotated on the component (watcher.margo.org/rollout-delay) which is derived from DeviceID.
+	RolloutStagger time.Duration
+	// SelfUpdate replaces the watcher by the binary of components annotated with watcher.margo.org/self-update set
+	// to true. Such components are ignored if nil.
+	SelfUpdate SelfUpdater
+	// Version of the watcher, which components may require a minimum of with the annotation
+	// watcher.margo.org/min-watcher-version. Requirements are not checked unless it is a semantic version.
+	Version string
+	// Progress tracks the phase of the reconciler. Optional.
+	Progress *Progress
+	// Failures counts the failed reconciliations of components. Optional.
+	Failures *Failures
+	// Timings records the durations of the phases of the last installation of every component. Optional.
+	Timings *InstallTimings
+	// ValidateConfig checks the files of packages with the backend before the installed version is stopped, e.g.
+	// that their compose file parses, see backend.Validator.
+	ValidateConfig bool
+	// FailureLogLines is the number of log lines of each service captured when a component fails to start or does
+	// not become ready, see FailureLogs. Disabled if zero.
+	FailureLogLines int
+	// EnforceRequirements leaves components alone whose required architecture or runtime the host does not
+	// provide, see Capabilities. The resources required by components, e.g. memory, are checked regardless.
+	EnforceRequirements bool
+	// BackupDir enables backing up deployments before they are replaced by an update or purged, e.g. to recover
+	// from a bad desired state manually. The files of every deployment, including its secrets, are stored in
+	// BackupDir/<component>/<time>-<reason>/deployment.tgz, those of other namespaces and hosts below .namespaces and
+	// .hosts like their deployments. Disabled if empty.
+	BackupDir string
+	// KeepBackups limits the backups per component, removing the oldest ones. Unlimited if zero.
+	KeepBackups int
+	// BackupVolumes backs up the volumes of deployments as well, see backend.VolumeBackuper.
+	BackupVolumes bool
+	// Platform selects the package of components whose packageLocation references an image index with packages per
+	// platform, see registry.Client.ResolvePackage. Defaults to the platform of the watcher.
+	Platform platform.Platform
+
+	// baseDir is the DeployDir of the default namespace if the reconciler applies another one.
+	baseDir string
+}
+
+// Reconcile runs a single reconcile.
+func (r *Reconciler) Reconcile(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "reconcile", attribute.String("host", r.Host))
+	defer tracing.End(span, &err)
+	defer r.Progress.set("", "idle")
+	r.Progress.set("", "loading desired state")
+	appDeployments, err := r.Load(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Apply(ctx, appDeployments)
+}
+
+// Load loads the desired state from the sources.
+func (r *Reconciler) Load(ctx context.Context) (_ []*deployment.ApplicationDeployment, err error) {
+	ctx, span := tracing.Start(ctx, "load desired state")
+	defer tracing.End(span, &err)
+	appDeployments, err := source.Load(ctx, r.Source, r.Overlays...)
+	if err != nil {
+		return nil, err
+	}
+
+	// reject the whole desired state rather than deploying parts of a redirected one
+	var errs []error
+	for _, deployments := range appDeployments {
+		for _, component := range deployments.Spec.DeploymentProfile.Components {
+			for _, location := range []string{component.Properties.KeyLocation, component.Properties.PackageLocation} {
+				if err := r.Registry.CheckLocation(location); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", component.Name, err))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("desired state references disallowed locations: %w", errors.Join(errs...))
+	}
+	return appDeployments, nil
+}
+
+// Apply converges the deployments in the managed namespaces towards the desired state. A failing namespace does not
+// keep the others from being reconciled.
+func (r *Reconciler) Apply(ctx context.Context, appDeployments []*deployment.ApplicationDeployment) error {
+	namespaces := r.managedNamespaces(appDeployments)
+	byNamespace := make(map[string][]*deployment.ApplicationDeployment)
+	for _, deployments := range appDeployments {
+		namespace := namespaceOf(deployments)
+		if !slices.Contains(namespaces, namespace) {
+			log.Printf("%s: ignoring deployment of unmanaged namespace %s", deployments.Metadata.Name, namespace)
+			continue
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], deployments)
+	}
+	var errs []error
+	for _, namespace := range namespaces {
+		err := r.inNamespace(namespace).applyNamespace(ctx, byNamespace[namespace])
+		if err != nil && namespace != defaultNamespace {
+			err = fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyNamespace converges the deployments in DeployDir towards the desired state of its namespace.
+func (r *Reconciler) applyNamespace(ctx context.Context, appDeployments []*deployment.ApplicationDeployment) error {
+	allowedDeployments := make(map[string]bool)
+
+	// Step 1: Add/update deployments as specified in the desired state, after the components they depend on
+	var planned []plannedComponent
+	for _, deployments := range appDeployments {
+		planned = append(planned, r.selectComponents(deployments, allowedDeployments)...)
+	}
+	ordered, err := orderComponents(planned)
+	if err != nil {
+		return err
+	}
+	overrides := r.loadOverrides()
+	var capabilities *Capabilities
+	collectCapabilities := func() Capabilities {
+		if capabilities == nil {
+			c := r.Capabilities(ctx)
+			capabilities = &c
+		}
+		return *capabilities
+	}
+	var heldBack, failed []error
+	for _, p := range ordered {
+		p.hold = r.hold(overrides, p.deployments, p.component)
+		if p.hold == holdPaused {
+			log.Printf("%s: reconciliation is paused", p.component.Name)
+			continue
+		}
+		err := r.checkCompatible(ctx, p.deployments, p.component)
+		if err == nil {
+			err = r.checkRequirements(ctx, p.deployments, p.component, collectCapabilities)
+		}
+		if err != nil {
+			// the installed version is kept
+			log.Printf("ERROR: %s: %s", p.component.Name, err)
+			heldBack = append(heldBack, fmt.Errorf("%s: %w", p.component.Name, err))
+			continue
+		}
+		if wait, attempts := r.retryIn(p.deployments, p.component); wait > 0 {
+			log.Printf("%s: failed %d times, retrying in %s", p.component.Name, attempts, wait.Round(time.Second))
+			failed = append(failed, fmt.Errorf("%s: failed %d times", p.component.Name, attempts))
+			continue
+		}
+		err = r.waitForDependencies(ctx, p)
+		switch {
+		case err != nil:
+		case isSelfUpdate(p.deployments, p.component):
+			err = r.selfUpdate(ctx, p.deployments, p.component, p.hold == holdPinned)
+		default:
+			err = r.reconcileComponent(ctx, p.deployments, p.component, p.hold == holdPinned)
+		}
+		if err != nil {
+			// the other components are reconciled and stale ones purged regardless
+			r.fail(ctx, p.deployments, p.component, err)
+			failed = append(failed, fmt.Errorf("%s: %w", p.component.Name, err))
+			continue
+		}
+		r.clearFailure(p.component.Name)
+	}
+
+	// Step 2: Purge local deployments missing in the desired state
+	f, _ := os.Open(r.DeployDir)
+	defer f.Close()
+
+	entries, _ := f.ReadDir(0)
+	for _, entry := range entries {
+		// hidden directories hold internal state such as previous versions
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			if found, _ := allowedDeployments[entry.Name()]; !found {
+				if allowed, reason := r.Maintenance.Allowed(time.Now()); !allowed {
+					r.deferChange(ctx, "", entry.Name(), pendingPurge, reason)
+					continue
+				}
+				log.Println("Purging stale deployment", entry.Name())
+				r.Progress.set(entry.Name(), "purging")
+				destDir := path.Join(r.DeployDir, entry.Name())
+				if err := r.runHooks(ctx, entry.Name(), HookPreStop, destDir); err != nil {
+					log.Printf("WARN: %s: %s", entry.Name(), err)
+				}
+				if err := r.backupStale(ctx, entry.Name(), destDir); err != nil {
+					log.Printf("ERROR: %s: not purging deployment: %s", entry.Name(), err)
+					continue
+				}
+				if err := r.DeploymentBackend(destDir).Remove(ctx, destDir); err != nil {
+					log.Println("ERROR: Failed to stop deployment", entry.Name())
+				}
+				if err := r.runHooks(ctx, entry.Name(), HookPostPurge, destDir); err != nil {
+					log.Printf("WARN: %s: %s", entry.Name(), err)
+				}
+				_ = os.RemoveAll(destDir)
+				_ = os.Remove(r.imageHistoryFile(entry.Name()))
+				r.clearPending(entry.Name())
+				r.clearIncompatible(entry.Name())
+				r.clearUnschedulable(entry.Name())
+				r.clearFailure(entry.Name())
+				r.forgetTimings(entry.Name())
+				r.emit(ctx, notify.Event{Type: notify.EventPurged, Component: entry.Name()})
+			}
+		}
+	}
+
+	return errors.Join(append(failed, heldBack...)...)
+}
+
+// selectComponents returns the components of a single ApplicationDeployment which are deployed on this device and
+// records their names in allowedDeployments.
+func (r *Reconciler) selectComponents(deployments *deployment.ApplicationDeployment, allowedDeployments map[string]bool) []plannedComponent {
+	var planned []plannedComponent
+	for _, component := range deployments.Spec.DeploymentProfile.Components {
+		if !r.assigned(deployments, component) {
+			continue
+		}
+		if selector := deployments.Annotation(component, "selector"); selector != "" {
+			match, err := deployment.MatchSelector(selector, r.Labels)
+			if err != nil {
+				log.Printf("%s: ignoring component with invalid selector: %s", component.Name, err)
+				continue
+			}
+			if !match {
+				log.Printf("%s: selector %q does not match this device", component.Name, selector)
+				continue
+			}
+		}
+
+		// keep track of deployment names for removing outdated deployments afterwards
+		allowedDeployments[component.Name] = true
+		planned = append(planned, plannedComponent{deployments: deployments, component: component})
+	}
+	return planned
+}
+
+// assigned reports whether the component is deployed to the reconciler's host.
+func (r *Reconciler) assigned(deployments *deployment.ApplicationDeployment, component deployment.Component) bool {
+	hosts := deployments.Annotation(component, "host")
+	if hosts == "" {
+		return r.Host == ""
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if strings.TrimSpace(host) == r.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// profileFile records the deployment profile type of a deployment, which selects its backend.
+const profileFile = ".profile"
+
+// DeploymentBackend returns the backend running the deployment in dir.
+func (r *Reconciler) DeploymentBackend(dir string) backend.Backend {
+	profileType, _ := os.ReadFile(path.Join(dir, profileFile))
+	return r.profileBackend(string(profileType))
+}
+
+// composeProfiles returns the compose profiles the component enables with the annotation
+// watcher.margo.org/compose-profiles, a comma-separated list, so a package can serve several hardware variants.
+func composeProfiles(deployments *deployment.ApplicationDeployment, component deployment.Component) string {
+	var profiles []string
+	for _, profile := range strings.Split(deployments.Annotation(component, "compose-profiles"), ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			profiles = append(profiles, profile)
+		}
+	}
+	slices.Sort(profiles)
+	return strings.Join(slices.Compact(profiles), ",")
+}
+
+// writeProfiles records the compose profiles of the component in dir, see backend.ProfilesFile.
+func writeProfiles(dir string, deployments *deployment.ApplicationDeployment, component deployment.Component) error {
+	profiles := composeProfiles(deployments, component)
+	if profiles == "" {
+		return nil
+	}
+	return os.WriteFile(path.Join(dir, backend.ProfilesFile), []byte(profiles), 0o644)
+}
+
+// profileBackend returns the backend running deployments of the profile type.
+func (r *Reconciler) profileBackend(profileType string) backend.Backend {
+	if b, found := r.Backends[profileType]; found {
+		return b
+	}
+	return r.Backend
+}
+
+// emit emits the event for the reconciler's host.
+func (r *Reconciler) emit(ctx context.Context, ev notify.Event) {
+	ev.Host = r.Host
+	r.Notifier.Emit(ctx, ev)
+}
+
+// reconcileComponent installs or updates the component unless it is up-to-date. Pinned components are only
+// installed, not updated.
+func (r *Reconciler) reconcileComponent(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, pinned bool) (err error) {
+	ctx, span := tracing.Start(ctx, "reconcile component", attribute.String("component", component.Name), attribute.String("package", component.Properties.PackageLocation))
+	defer tracing.End(span, &err)
+	r.Progress.set(component.Name, "checking")
+	destDir := path.Join(r.DeployDir, component.Name)
+	// SBOMs refer to the artifact holding the package
+	artifactLocation := component.Properties.PackageLocation
+	if component, err = r.resolvePackage(ctx, component); err != nil {
+		return err
+	}
+	expectedDigest, err := packageDigest(component)
+	if err != nil {
+		return err
+	}
+	forced := consumeRedeploy(destDir)
+	if forced {
+		log.Printf("%s: redeploying on request", component.Name)
+	}
+	// check if local deployment is up-to-date
+	installed, err := ReadMetadata(destDir)
+	if err != nil {
+		log.Printf("WARN: %s: reinstalling, %s", component.Name, err)
+	}
+	if installed != nil && !forced {
+		if installed.Digest == expectedDigest.String() && installed.Parameters == parametersDigest(deployments, component) &&
+			strings.Join(backend.ReadProfiles(destDir), ",") == composeProfiles(deployments, component) {
+			r.clearPending(component.Name)
+			if r.RestoreDrift {
+				drifted, err := drift(destDir)
+				if err != nil {
+					return err
+				}
+				if len(drifted) > 0 {
+					return r.restoreDrift(ctx, deployments, component, destDir, drifted)
+				}
+			}
+			log.Printf("%s: deployment is up-to-date", component.Name)
+			// ensure it is running (e.g. after reboot)
+			if err := r.DeploymentBackend(destDir).EnsureRunning(ctx, destDir); err != nil {
+				log.Printf("%s: failed to start: %s", component.Name, err)
+				r.captureLogs(ctx, component.Name, destDir)
+			}
+			return nil
+		}
+	}
+
+	if pinned && !forced && fsutil.FileExists(destDir) {
+		log.Printf("%s: pinned to the installed version, not updating to %s", component.Name, component.Properties.PackageLocation)
+		if err := r.DeploymentBackend(destDir).EnsureRunning(ctx, destDir); err != nil {
+			log.Printf("%s: failed to start: %s", component.Name, err)
+		}
+		return nil
+	}
+
+	if allowed, reason := r.changeAllowed(deployments, component); !allowed && !forced {
+		r.deferChange(ctx, deployments.Metadata.Name, component.Name, component.Properties.PackageLocation, reason)
+		return nil
+	}
+
+	log.Printf("%s: fetching from remote", component.Name)
+	r.Progress.set(component.Name, "fetching")
+	ctx, phases := withTimings(ctx)
+	defer r.recordTimings(component.Name, phases)
+
+	tempDir, err := os.MkdirTemp("", component.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	app, key, err := r.fetch(ctx, component, tempDir)
+	if err != nil {
+		return err
+	}
+	if err := r.checkQuota(app); err != nil {
+		return err
+	}
+	var sboms []sbom.Document
+	if r.SBOM != nil {
+		if sboms, err = r.checkSBOMs(ctx, artifactLocation); err != nil {
+			return err
+		}
+	}
+
+	params, err := resolveParameters(deployments, component)
+	if err != nil {
+		return err
+	}
+	if _, err := persistentPaths(deployments, component); err != nil {
+		return err
+	}
+	secretParams, err := r.resolveSecrets(ctx, deployments, component)
+	if err != nil {
+		return err
+	}
+
+	// the admission checks need the app's content
+	validator, validate := r.profileBackend(deployments.Spec.DeploymentProfile.Type).(backend.Validator)
+	validate = validate && r.ValidateConfig
+	if r.Scanner != nil || r.Policy != nil || r.ComposeLint != nil || validate {
+		stagingDir := path.Join(tempDir, "staging")
+		if err := unpackApp(app, stagingDir); err != nil {
+			return err
+		}
+		if validate {
+			// the configuration may reference the parameters and secrets
+			if err := keepLocalEnv(destDir, stagingDir); err != nil {
+				return err
+			}
+			if err := writeSecrets(stagingDir, secretParams); err != nil {
+				return err
+			}
+			if err := writeEnv(stagingDir, params, secretParams); err != nil {
+				return err
+			}
+			if err := writeProfiles(stagingDir, deployments, component); err != nil {
+				return err
+			}
+			if err := validator.Validate(ctx, stagingDir); errors.Is(err, backend.ErrPlatformMismatch) {
+				return &errdefs.PlatformError{Err: fmt.Errorf("rejecting package: %w", err)}
+			} else if err != nil {
+				return fmt.Errorf("rejecting package: %w", err)
+			}
+		}
+		if r.ComposeLint != nil {
+			if err := r.lint(component, stagingDir, destDir); err != nil {
+				return err
+			}
+		}
+		if r.Scanner != nil {
+			if err := r.scanApp(ctx, deployments, component, stagingDir); err != nil {
+				return err
+			}
+		}
+		if r.Policy != nil {
+			if err := r.admit(ctx, deployments, component, app, stagingDir); err != nil {
+				return err
+			}
+		}
+	}
+	r.Progress.set(component.Name, "installing")
+
+	// keep the previous version around until the new one is up, so its persistent data is kept and we can roll back
+	previousDir := ""
+	if fsutil.FileExists(destDir) {
+		if err := r.runHooks(ctx, component.Name, HookPreStop, destDir); err != nil {
+			return err
+		}
+		_, stopSpan := tracing.Start(ctx, "stop")
+		err := r.DeploymentBackend(destDir).Stop(ctx, destDir)
+		tracing.End(stopSpan, &err)
+		if err != nil {
+			return &errdefs.RuntimeError{Err: err}
+		}
+		if err := r.backup(ctx, component.Name, destDir, "update"); err != nil {
+			if err := r.DeploymentBackend(destDir).EnsureRunning(ctx, destDir); err != nil {
+				log.Printf("ERROR: %s: failed to restart installed version: %s", component.Name, err)
+			}
+			return err
+		}
+		previousDir = path.Join(r.DeployDir, ".previous-"+component.Name)
+		// a failed update which was not rolled back may not have carried the data over yet
+		if err := movePersistent(previousDir, destDir, readPersistentPaths(previousDir)); err != nil {
+			return err
+		}
+		_ = os.RemoveAll(previousDir)
+		if err := os.Rename(destDir, previousDir); err != nil {
+			return err
+		}
+	}
+
+	if err := r.installApp(ctx, deployments, component, app, destDir, previousDir, params, secretParams); err != nil {
+		if errors.As(err, new(*errdefs.RuntimeError)) {
+			r.captureLogs(ctx, component.Name, destDir)
+		}
+		if previousDir != "" && r.Rollback {
+			r.rollback(ctx, deployments, component, destDir, previousDir, err)
+		}
+		return err
+	}
+
+	metadata := &Metadata{
+		Deployment:      deployments.Metadata.Name,
+		Package:         component.Properties.PackageLocation,
+		Digest:          expectedDigest.String(),
+		DesiredState:    desiredStateDigest(deployments),
+		Parameters:      parametersDigest(deployments, component),
+		KeyFingerprints: verify.KeyFingerprints(key),
+		Version:         strings.TrimSpace(deployments.Annotation(component, "version")),
+		Applied:         time.Now().UTC(),
+		Timings:         phases,
+	}
+	if pruner, ok := r.DeploymentBackend(destDir).(backend.ImagePruner); ok {
+		if metadata.Images, err = pruner.Images(ctx, destDir); err != nil {
+			log.Printf("WARN: %s: failed to list images: %s", component.Name, err)
+		}
+	}
+	if err := writeMetadata(destDir, metadata); err != nil {
+		return err
+	}
+	if r.SBOM != nil {
+		if err := sbom.Store(path.Join(destDir, ".sbom"), sboms); err != nil {
+			log.Printf("WARN: %s: failed to store SBOMs: %s", component.Name, err)
+		}
+	}
+	if previousDir != "" {
+		if r.PruneImages {
+			r.pruneImages(ctx, component.Name, previousDir)
+		}
+		_ = os.RemoveAll(previousDir)
+	}
+	r.clearPending(component.Name)
+	r.emit(ctx, notify.Event{Type: notify.EventApplied, Deployment: deployments.Metadata.Name, Component: component.Name, Package: component.Properties.PackageLocation})
+	return nil
+}
+
+// fetch downloads, decrypts and verifies the package of the component in dir, unless there is not enough disk space
+// for it. It returns the path of the verified app and the public key it was verified with.
+func (r *Reconciler) fetch(ctx context.Context, component deployment.Component, dir string) (_ string, _ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "fetch")
+	defer tracing.End(span, &err)
+	if err := r.checkDiskSpace(ctx, component, dir); err != nil {
+		return "", nil, err
+	}
+
+	// the key is downloaded while the package is. Both are bounded by the registry.Limiter, so the package is closed
+	// before waiting for the key, which may need its download slot.
+	type download struct {
+		key      []byte
+		err      error
+		panicked any
+	}
+	keyDownload := make(chan download, 1)
+	go func() {
+		// panics are passed on to the reconciling goroutine, which reports them
+		defer func() {
+			if v := recover(); v != nil {
+				select {
+				case keyDownload <- download{panicked: fmt.Sprintf("%v\n\ndownloading the key:\n%s", v, debug.Stack())}:
+				default: // the key was delivered already
+				}
+			}
+		}()
+		pubKey, err := r.Registry.Download(ctx, component.Properties.KeyLocation)
+		if err != nil {
+			keyDownload <- download{err: err}
+			return
+		}
+		defer pubKey.Close()
+		key, err := io.ReadAll(pubKey)
+		keyDownload <- download{key: key, err: err}
+	}()
+	app, err := r.fetchPackage(ctx, component, dir)
+	k := <-keyDownload
+	if k.panicked != nil {
+		panic(k.panicked)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if k.err != nil {
+		return "", nil, k.err
+	}
+	return app, k.key, verifyApp(ctx, r.Verifier, component.Name, app, k.key)
+}
+
+// fetchPackage downloads, decrypts and extracts the package of the component into dir. It returns the path of the
+// app, which is yet to be verified.
+func (r *Reconciler) fetchPackage(ctx context.Context, component deployment.Component, dir string) (string, error) {
+	start := time.Now()
+	pkg, err := r.downloadPackage(ctx, component)
+	recordPhase(ctx, PhaseDownload, start)
+	if err != nil {
+		return "", err
+	}
+	defer pkg.Close()
+	_, decryptSpan := tracing.Start(ctx, "decrypt")
+	plaintext, err := r.Decryption.Decrypt(ctx, pkg)
+	tracing.End(decryptSpan, &err)
+	if err != nil {
+		return "", err
+	}
+	defer plaintext.Close()
+	return unpackPackage(ctx, plaintext, dir)
+}
+
+// downloadPackage downloads the package of the component, or reconstructs it from an earlier package with one of the
+// deltas of the component if the cache holds one.
+func (r *Reconciler) downloadPackage(ctx context.Context, component deployment.Component) (io.ReadCloser, error) {
+	for _, delta := range component.Properties.Deltas {
+		pkg, err := r.Registry.DownloadDelta(ctx, component.Properties.PackageLocation, delta.From, delta.Location)
+		if err == nil {
+			return pkg, nil
+		}
+		if !errors.Is(err, registry.ErrNoBase) {
+			log.Printf("WARN: Failed to update %s with a delta, downloading the package: %s", component.Name, err)
+		}
+	}
+	return r.Registry.Download(ctx, component.Properties.PackageLocation)
+}
+
+// UnpackAndVerify extracts the package into dir and verifies the app it contains. Packages must contain exactly one
+// app. It returns the path of the verified app.
+func UnpackAndVerify(ctx context.Context, v verify.Verifier, component string, pkg io.Reader, key []byte, dir string) (string, error) {
+	app, err := unpackPackage(ctx, pkg, dir)
+	if err != nil {
+		return "", err
+	}
+	return app, verifyApp(ctx, v, component, app, key)
+}
+
+// unpackPackage extracts the package into dir and returns the path of the single app it contains.
+func unpackPackage(ctx context.Context, pkg io.Reader, dir string) (string, error) {
+	_, span := tracing.Start(ctx, "extract package")
+	start := time.Now()
+	err := fsutil.UnpackTgz(pkg, dir, true)
+	recordPhase(ctx, PhaseExtraction, start)
+	tracing.End(span, &err)
+	if err != nil {
+		return "", err
+	}
+	appFiles, err := fsutil.FindAppFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(appFiles) == 0 {
+		return "", errors.New("package contains no app")
+	}
+	if len(appFiles) > 1 {
+		// a component is installed from a single app, further apps would remain unverified
+		names := make([]string, len(appFiles))
+		for i, f := range appFiles {
+			names[i], _ = filepath.Rel(dir, f)
+		}
+		return "", fmt.Errorf("package contains more than one app: %s", strings.Join(names, ", "))
+	}
+	return appFiles[0], nil
+}
+
+// verifyApp verifies the app of the component with the key.
+func verifyApp(ctx context.Context, v verify.Verifier, component, app string, key []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "verify", attribute.String("app", filepath.Base(app)))
+	start := time.Now()
+	err = v.Verify(ctx, verify.Artifact{Component: component, File: app, Key: key})
+	recordPhase(ctx, PhaseVerification, start)
+	tracing.End(span, &err)
+	if err != nil {
+		return &errdefs.VerificationError{Err: err}
+	}
+	return nil
+}
+
+// checkSBOMs fetches the SBOMs attached to the artifact holding the package and checks them against the policy.
+func (r *Reconciler) checkSBOMs(ctx context.Context, packageLocation string) ([]sbom.Document, error) {
+	artifact, err := r.Registry.PackageArtifact(ctx, packageLocation, r.platform())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SBOMs: %w", err)
+	}
+	docs, err := sbom.Fetch(ctx, r.Registry.RC, artifact)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SBOMs: %w", err)
+	}
+	if err := r.SBOM.Check(docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// scanApp scans the images bundled with the app unpacked in dir.
+func (r *Reconciler) scanApp(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, dir string) error {
+	var ignore []string
+	for _, id := range strings.Split(deployments.Annotation(component, "ignore-vulnerabilities"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ignore = append(ignore, id)
+		}
+	}
+	return r.Scanner.Check(ctx, dir, ignore)
+}
+
+// lint checks the compose files of the app unpacked in dir, which will be deployed in destDir.
+func (r *Reconciler) lint(component deployment.Component, dir, destDir string) error {
+	absDestDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range backend.ComposeFiles(dir) {
+		b, err := os.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		warnings, err := r.ComposeLint.Lint(b, absDestDir)
+		for _, w := range warnings {
+			log.Printf("WARN: %s: %s: %s", component.Name, file, w)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// admit evaluates the admission policy for the app unpacked in dir.
+func (r *Reconciler) admit(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, app, dir string) error {
+	dgst, err := packageDigest(component)
+	if err != nil {
+		return err
+	}
+	input := policy.Input{
+		Deployment: deployments,
+		Component:  component,
+		Package: policy.Package{
+			Location: component.Properties.PackageLocation,
+			Digest:   dgst.String(),
+			App:      filepath.Base(app),
+		},
+		Signature: policy.Signature{Verified: true},
+		Labels:    r.Labels,
+	}
+	if chain, ok := r.Verifier.(*verify.Chain); ok {
+		rule := chain.Rule(component.Name)
+		input.Signature.Rule = &rule
+	}
+	if file := backend.FindComposeFile(dir); file != "" {
+		b, err := os.ReadFile(path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(b, &input.Compose); err != nil {
+			return fmt.Errorf("invalid %s: %w", file, err)
+		}
+	}
+	return r.Policy.Evaluate(ctx, input)
+}
+
+// unpackApp extracts the app into dir.
+func unpackApp(app, dir string) error {
+	f, err := os.Open(app)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_ = os.MkdirAll(dir, 0o755)
+	return fsutil.UnpackTgz(f, dir, true)
+}
+
+// installApp extracts the verified app into destDir and provides the parameters and secrets. The .env.local and the
+// persistent paths are carried over from the previous version in previousDir, if any. It then loads the bundled
+// images, starts the deployment with the backend of the profile type and runs its postStart hooks. The compose
+// project is recorded, so components of different namespaces do not collide.
+func (r *Reconciler) installApp(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, app, destDir, previousDir string, params []envVar, secretParams []secret) error {
+	_, span := tracing.Start(ctx, "extract app")
+	start := time.Now()
+	err := unpackApp(app, destDir)
+	recordPhase(ctx, PhaseExtraction, start)
+	tracing.End(span, &err)
+	if err != nil {
+		return err
+	}
+	persistent, err := persistentPaths(deployments, component)
+	if err != nil {
+		return err
+	}
+	if persistent, err = keepPersistent(previousDir, destDir, persistent); err != nil {
+		return err
+	}
+	if err := writePersistentPaths(destDir, persistent); err != nil {
+		return err
+	}
+	if err := writeChecksums(destDir); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(destDir, profileFile), []byte(deployments.Spec.DeploymentProfile.Type), 0o644); err != nil {
+		return err
+	}
+	namespace := deployments.Metadata.Namespace
+	if namespace == defaultNamespace {
+		namespace = ""
+	}
+	project := backend.ProjectName(namespace, component.Name)
+	if err := os.WriteFile(path.Join(destDir, backend.ProjectFile), []byte(project), 0o644); err != nil {
+		return err
+	}
+	if err := keepLocalEnv(previousDir, destDir); err != nil {
+		return err
+	}
+	if err := writeSecrets(destDir, secretParams); err != nil {
+		return err
+	}
+	if err := writeEnv(destDir, params, secretParams); err != nil {
+		return err
+	}
+	if err := writeProfiles(destDir, deployments, component); err != nil {
+		return err
+	}
+	spanCtx, span := tracing.Start(ctx, "load images")
+	start = time.Now()
+	err = r.DeploymentBackend(destDir).Load(spanCtx, destDir)
+	recordPhase(ctx, PhaseImageLoad, start)
+	tracing.End(span, &err)
+	if errors.Is(err, backend.ErrPlatformMismatch) {
+		return &errdefs.PlatformError{Err: err}
+	}
+	if err != nil {
+		return &errdefs.RuntimeError{Err: err}
+	}
+	spanCtx, span = tracing.Start(ctx, "start")
+	start = time.Now()
+	err = r.DeploymentBackend(destDir).EnsureRunning(spanCtx, destDir)
+	recordPhase(ctx, PhaseStart, start)
+	tracing.End(span, &err)
+	if err != nil {
+		return &errdefs.RuntimeError{Err: err}
+	}
+	return r.runHooks(ctx, component.Name, HookPostStart, destDir)
+}
+
+// rollback restores the previous version of a component after a failed update.
+func (r *Reconciler) rollback(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, destDir, previousDir string, cause error) {
+	log.Printf("%s: update failed, rolling back: %s", component.Name, cause)
+	_ = r.DeploymentBackend(destDir).Stop(ctx, destDir)
+	// the data may have changed since it was carried over
+	if err := movePersistent(destDir, previousDir, readPersistentPaths(destDir)); err != nil {
+		log.Printf("WARN: %s: failed to keep persistent data: %s", component.Name, err)
+	}
+	_ = os.RemoveAll(destDir)
+	if err := os.Rename(previousDir, destDir); err != nil {
+		log.Printf("ERROR: %s: rollback failed: %s", component.Name, err)
+		return
+	}
+	if err := r.DeploymentBackend(destDir).EnsureRunning(ctx, destDir); err != nil {
+		log.Printf("ERROR: %s: failed to start previous version: %s", component.Name, err)
+		return
+	}
+	r.emit(ctx, notify.Event{Type: notify.EventRolledBack, Deployment: deployments.Metadata.Name, Component: component.Name, Error: cause.Error()})
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+)
+
+// redeployFile marks a deployment for redeployment in the next reconciliation.
+const redeployFile = ".redeploy"
+
+// Redeploy marks the component for redeployment: the next reconciliation downloads, verifies and installs its package
+// again even if it is up-to-date, regardless of maintenance windows and pins. Components of other namespaces than
+// the default one are named <namespace>/<component>.
+func (r *Reconciler) Redeploy(name string) error {
+	namespace, component, found := strings.Cut(name, "/")
+	if !found {
+		namespace, component = defaultNamespace, name
+	}
+	if !deployment.ValidName(namespace) || !deployment.ValidName(component) {
+		return fmt.Errorf("invalid component %q", name)
+	}
+	dir := path.Join(r.inNamespace(namespace).DeployDir, component)
+	if !fsutil.FileExists(dir) {
+		return fmt.Errorf("%s: %w", name, ErrNotDeployed)
+	}
+	return os.WriteFile(path.Join(dir, redeployFile), nil, 0o644)
+}
+
+// ErrNotDeployed is returned for redeployments of unknown components.
+var ErrNotDeployed = errors.New("component is not deployed")
+
+// Redeploy marks the component deployed on the host for redeployment, see Reconciler.Redeploy. The local host is
+// the unnamed one.
+func (f *Fleet) Redeploy(host, name string) error {
+	for _, r := range f.Reconcilers {
+		if r.Host == host {
+			return r.Redeploy(name)
+		}
+	}
+	return fmt.Errorf("unknown host %q", host)
+}
+
+// consumeRedeploy reports whether the deployment in dir was marked for redeployment, and removes the mark so a
+// failed redeployment is not retried.
+func consumeRedeploy(dir string) bool {
+	return os.Remove(path.Join(dir, redeployFile)) == nil
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+)
+
+// secret is a resolved secret parameter.
+type secret struct {
+	name  string
+	value string
+	// env lists the environment variables set to the secret.
+	env []string
+}
+
+// resolveSecrets resolves the secret parameters targeting the component.
+func (r *Reconciler) resolveSecrets(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component) ([]secret, error) {
+	var resolved []secret
+	for name, param := range deployments.Spec.Parameters {
+		if param.SecretRef == "" {
+			continue
+		}
+		s := secret{name: name}
+		for _, target := range param.Targets {
+			if slices.Contains(target.Components, component.Name) {
+				s.env = append(s.env, target.Pointer)
+			}
+		}
+		if len(s.env) == 0 {
+			continue
+		}
+		if r.Secrets == nil {
+			return nil, fmt.Errorf("parameter %s references a secret, but secrets are not configured", name)
+		}
+		value, err := r.Secrets.Resolve(ctx, param.SecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return nil, fmt.Errorf("parameter %s: multi-line secrets cannot be passed as environment variables", name)
+		}
+		s.value = value
+		resolved = append(resolved, s)
+	}
+	slices.SortFunc(resolved, func(a, b secret) int { return strings.Compare(a.name, b.name) })
+	return resolved, nil
+}
+
+// writeSecrets writes the secrets into .secrets/<name> for use as compose secrets (file: .secrets/<name>), which is
+// only readable by the watcher. They are passed as environment variables by writeEnv.
+func writeSecrets(dir string, resolved []secret) error {
+	if len(resolved) == 0 {
+		return nil
+	}
+	secretsDir := path.Join(dir, ".secrets")
+	if err := os.MkdirAll(secretsDir, 0o700); err != nil {
+		return err
+	}
+	for _, s := range resolved {
+		if err := os.WriteFile(path.Join(secretsDir, s.name), []byte(s.value), 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteEnv quotes the value so docker compose neither interpolates nor unescapes it.
+func quoteEnv(value string) string {
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`)
+	return `"` + r.Replace(value) + `"`
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/verify"
+)
+
+// SelfUpdater replaces the binary of the running watcher.
+type SelfUpdater interface {
+	// Stage prepares the replacement of the watcher by the binary and checks that it runs on the device.
+	Stage(ctx context.Context, binary string) error
+	// Restart replaces the watcher by the staged binary and restarts it. It only returns on failure.
+	Restart() error
+}
+
+// selfUpdateDir records the Metadata of the package the watcher was last updated from.
+const selfUpdateDir = ".self-update"
+
+// selfUpdateBinary is the name of the watcher's binary in the apps of self-update components.
+func selfUpdateBinary() string {
+	if runtime.GOOS == "windows" {
+		return "oci-watcher.exe"
+	}
+	return "oci-watcher"
+}
+
+// isSelfUpdate reports whether the component is the watcher itself, by the annotation watcher.margo.org/self-update.
+func isSelfUpdate(deployments *deployment.ApplicationDeployment, component deployment.Component) bool {
+	selfUpdate, _ := strconv.ParseBool(deployments.Annotation(component, "self-update"))
+	return selfUpdate
+}
+
+// selfUpdate replaces the watcher by the binary in the app of the component unless it was updated from that package
+// before. The package is recorded before restarting, so a binary which was rolled back because it failed to start is
+// not installed again until the desired state refers to another package.
+func (r *Reconciler) selfUpdate(ctx context.Context, deployments *deployment.ApplicationDeployment, component deployment.Component, pinned bool) error {
+	if r.SelfUpdate == nil || r.Host != "" {
+		log.Printf("%s: ignoring self-update component, self-updates are disabled", component.Name)
+		return nil
+	}
+	dir := path.Join(r.DeployDir, selfUpdateDir)
+	component, err := r.resolvePackage(ctx, component)
+	if err != nil {
+		return err
+	}
+	expectedDigest, err := packageDigest(component)
+	if err != nil {
+		return err
+	}
+	installed, err := ReadMetadata(dir)
+	if err != nil {
+		log.Printf("WARN: %s: updating the watcher again, %s", component.Name, err)
+	}
+	if installed != nil && installed.Digest == expectedDigest.String() {
+		r.clearPending(component.Name)
+		log.Printf("%s: watcher is up-to-date", component.Name)
+		return nil
+	}
+	if pinned {
+		log.Printf("%s: pinned to the running watcher, not updating to %s", component.Name, component.Properties.PackageLocation)
+		return nil
+	}
+	if allowed, reason := r.changeAllowed(deployments, component); !allowed {
+		r.deferChange(ctx, deployments.Metadata.Name, component.Name, component.Properties.PackageLocation, reason)
+		return nil
+	}
+
+	log.Printf("%s: fetching watcher from remote", component.Name)
+	tempDir, err := os.MkdirTemp("", component.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	app, key, err := r.fetch(ctx, component, tempDir)
+	if err != nil {
+		return err
+	}
+	binDir := path.Join(tempDir, "binary")
+	if err := unpackApp(app, binDir); err != nil {
+		return err
+	}
+	if err := r.SelfUpdate.Stage(ctx, path.Join(binDir, selfUpdateBinary())); err != nil {
+		return fmt.Errorf("failed to stage watcher: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeMetadata(dir, &Metadata{
+		Deployment:      deployments.Metadata.Name,
+		Package:         component.Properties.PackageLocation,
+		Digest:          expectedDigest.String(),
+		DesiredState:    desiredStateDigest(deployments),
+		KeyFingerprints: verify.KeyFingerprints(key),
+		Version:         strings.TrimSpace(deployments.Annotation(component, "version")),
+		Applied:         time.Now().UTC(),
+	}); err != nil {
+		return err
+	}
+	r.clearPending(component.Name)
+	log.Printf("%s: restarting as updated watcher", component.Name)
+	return r.SelfUpdate.Restart()
+}
@@ -0,0 +1,303 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// stateManifest is the first entry of a state archive. Deployments of the local host are stored below deployments/,
+// those of other hosts below hosts/<host>/ and the blob cache below cache/.
+type stateManifest struct {
+	Version     string    `json:"version"`
+	Exported    time.Time `json:"exported"`
+	Deployments []string  `json:"deployments"`
+}
+
+const stateManifestName = "state.json"
+
+// stateArchiveDir returns the directory of the archive holding the deployments of the host.
+func stateArchiveDir(host string) string {
+	if host == "" {
+		return "deployments"
+	}
+	return path.Join("hosts", host)
+}
+
+// excludedFromState reports whether the file of a deployment, relative to its directory, is left out of state
+// archives: the resolved secrets, which the replacement device resolves itself, and marks for the reconciler.
+func excludedFromState(rel string) bool {
+	return rel == ".secrets" || strings.HasPrefix(rel, ".secrets/") || rel == envFile || rel == redeployFile
+}
+
+// ExportState writes the deployments of all hosts and the blob cache in cacheDir, if any, to w as gzip-compressed
+// tarball, so a replacement device can be seeded with ImportState rather than downloading every package again.
+// Secrets are not exported.
+func (f *Fleet) ExportState(w io.Writer, cacheDir string) error {
+	manifest := stateManifest{Version: f.Reconcilers[0].Version, Exported: time.Now().UTC()}
+	type deploymentDir struct{ dir, name string }
+	var dirs []deploymentDir
+	for _, r := range f.Reconcilers {
+		for _, dir := range r.DeploymentDirs() {
+			rel, err := filepath.Rel(r.DeployDir, dir)
+			if err != nil {
+				return err
+			}
+			name := path.Join(stateArchiveDir(r.Host), filepath.ToSlash(rel))
+			dirs = append(dirs, deploymentDir{dir: dir, name: name})
+			manifest.Deployments = append(manifest.Deployments, name)
+		}
+	}
+
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: stateManifestName, Mode: 0o644, Size: int64(len(b)), ModTime: manifest.Exported}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := addToState(tw, d.dir, d.name, excludedFromState); err != nil {
+			return err
+		}
+	}
+	if cacheDir != "" {
+		if err := addToState(tw, cacheDir, "cache", func(rel string) bool {
+			// incomplete downloads
+			return strings.HasPrefix(path.Base(rel), ".tmp-")
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+// addToState writes the files in dir to the archive below name, except those excluded by their path relative to dir.
+func addToState(tw *tar.Writer, dir, name string, exclude func(rel string) bool) error {
+	return filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && exclude(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			log.Println("WARN: Skipping unsupported file", file)
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(name, rel)
+		if entry.IsDir() {
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// ImportState seeds the deploy directories of the hosts and the blob cache in cacheDir from an archive written by
+// ExportState and returns the directories of the imported deployments. Deployments which exist already and those of
+// unknown hosts are skipped, as is the cache if cacheDir is empty. The imported deployments are marked for
+// redeployment, so the next reconciliation installs them again from the cache with the secrets of this device,
+// keeping their .env.local and persistent paths.
+func (f *Fleet) ImportState(r io.Reader, cacheDir string) ([]string, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != stateManifestName {
+		return nil, fmt.Errorf("not a state archive: %s missing", stateManifestName)
+	}
+	var manifest stateManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", stateManifestName, err)
+	}
+
+	// the deploy directory of each imported deployment
+	targets := make(map[string]string)
+	for _, name := range manifest.Deployments {
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid deployment %q in %s", name, stateManifestName)
+		}
+		for _, rec := range f.Reconcilers {
+			prefix := stateArchiveDir(rec.Host) + "/"
+			if rel, found := strings.CutPrefix(name, prefix); found {
+				dir := filepath.Join(rec.DeployDir, filepath.FromSlash(rel))
+				if fsutil.FileExists(dir) {
+					log.Printf("%s: skipping import, deployment exists", rec.ComponentName(dir))
+				} else {
+					targets[name] = dir
+				}
+			}
+		}
+	}
+	if cacheDir != "" {
+		targets["cache"] = cacheDir
+	}
+
+	var imported []string
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(header.Name, "/")
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid entry %s", header.Name)
+		}
+		var root, target string
+		for prefix, dir := range targets {
+			if rel, found := strings.CutPrefix(name, prefix+"/"); found {
+				root, target = prefix, filepath.Join(dir, filepath.FromSlash(rel))
+				break
+			}
+			if name == prefix {
+				root, target = prefix, dir
+				break
+			}
+		}
+		if target == "" {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return nil, err
+			}
+			if root != "cache" && !slices.Contains(imported, root) {
+				imported = append(imported, root)
+			}
+		case tar.TypeReg:
+			if root == "cache" && fsutil.FileExists(target) {
+				continue
+			}
+			err := importFile(tr, target, os.FileMode(header.Mode).Perm(), cacheDigest(root, name))
+			if errors.Is(err, errDigestMismatch) {
+				// the blob is downloaded again when needed
+				log.Printf("WARN: skipping corrupt blob %s", header.Name)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", header.Name, err)
+			}
+		}
+	}
+
+	var dirs []string
+	for _, name := range imported {
+		if err := os.WriteFile(filepath.Join(targets[name], redeployFile), nil, 0o644); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, targets[name])
+	}
+	return dirs, nil
+}
+
+// cacheDigest returns the digest of a blob of the cache, as named in the archive, or the empty digest for other
+// files.
+func cacheDigest(root, name string) digest.Digest {
+	parts := strings.Split(name, "/")
+	if root != "cache" || len(parts) != 4 || parts[1] != "blobs" {
+		return ""
+	}
+	d := digest.NewDigestFromEncoded(digest.Algorithm(parts[2]), parts[3])
+	if d.Validate() != nil {
+		return ""
+	}
+	return d
+}
+
+var errDigestMismatch = errors.New("digest mismatch")
+
+// importFile writes the content of r to target. Files with digest are only committed if their content matches.
+func importFile(r io.Reader, target string, mode os.FileMode, d digest.Digest) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-import-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w := io.Writer(tmp)
+	var verifier digest.Verifier
+	if d != "" {
+		verifier = d.Verifier()
+		w = io.MultiWriter(tmp, verifier)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if verifier != nil && !verifier.Verified() {
+		return fmt.Errorf("%w for %s", errDigestMismatch, d)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package reconcile
+
+import (
+	"context"
+	"maps"
+	"path"
+	"sync"
+	"time"
+)
+
+// Phases of installations whose durations are recorded, see Timings. Packages which are not cached yet are
+// downloaded while they are extracted, so the download covers connecting to the registry only.
+const (
+	PhaseDownload     = "download"
+	PhaseVerification = "verification"
+	PhaseExtraction   = "extraction"
+	PhaseImageLoad    = "image_load"
+	PhaseStart        = "start"
+)
+
+// Timings are the durations of the phases of an installation in seconds.
+type Timings map[string]float64
+
+// InstallTimings holds the Timings of the last installation of every component, successful or not. A nil
+// InstallTimings records nothing.
+type InstallTimings struct {
+	mu   sync.Mutex
+	last map[string]Timings
+}
+
+// Last returns the Timings of the last installation of every component by name, see Reconciler.ComponentName.
+func (t *InstallTimings) Last() map[string]Timings {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last := make(map[string]Timings, len(t.last))
+	for component, phases := range t.last {
+		last[component] = maps.Clone(phases)
+	}
+	return last
+}
+
+func (t *InstallTimings) record(component string, phases Timings) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last = make(map[string]Timings)
+	}
+	t.last[component] = maps.Clone(phases)
+}
+
+func (t *InstallTimings) forget(component string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, component)
+}
+
+type timingsKey struct{}
+
+// withTimings returns a context in which the durations of the phases are recorded in the returned Timings.
+func withTimings(ctx context.Context) (context.Context, Timings) {
+	t := make(Timings)
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// recordPhase adds the time since start to the duration of the phase, if the context records them.
+func recordPhase(ctx context.Context, phase string, start time.Time) {
+	if t, ok := ctx.Value(timingsKey{}).(Timings); ok {
+		t[phase] += time.Since(start).Seconds()
+	}
+}
+
+// recordTimings remembers the Timings of an installation of the component, unless it did not get to any phase.
+func (r *Reconciler) recordTimings(component string, t Timings) {
+	if len(t) > 0 {
+		r.Timings.record(r.ComponentName(path.Join(r.DeployDir, component)), t)
+	}
+}
+
+// forgetTimings forgets the Timings of the component once it was purged.
+func (r *Reconciler) forgetTimings(component string) {
+	r.Timings.forget(r.ComponentName(path.Join(r.DeployDir, component)))
+}
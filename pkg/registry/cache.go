@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+)
+
+// Cache is a content-addressed on-disk store for blobs and manifests pulled from a registry.
+type Cache struct {
+	dir   string
+	rc    *regclient.RegClient
+	locks sync.Map // digest -> *sync.Mutex
+	peers *Peers   // optional, consulted before the registry
+	limit *Limiter // optional, bounds downloads from the registry
+}
+
+// NewCache creates a cache in dir which pulls missing content using rc.
+func NewCache(dir string, rc *regclient.RegClient) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tags"), 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, rc: rc}, nil
+}
+
+// Dir returns the root directory of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// UsePeers makes the cache try to fetch missing blobs from peers before the registry.
+func (c *Cache) UsePeers(p *Peers) {
+	c.peers = p
+}
+
+// UseLimiter bounds the downloads of missing blobs from the registry. Peers are in the local network and not
+// limited.
+func (c *Cache) UseLimiter(l *Limiter) {
+	c.limit = l
+}
+
+func (c *Cache) blobPath(d digest.Digest) string {
+	return filepath.Join(c.dir, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// Has reports whether the blob is cached.
+func (c *Cache) Has(d digest.Digest) bool {
+	return fsutil.FileExists(c.blobPath(d))
+}
+
+// Open returns the cached blob, or an error satisfying os.IsNotExist if it is not cached.
+func (c *Cache) Open(d digest.Digest) (*os.File, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return os.Open(c.blobPath(d))
+}
+
+// lock serializes storing the blob with the digest. The returned function unlocks it.
+func (c *Cache) lock(d digest.Digest) func() {
+	mu, _ := c.locks.LoadOrStore(d, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// store writes the content of r to the cache. The content is only committed if it matches the digest.
+func (c *Cache) store(d digest.Digest, r io.Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	target := c.blobPath(d)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-"+d.Encoded())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := d.Verifier()
+	if _, err := io.Copy(io.MultiWriter(tmp, verifier), r); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return &errdefs.VerificationError{Err: fmt.Errorf("digest mismatch for %s", d)}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Fetch returns the blob from the cache, downloading it from peers or the registry first if necessary.
+func (c *Cache) Fetch(ctx context.Context, r ref.Ref, d digest.Digest) (*os.File, error) {
+	blob, err := c.Stream(ctx, r, d)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := blob.(*os.File); ok {
+		return f, nil
+	}
+	_, err = io.Copy(io.Discard, blob)
+	blob.Close()
+	if err != nil {
+		return nil, err
+	}
+	return c.Open(d)
+}
+
+func (c *Cache) tagPath(repo, tag string) string {
+	return filepath.Join(c.dir, "tags", filepath.FromSlash(repo), tag)
+}
+
+// storeTag remembers which manifest a tag pointed to, so it can still be resolved while the upstream is unreachable.
+func (c *Cache) storeTag(repo, tag, mediaType string, d digest.Digest) error {
+	target := c.tagPath(repo, tag)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(target, []byte(mediaType+"\n"+d.String()), 0o644)
+}
+
+func (c *Cache) lookupTag(repo, tag string) (string, digest.Digest, error) {
+	b, err := os.ReadFile(c.tagPath(repo, tag))
+	if err != nil {
+		return "", "", err
+	}
+	mediaType, dgst, found := strings.Cut(string(b), "\n")
+	if !found {
+		return "", "", fmt.Errorf("corrupt tag entry for %s:%s", repo, tag)
+	}
+	return mediaType, digest.Digest(dgst), nil
+}
+
+// storeManifest caches a manifest together with its media type, which is needed to serve it again.
+func (c *Cache) storeManifest(d digest.Digest, mediaType string, body []byte) error {
+	if err := c.store(d, bytes.NewReader(body)); err != nil {
+		return err
+	}
+	return os.WriteFile(c.blobPath(d)+".type", []byte(mediaType), 0o644)
+}
+
+func (c *Cache) lookupManifest(d digest.Digest) (string, []byte, error) {
+	if err := d.Validate(); err != nil {
+		return "", nil, err
+	}
+	mediaType, err := os.ReadFile(c.blobPath(d) + ".type")
+	if err != nil {
+		return "", nil, err
+	}
+	body, err := os.ReadFile(c.blobPath(d))
+	if err != nil {
+		return "", nil, err
+	}
+	return string(mediaType), body, nil
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+)
+
+// ErrNoBase is returned by DownloadDelta if the cache does not hold the package the delta applies to, or it exceeds
+// Client.MaxDeltaBase.
+var ErrNoBase = errors.New("base of the delta is not cached")
+
+// DefaultMaxDeltaBase is the default of Client.MaxDeltaBase.
+const DefaultMaxDeltaBase = 256 << 20
+
+// DownloadDelta downloads the package at location like Download, but reconstructs it from the package with the digest
+// from in the cache and the delta at deltaLocation instead of downloading it. Deltas are zstd patches, created with
+// e.g. zstd --patch-from=<from> <package> -o <delta>. The reconstructed package must match the digest of location
+// and is cached, so the next delta can be applied to it. It returns ErrNoBase without a cache.
+func (c *Client) DownloadDelta(ctx context.Context, location, from, deltaLocation string) (io.ReadCloser, error) {
+	_, dgst, err := ParseBlobLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	if c.Cache == nil {
+		return nil, ErrNoBase
+	}
+	if c.Cache.Has(dgst) {
+		return c.Download(ctx, location)
+	}
+	if err := c.patch(ctx, dgst, from, deltaLocation); err != nil {
+		return nil, err
+	}
+	log.Printf("Reconstructed %s from %s with delta %s", location, from, deltaLocation)
+	return c.Download(ctx, location)
+}
+
+// patch stores the blob with the digest in the cache, applying the delta to the cached blob from.
+func (c *Client) patch(ctx context.Context, dgst digest.Digest, from, deltaLocation string) error {
+	unlock := c.Cache.lock(dgst)
+	defer unlock()
+	if c.Cache.Has(dgst) {
+		return nil
+	}
+	base, err := c.Cache.Open(digest.Digest(from))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoBase
+		}
+		return err
+	}
+	defer base.Close()
+	info, err := base.Stat()
+	if err != nil {
+		return err
+	}
+	maxBase := cmp.Or(c.MaxDeltaBase, DefaultMaxDeltaBase)
+	if info.Size() > maxBase {
+		log.Printf("Not applying delta %s: its base %s exceeds %d bytes", deltaLocation, from, maxBase)
+		return ErrNoBase
+	}
+	// the base is the dictionary of the patch, which the decoder needs in memory
+	dict, err := io.ReadAll(base)
+	if err != nil {
+		return err
+	}
+	delta, err := c.Download(ctx, deltaLocation)
+	if err != nil {
+		return err
+	}
+	defer delta.Close()
+	dec, err := zstd.NewReader(delta, zstd.WithDecoderDictRaw(0, dict), zstd.WithDecoderMaxWindow(zstd.MaxWindowSize))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	if err = c.Cache.store(dgst, dec); err != nil {
+		var verr *errdefs.VerificationError
+		if errors.As(err, &verr) {
+			return fmt.Errorf("delta %s does not lead from %s to %s: %w", deltaLocation, from, dgst, err)
+		}
+		return fmt.Errorf("failed to apply delta %s: %w", deltaLocation, err)
+	}
+	return nil
+}
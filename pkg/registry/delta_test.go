@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+)
+
+// makeDelta creates a zstd patch from base to target, like zstd --patch-from.
+func makeDelta(t *testing.T, base, target []byte) []byte {
+	t.Helper()
+	var delta bytes.Buffer
+	enc, err := zstd.NewWriter(&delta, zstd.WithEncoderDictRaw(0, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return delta.Bytes()
+}
+
+func TestDownloadDelta(t *testing.T) {
+	base := bytes.Repeat([]byte("version 1 of the package\n"), 1_000)
+	target := append(bytes.Clone(base), []byte("version 2 adds a line\n")...)
+	other := bytes.Repeat([]byte("another package\n"), 1_000)
+
+	tests := []struct {
+		name         string
+		noCache      bool
+		cached       [][]byte
+		delta        []byte
+		maxDeltaBase int64
+		wantErr      error
+		verification bool
+	}{
+		{name: "reconstructed", cached: [][]byte{base}, delta: makeDelta(t, base, target)},
+		{name: "already cached", cached: [][]byte{base, target}},
+		{name: "no cache", noCache: true, delta: makeDelta(t, base, target), wantErr: ErrNoBase},
+		{name: "base not cached", delta: makeDelta(t, base, target), wantErr: ErrNoBase},
+		{name: "base too large", cached: [][]byte{base}, delta: makeDelta(t, base, target), maxDeltaBase: int64(len(base)) - 1, wantErr: ErrNoBase},
+		{name: "delta for another package", cached: [][]byte{base}, delta: makeDelta(t, base, other), verification: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry(t)
+			baseDigest := reg.add(base, base)
+			targetDigest := digest.FromBytes(target) // only the delta is served
+			deltaDigest := reg.add(tt.delta, tt.delta)
+			c := &Client{RC: reg.rc, MaxDeltaBase: tt.maxDeltaBase}
+			if !tt.noCache {
+				cache, err := NewCache(t.TempDir(), reg.rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, b := range tt.cached {
+					if err := cache.store(reg.add(b, b), bytes.NewReader(b)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				c.Cache = cache
+			}
+
+			blob, err := c.DownloadDelta(context.Background(), reg.location(targetDigest), baseDigest.String(), reg.location(deltaDigest))
+			if tt.wantErr != nil || tt.verification {
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DownloadDelta() = %v, want %v", err, tt.wantErr)
+				}
+				var verr *errdefs.VerificationError
+				if tt.verification && !errors.As(err, &verr) {
+					t.Fatalf("DownloadDelta() = %v, want a verification error", err)
+				}
+				if c.Cache != nil && c.Cache.Has(targetDigest) {
+					t.Error("failed reconstruction was cached")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadDelta() = %v", err)
+			}
+			defer blob.Close()
+			b, err := io.ReadAll(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, target) {
+				t.Error("reconstructed package differs")
+			}
+			if !c.Cache.Has(targetDigest) {
+				t.Error("reconstructed package was not cached")
+			}
+		})
+	}
+}
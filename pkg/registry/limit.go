@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter bounds the downloads from registries: the number of concurrent downloads and the bandwidth they share. A
+// nil Limiter imposes no limits.
+type Limiter struct {
+	slots chan struct{}
+	// rate is in bytes per second, unlimited if zero.
+	rate float64
+	mu   sync.Mutex
+	// next is when the bytes read so far are paid for.
+	next time.Time
+}
+
+// NewLimiter allows the given number of concurrent downloads, which share bytesPerSecond. Either is unlimited if zero.
+func NewLimiter(concurrency int, bytesPerSecond int64) *Limiter {
+	l := &Limiter{rate: float64(bytesPerSecond)}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+// acquire waits for a download slot. The returned function releases it.
+func (l *Limiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil || l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-l.slots }) }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// reader throttles reading from r to the shared bandwidth.
+func (l *Limiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil || l.rate <= 0 {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+// wait blocks until n bytes may have been read, or ctx is done.
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// small reads keep the bandwidth of concurrent downloads fair
+	if limit := max(int(lr.l.rate/10), 1); len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if waitErr := lr.l.wait(lr.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// limitedReadCloser releases the download slot when the download is closed.
+type limitedReadCloser struct {
+	io.Reader
+	closer  io.Closer
+	release func()
+}
+
+func (rc *limitedReadCloser) Close() error {
+	rc.release()
+	return rc.closer.Close()
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *Limiter
+		held    int
+		blocks  bool
+	}{
+		{name: "nil", limiter: nil, held: 10},
+		{name: "unlimited", limiter: NewLimiter(0, 0), held: 10},
+		{name: "free slot", limiter: NewLimiter(2, 0), held: 1},
+		{name: "all slots taken", limiter: NewLimiter(2, 0), held: 2, blocks: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range tt.held {
+				if _, err := tt.limiter.acquire(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			release, err := tt.limiter.acquire(ctx)
+			if tt.blocks {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("acquire() = %v, want %v", err, context.DeadlineExceeded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("acquire() = %v", err)
+			}
+			release()
+		})
+	}
+}
+
+func TestLimiterRelease(t *testing.T) {
+	l := NewLimiter(1, 0)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// releasing twice must not free a slot held by another download
+	release()
+	release()
+	if _, err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLimiterReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter *Limiter
+		size    int
+		minTime time.Duration
+	}{
+		{name: "nil", limiter: nil, size: 1 << 20},
+		{name: "unlimited", limiter: NewLimiter(1, 0), size: 1 << 20},
+		// 3,000 bytes at 10,000 bytes per second take 300 ms
+		{name: "throttled", limiter: NewLimiter(1, 10_000), size: 3_000, minTime: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte{'x'}, tt.size)
+			start := time.Now()
+			b, err := io.ReadAll(tt.limiter.reader(context.Background(), bytes.NewReader(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Fatalf("read %d bytes, want %d", len(b), len(content))
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("read took %s, want at least %s", elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestLimiterReaderCancel(t *testing.T) {
+	l := NewLimiter(1, 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := io.ReadAll(l.reader(ctx, bytes.NewReader(make([]byte, 1_000))))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadAll() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	peerAnnounceInterval = 15 * time.Second
+	peerExpiry           = 3 * peerAnnounceInterval
+	// peerConnectTimeout limits connecting to a peer and waiting for its response, peerIdleTimeout a transfer which
+	// stalls. The cache lock of the blob is held meanwhile, so a stuck peer must not delay the registry for long.
+	peerConnectTimeout = 5 * time.Second
+	peerIdleTimeout    = 15 * time.Second
+)
+
+// peerAnnouncement is multicast periodically by every watcher running in P2P mode.
+type peerAnnouncement struct {
+	ID   string `json:"id"`
+	Port int    `json:"port"`
+}
+
+// Peers keeps track of nearby watchers which serve their local blob cache.
+type Peers struct {
+	id     string
+	port   int
+	group  *net.UDPAddr
+	static []string
+
+	mu    sync.Mutex
+	peers map[string]peerInfo // id -> info
+}
+
+type peerInfo struct {
+	addr     string
+	lastSeen time.Time
+}
+
+// NewPeers prepares peer discovery via the multicast group. listen is the address of the local cache server, which is
+// announced to the peers. Static peers are always considered.
+func NewPeers(group string, listen string, static []string) (*Peers, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	_, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.LookupPort("tcp", portStr)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Peers{
+		id:     hex.EncodeToString(id),
+		port:   port,
+		group:  groupAddr,
+		static: static,
+		peers:  make(map[string]peerInfo),
+	}, nil
+}
+
+// Run announces this watcher and listens for announcements of other watchers until the context is cancelled.
+func (ps *Peers) Run(ctx context.Context) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ps.group)
+	if err != nil {
+		log.Println("ERROR: P2P discovery disabled:", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go ps.announce(ctx)
+
+	buf := make([]byte, 1024)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("ERROR: P2P discovery failed:", err)
+			}
+			return
+		}
+		var a peerAnnouncement
+		if err := json.Unmarshal(buf[:n], &a); err != nil || a.ID == ps.id || a.Port == 0 {
+			continue
+		}
+		addr := fmt.Sprintf("http://%s", net.JoinHostPort(src.IP.String(), fmt.Sprint(a.Port)))
+		ps.mu.Lock()
+		if _, known := ps.peers[a.ID]; !known {
+			log.Println("Discovered peer", addr)
+		}
+		ps.peers[a.ID] = peerInfo{addr: addr, lastSeen: time.Now()}
+		ps.mu.Unlock()
+	}
+}
+
+func (ps *Peers) announce(ctx context.Context) {
+	msg, _ := json.Marshal(peerAnnouncement{ID: ps.id, Port: ps.port})
+	ticker := time.NewTicker(peerAnnounceInterval)
+	defer ticker.Stop()
+	for {
+		conn, err := net.DialUDP("udp4", nil, ps.group)
+		if err == nil {
+			_, err = conn.Write(msg)
+			conn.Close()
+		}
+		if err != nil {
+			log.Println("WARN: Failed to announce to peers:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// candidates returns the addresses of all live peers in random order, followed by the static peers.
+func (ps *Peers) candidates() []string {
+	ps.mu.Lock()
+	var addrs []string
+	for id, p := range ps.peers {
+		if time.Since(p.lastSeen) > peerExpiry {
+			delete(ps.peers, id)
+			continue
+		}
+		addrs = append(addrs, p.addr)
+	}
+	ps.mu.Unlock()
+	for i := len(addrs) - 1; i > 0; i-- {
+		j, _ := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		addrs[i], addrs[j.Int64()] = addrs[j.Int64()], addrs[i]
+	}
+	return append(addrs, ps.static...)
+}
+
+// fetch tries to download the blob from peers into the cache. If a peer fails or stalls mid-transfer, the download is
+// resumed from the next peer using a range request. It fails if no peer serves the blob, so it is fetched from the
+// registry instead.
+func (ps *Peers) fetch(ctx context.Context, c *Cache, d digest.Digest) error {
+	target := c.blobPath(d)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-"+d.Encoded())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: peerConnectTimeout}).DialContext,
+		ResponseHeaderTimeout: peerConnectTimeout,
+	}}
+	defer client.CloseIdleConnections()
+	var offset int64
+	for _, addr := range ps.candidates() {
+		offset, err = ps.fetchFrom(ctx, client, addr, d, tmp, offset)
+		if err != nil {
+			log.Printf("WARN: Peer %s failed to serve %s: %s", addr, d, err)
+			continue
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		verifier := d.Verifier()
+		if _, err := io.Copy(verifier, tmp); err != nil {
+			return err
+		}
+		if !verifier.Verified() {
+			// the peer served garbage, start over with the next one
+			log.Printf("WARN: Peer %s served corrupt content for %s", addr, d)
+			offset = 0
+			if err := tmp.Truncate(0); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		log.Printf("Fetched %s from peer %s", d, addr)
+		return os.Rename(tmp.Name(), target)
+	}
+	return fmt.Errorf("no peer serves %s", d)
+}
+
+// fetchFrom continues the download at offset and returns the number of bytes in tmp afterwards.
+func (ps *Peers) fetchFrom(ctx context.Context, client *http.Client, addr string, d digest.Digest, tmp *os.File, offset int64) (int64, error) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, fmt.Sprintf("%s/p2p/blobs/%s", addr, d), nil)
+	if err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// peer ignored the range, start from scratch
+		offset = 0
+		if err := tmp.Truncate(0); err != nil {
+			return offset, err
+		}
+	case http.StatusPartialContent:
+	default:
+		return offset, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if _, err := tmp.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	stalled := time.AfterFunc(peerIdleTimeout, cancel)
+	defer stalled.Stop()
+	n, err := io.Copy(tmp, &idleReader{Reader: resp.Body, timer: stalled})
+	if err != nil && reqCtx.Err() != nil && ctx.Err() == nil {
+		err = fmt.Errorf("stalled for %s", peerIdleTimeout)
+	}
+	return offset + n, err
+}
+
+// idleReader restarts the timer whenever data arrives.
+type idleReader struct {
+	io.Reader
+	timer *time.Timer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(peerIdleTimeout)
+	}
+	return n, err
+}
+
+// PeerHandler serves blobs from the local cache to other watchers on /p2p/blobs/. Unlike the pull-through proxy, it
+// never reaches out to the upstream registry.
+type PeerHandler struct {
+	Cache *Cache
+}
+
+func (h *PeerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, err := digest.Parse(strings.TrimPrefix(r.URL.Path, "/p2p/blobs/"))
+	if err != nil {
+		http.Error(w, "digest invalid", http.StatusBadRequest)
+		return
+	}
+	f, err := h.Cache.Open(d)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// Proxy is a read-only OCI distribution endpoint which serves content from the blob cache and fetches missing content
+// from the upstream registry. Sibling devices use it as a registry mirror. As it pulls with the credentials of the
+// watcher, it should be restricted with an allowlist or a secret.
+type Proxy struct {
+	Upstream string
+	Cache    *Cache
+	// Allowlist restricts the upstream repositories which are served, like Client.Allowlist. Everything is served
+	// if empty.
+	Allowlist []string
+	// Secret is optional. If set, clients must present it as bearer token or as password of basic authentication,
+	// which container runtimes support for mirrors.
+	Secret string
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if !p.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oci-watcher"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/v2/" || r.URL.Path == "/v2" {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+		return
+	}
+
+	p2 := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if i := strings.LastIndex(p2, "/manifests/"); i > 0 {
+		if !p.allowed(w, p2[:i]) {
+			return
+		}
+		p.serveManifest(w, r, p2[:i], p2[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(p2, "/blobs/"); i > 0 {
+		if !p.allowed(w, p2[:i]) {
+			return
+		}
+		p.serveBlob(w, r, p2[:i], digest.Digest(p2[i+len("/blobs/"):]))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// authorized reports whether the request carries the secret, if one is required.
+func (p *Proxy) authorized(r *http.Request) bool {
+	if p.Secret == "" {
+		return true
+	}
+	secret := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		secret = password
+	}
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(p.Secret)) == 1
+}
+
+// allowed reports whether the upstream repository may be served, and responds with an error otherwise.
+func (p *Proxy) allowed(w http.ResponseWriter, repo string) bool {
+	r, err := ref.New(fmt.Sprintf("%s/%s", p.Upstream, repo))
+	if err != nil {
+		http.Error(w, "name invalid", http.StatusBadRequest)
+		return false
+	}
+	if err := checkAllowlist(p.Allowlist, r); err != nil {
+		log.Printf("WARN: Pull-through cache: %s", err)
+		http.Error(w, "repository not allowed", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (p *Proxy) serveManifest(w http.ResponseWriter, r *http.Request, repo, reference string) {
+	d, err := digest.Parse(reference)
+	if err != nil {
+		// reference is a tag: ask the upstream which manifest it points to
+		d, err = p.resolveTag(r.Context(), repo, reference)
+		if err != nil {
+			log.Printf("WARN: Failed to resolve %s:%s: %s", repo, reference, err)
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+	}
+
+	mediaType, body, err := p.Cache.lookupManifest(d)
+	if err != nil {
+		mediaType, body, err = p.fetchManifest(r.Context(), repo, d)
+		if err != nil {
+			log.Printf("WARN: Failed to fetch manifest %s@%s: %s", repo, d, err)
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(body)
+	}
+}
+
+// resolveTag looks up the digest of a tag upstream, falling back to the last known digest when offline.
+func (p *Proxy) resolveTag(ctx context.Context, repo, tag string) (digest.Digest, error) {
+	r, err := ref.New(fmt.Sprintf("%s/%s:%s", p.Upstream, repo, tag))
+	if err != nil {
+		return "", err
+	}
+	mf, err := p.Cache.rc.ManifestHead(ctx, r)
+	if err == nil && mf.GetDescriptor().Digest != "" {
+		desc := mf.GetDescriptor()
+		if err := p.Cache.storeTag(repo, tag, desc.MediaType, desc.Digest); err != nil {
+			log.Println("WARN: Failed to cache tag:", err)
+		}
+		return desc.Digest, nil
+	}
+	_, d, cacheErr := p.Cache.lookupTag(repo, tag)
+	if cacheErr != nil {
+		if err == nil {
+			err = cacheErr
+		}
+		return "", err
+	}
+	log.Printf("WARN: Serving cached %s:%s, upstream unavailable: %v", repo, tag, err)
+	return d, nil
+}
+
+func (p *Proxy) fetchManifest(ctx context.Context, repo string, d digest.Digest) (string, []byte, error) {
+	r, err := ref.New(fmt.Sprintf("%s/%s@%s", p.Upstream, repo, d))
+	if err != nil {
+		return "", nil, err
+	}
+	mf, err := p.Cache.rc.ManifestGet(ctx, r)
+	if err != nil {
+		return "", nil, err
+	}
+	body, err := mf.RawBody()
+	if err != nil {
+		return "", nil, err
+	}
+	mediaType := mf.GetDescriptor().MediaType
+	if err := p.Cache.storeManifest(d, mediaType, body); err != nil {
+		return "", nil, err
+	}
+	return mediaType, body, nil
+}
+
+func (p *Proxy) serveBlob(w http.ResponseWriter, r *http.Request, repo string, d digest.Digest) {
+	if err := d.Validate(); err != nil {
+		http.Error(w, "digest invalid", http.StatusBadRequest)
+		return
+	}
+	upstreamRef, err := ref.New(fmt.Sprintf("%s/%s", p.Upstream, repo))
+	if err != nil {
+		http.Error(w, "name invalid", http.StatusBadRequest)
+		return
+	}
+	f, err := p.Cache.Fetch(r.Context(), upstreamRef, d)
+	if err != nil {
+		log.Printf("WARN: Failed to fetch blob %s@%s: %s", repo, d, err)
+		http.Error(w, "blob unknown", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", d.String())
+	// ServeContent takes care of HEAD and range requests
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
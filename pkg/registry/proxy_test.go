@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestProxyAccess(t *testing.T) {
+	content := []byte("package")
+	tests := []struct {
+		name      string
+		allowlist []string
+		secret    string
+		repo      string
+		auth      func(r *http.Request)
+		want      int
+	}{
+		{name: "open", repo: "org/app", want: http.StatusOK},
+		{name: "allowed repository", allowlist: []string{"HOST/org/*"}, repo: "org/app", want: http.StatusOK},
+		{name: "allowed registry", allowlist: []string{"HOST"}, repo: "other/app", want: http.StatusOK},
+		{name: "repository not allowed", allowlist: []string{"HOST/org/*"}, repo: "other/app", want: http.StatusForbidden},
+		{name: "secret missing", secret: "s3cret", repo: "org/app", want: http.StatusUnauthorized},
+		{name: "secret wrong", secret: "s3cret", repo: "org/app", auth: func(r *http.Request) { r.SetBasicAuth("user", "guess") }, want: http.StatusUnauthorized},
+		{name: "secret as password", secret: "s3cret", repo: "org/app", auth: func(r *http.Request) { r.SetBasicAuth("user", "s3cret") }, want: http.StatusOK},
+		{name: "secret as token", secret: "s3cret", repo: "org/app", auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry(t)
+			d := reg.add(content, content)
+			cache, err := NewCache(t.TempDir(), reg.rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the port of the registry is random
+			var allowlist []string
+			for _, entry := range tt.allowlist {
+				allowlist = append(allowlist, strings.Replace(entry, "HOST", reg.host, 1))
+			}
+			proxy := &Proxy{Upstream: reg.host, Cache: cache, Allowlist: allowlist, Secret: tt.secret}
+
+			req := httptest.NewRequest(http.MethodGet, "/v2/"+tt.repo+"/blobs/"+d.String(), nil)
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("GET = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && digest.FromBytes(rec.Body.Bytes()) != d {
+				t.Error("content differs")
+			}
+			if tt.want != http.StatusOK && cache.Has(d) {
+				t.Error("refused blob was fetched")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// PushFile uploads the file as blob to the repository.
+func PushFile(ctx context.Context, rc *regclient.RegClient, r ref.Ref, mediaType, path string) (descriptor.Descriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	defer f.Close()
+	d, err := digest.SHA256.FromReader(f)
+	if err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	desc := descriptor.Descriptor{MediaType: mediaType, Digest: d, Size: info.Size()}
+	if _, err := rc.BlobPut(ctx, r, desc, f); err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// PushBytes uploads the content as blob to the repository.
+func PushBytes(ctx context.Context, rc *regclient.RegClient, r ref.Ref, mediaType string, content []byte) (descriptor.Descriptor, error) {
+	desc := descriptor.Descriptor{MediaType: mediaType, Digest: digest.SHA256.FromBytes(content), Size: int64(len(content))}
+	if _, err := rc.BlobPut(ctx, r, desc, bytes.NewReader(content)); err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// PushArtifact pushes an OCI 1.1 artifact manifest referencing the given layers, which must have been pushed before.
+// Registries keep blobs only as long as a manifest references them. The subject is optional and turns the artifact
+// into a referrer, e.g. a signature.
+func PushArtifact(ctx context.Context, rc *regclient.RegClient, r ref.Ref, artifactType string, layers []descriptor.Descriptor, subject *descriptor.Descriptor) (manifest.Manifest, error) {
+	empty := descriptor.Descriptor{MediaType: mediatype.OCI1Empty, Digest: descriptor.EmptyDigest, Size: int64(len(descriptor.EmptyData))}
+	if _, err := rc.BlobPut(ctx, r, empty, bytes.NewReader(descriptor.EmptyData)); err != nil {
+		return nil, err
+	}
+	mf, err := manifest.New(manifest.WithOrig(v1.Manifest{
+		Versioned:    v1.ManifestSchemaVersion,
+		MediaType:    mediatype.OCI1Manifest,
+		ArtifactType: artifactType,
+		Config:       empty,
+		Layers:       layers,
+		Subject:      subject,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if subject != nil {
+		// referrers are not tagged
+		r = r.SetDigest(mf.GetDescriptor().Digest.String())
+	}
+	if err := rc.ManifestPut(ctx, r, mf); err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
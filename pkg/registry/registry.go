@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package registry downloads content from OCI registries, optionally through a local cache which can in turn be
+// served to other devices.
+package registry
+
+import (
+	"context"
+	_ "crypto/sha512" // registers sha384 and sha512 digests
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/tracing"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Client downloads blobs referenced by the desired state.
+type Client struct {
+	RC *regclient.RegClient
+	// Cache is optional. If set, all downloads go through it.
+	Cache *Cache
+	// Allowlist restricts the locations which may be downloaded. Entries are registries (e.g. ghcr.io) or
+	// repositories, which may contain path.Match patterns (e.g. ghcr.io/org/*). Everything is allowed if empty.
+	Allowlist []string
+	// Limiter bounds the downloads of blobs, including those of the cache, see Cache.UseLimiter. Optional.
+	Limiter *Limiter
+	// MaxDeltaBase limits the size of the packages deltas are applied to, which are held in memory, see DownloadDelta.
+	// Defaults to DefaultMaxDeltaBase.
+	MaxDeltaBase int64
+
+	resolved sync.Map // location and platform -> location of the package blob, see ResolvePackage
+}
+
+// CheckLocation returns an error if the location is not covered by the allowlist.
+func (c *Client) CheckLocation(location string) error {
+	if len(c.Allowlist) == 0 {
+		return nil
+	}
+	r, _, err := ParseBlobLocation(location)
+	if err != nil {
+		return err
+	}
+	if err := checkAllowlist(c.Allowlist, r); err != nil {
+		return fmt.Errorf("%s: %w", location, err)
+	}
+	return nil
+}
+
+// checkAllowlist returns an error if the repository of r is not covered by the allowlist, see Client.Allowlist.
+func checkAllowlist(allowlist []string, r ref.Ref) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	repo := r.Registry + "/" + r.Repository
+	if r.Scheme == "ocidir" {
+		repo = "ocidir://" + r.Path
+	}
+	for _, entry := range allowlist {
+		if entry == r.Registry && r.Scheme != "ocidir" {
+			return nil
+		}
+		if match, _ := path.Match(entry, repo); match {
+			return nil
+		}
+	}
+	return fmt.Errorf("repository %s is not allowed", repo)
+}
+
+var blobURLRe = regexp.MustCompile(`^http://ghcr\.io/v2/([^/]+)/([^/]+)/blobs/([a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+)$`)
+
+// ParseBlobLocation parses a keyLocation or packageLocation. It is either the HTTP URL of the blob
+// (http://ghcr.io/v2/<owner>/<repo>/blobs/<digest>) or a digest-pinned reference (<registry>/<repo>@<digest>).
+// Digests may use any algorithm supported by go-digest, e.g. sha256 or sha512.
+func ParseBlobLocation(location string) (ref.Ref, digest.Digest, error) {
+	if matches := blobURLRe.FindStringSubmatch(location); len(matches) == 4 {
+		owner, repo := matches[1], matches[2]
+		d, err := digest.Parse(matches[3])
+		if err != nil {
+			return ref.Ref{}, "", fmt.Errorf("invalid digest in %s: %w", location, err)
+		}
+		r, err := ref.New(fmt.Sprintf("ghcr.io/%s/%s:latest", owner, repo))
+		return r, d, err
+	}
+	if repo, dgst, found := strings.Cut(location, "@"); found && !strings.Contains(location, "://") {
+		d, err := digest.Parse(dgst)
+		if err != nil {
+			return ref.Ref{}, "", fmt.Errorf("invalid digest in %s: %w", location, err)
+		}
+		r, err := ref.New(repo)
+		if err != nil {
+			return ref.Ref{}, "", err
+		}
+		return r, d, nil
+	}
+	return ref.Ref{}, "", fmt.Errorf("unsupported URL format: %s", location)
+}
+
+// Download downloads the given OCI registry url. This is a simple HTTP GET request. The blob is streamed, so it may be
+// processed while it is downloaded; its digest is verified once it was read to the end.
+func (c *Client) Download(ctx context.Context, url string) (_ io.ReadCloser, err error) {
+	log.Printf("Downloading %s", url)
+	ctx, span := tracing.Start(ctx, "download blob", attribute.String("location", url))
+	defer tracing.End(span, &err)
+
+	if err := c.CheckLocation(url); err != nil {
+		return nil, err
+	}
+	appRef, dgst, err := ParseBlobLocation(url)
+	if err != nil {
+		return nil, err
+	}
+	if c.Cache != nil {
+		blob, err := c.Cache.Stream(ctx, appRef, dgst)
+		if err != nil {
+			return nil, errdefs.FromRegistry(err)
+		}
+		return blob, nil
+	}
+	release, err := c.Limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := c.RC.BlobGet(ctx, appRef, descriptor.Descriptor{Digest: dgst})
+	if err != nil {
+		release()
+		return nil, errdefs.FromRegistry(err)
+	}
+	return &limitedReadCloser{Reader: c.Limiter.reader(ctx, blob), closer: blob, release: release}, nil
+}
+
+// Size returns the size of the blob at the location, which is taken from the cache if it holds the blob. It is
+// zero if the registry does not report it.
+func (c *Client) Size(ctx context.Context, location string) (int64, error) {
+	appRef, dgst, err := ParseBlobLocation(location)
+	if err != nil {
+		return 0, err
+	}
+	if c.Cache != nil {
+		if f, err := c.Cache.Open(dgst); err == nil {
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				return 0, err
+			}
+			return info.Size(), nil
+		}
+	}
+	head, err := c.RC.BlobHead(ctx, appRef, descriptor.Descriptor{Digest: dgst})
+	if err != nil {
+		return 0, err
+	}
+	defer head.Close()
+	return head.GetDescriptor().Size, nil
+}
+
+// FetchBlob reads a (small) blob into memory.
+func FetchBlob(ctx context.Context, rc *regclient.RegClient, r ref.Ref, desc descriptor.Descriptor) ([]byte, error) {
+	reader, err := rc.BlobGet(ctx, r, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
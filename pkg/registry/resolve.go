@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+)
+
+// PackageMediaType is the media type of application packages, which are pushed as layer of an artifact together
+// with the key verifying them.
+const PackageMediaType = "application/vnd.margo.package.v1.tar+gzip"
+
+// ResolvePackage returns the location of the package blob for the platform. A packageLocation may reference the
+// package blob itself, the artifact holding the package, or an image index of such artifacts per platform, so one
+// component can carry e.g. amd64 and arm64 packages. Locations of blobs are returned as they are. As manifests and
+// blobs are addressed by their digest, resolutions are remembered.
+func (c *Client) ResolvePackage(ctx context.Context, location string, p platform.Platform) (string, error) {
+	repo, _, found := strings.Cut(location, "@")
+	if !found || blobURLRe.MatchString(location) {
+		return location, nil
+	}
+	key := location + " " + p.String()
+	if resolved, ok := c.resolved.Load(key); ok {
+		return resolved.(string), nil
+	}
+	_, mf, err := c.artifact(ctx, location, p)
+	if err != nil {
+		return "", err
+	}
+	resolved := location
+	if mf != nil {
+		layer, err := packageLayer(mf)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", location, err)
+		}
+		resolved = repo + "@" + layer.Digest.String()
+		log.Printf("Resolved package %s for platform %s to %s", location, p, resolved)
+	}
+	c.resolved.Store(key, resolved)
+	return resolved, nil
+}
+
+// PackageArtifact returns the reference of the artifact holding the package at location for the platform, which is
+// the subject of e.g. the SBOMs of the package. It fails if location references the package blob itself.
+func (c *Client) PackageArtifact(ctx context.Context, location string, p platform.Platform) (ref.Ref, error) {
+	r, mf, err := c.artifact(ctx, location, p)
+	if err != nil {
+		return ref.Ref{}, err
+	}
+	if mf == nil {
+		return ref.Ref{}, fmt.Errorf("%s references the package blob instead of the artifact holding it", location)
+	}
+	return r.SetDigest(mf.GetDescriptor().Digest.String()), nil
+}
+
+// artifact returns the repository of location and the artifact manifest for the platform it references, directly or
+// via an image index. The manifest is nil if location references a blob.
+func (c *Client) artifact(ctx context.Context, location string, p platform.Platform) (ref.Ref, manifest.Manifest, error) {
+	r, dgst, err := ParseBlobLocation(location)
+	if err != nil {
+		return r, nil, err
+	}
+	mf, err := c.manifest(ctx, r, dgst)
+	if err != nil || mf == nil || !mf.IsList() {
+		return r, mf, err
+	}
+	desc, err := manifest.GetPlatformDesc(mf, &p)
+	if err != nil {
+		return r, nil, fmt.Errorf("%s: no package for platform %s: %w", location, p, errdefs.FromRegistry(err))
+	}
+	if mf, err = c.manifest(ctx, r, desc.Digest); err != nil {
+		return r, nil, err
+	}
+	if mf == nil {
+		return r, nil, fmt.Errorf("%s: package for platform %s is not a manifest", location, p)
+	}
+	return r, mf, nil
+}
+
+// manifest returns the manifest with the digest, or nil if the digest references a blob. Manifests are kept in the
+// cache, if any, so they can be resolved while the registry is unreachable.
+func (c *Client) manifest(ctx context.Context, r ref.Ref, d digest.Digest) (manifest.Manifest, error) {
+	if c.Cache != nil {
+		if mediaType, body, err := c.Cache.lookupManifest(d); err == nil {
+			return manifest.New(manifest.WithDesc(descriptor.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(body))}), manifest.WithRaw(body))
+		}
+		if c.Cache.Has(d) {
+			return nil, nil
+		}
+	}
+	mf, err := c.RC.ManifestGet(ctx, r.SetDigest(d.String()))
+	if err != nil {
+		// registries do not serve blobs as manifests
+		head, headErr := c.RC.BlobHead(ctx, r, descriptor.Descriptor{Digest: d})
+		if headErr != nil {
+			return nil, errdefs.FromRegistry(err)
+		}
+		head.Close()
+		return nil, nil
+	}
+	if c.Cache != nil {
+		body, err := mf.RawBody()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Cache.storeManifest(d, mf.GetDescriptor().MediaType, body); err != nil {
+			log.Println("WARN: Failed to cache manifest:", err)
+		}
+	}
+	return mf, nil
+}
+
+// packageLayer returns the package layer of an artifact, which is the layer of type PackageMediaType or else the
+// only layer.
+func packageLayer(mf manifest.Manifest) (descriptor.Descriptor, error) {
+	imager, ok := mf.(manifest.Imager)
+	if !ok {
+		return descriptor.Descriptor{}, fmt.Errorf("unsupported manifest type %s", mf.GetDescriptor().MediaType)
+	}
+	layers, err := imager.GetLayers()
+	if err != nil {
+		return descriptor.Descriptor{}, err
+	}
+	for _, layer := range layers {
+		if layer.MediaType == PackageMediaType {
+			return layer, nil
+		}
+	}
+	if len(layers) == 1 {
+		return layers[0], nil
+	}
+	return descriptor.Descriptor{}, fmt.Errorf("artifact has no layer of type %s", PackageMediaType)
+}
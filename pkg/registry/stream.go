@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+)
+
+// Stream returns the blob like Fetch, but a blob downloaded from the registry is returned while it is downloaded, so
+// large packages can be extracted without waiting for the download. The blob is committed to the cache once it was
+// read to the end and matches the digest; reading it fails otherwise. Concurrent fetches of the blob wait until the
+// returned reader is closed.
+func (c *Cache) Stream(ctx context.Context, r ref.Ref, d digest.Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	unlock := c.lock(d)
+	if f, err := c.Open(d); err == nil {
+		unlock()
+		return f, nil
+	}
+	if c.peers != nil {
+		if err := c.peers.fetch(ctx, c, d); err == nil {
+			unlock()
+			return c.Open(d)
+		}
+	}
+
+	release, err := c.limit.acquire(ctx)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	blob, err := c.rc.BlobGet(ctx, r, descriptor.Descriptor{Digest: d})
+	if err != nil {
+		release()
+		unlock()
+		return nil, err
+	}
+	target := c.blobPath(d)
+	if err = os.MkdirAll(filepath.Dir(target), 0o755); err == nil {
+		var tmp *os.File
+		if tmp, err = os.CreateTemp(filepath.Dir(target), ".tmp-"+d.Encoded()); err == nil {
+			return &streamedBlob{
+				Reader:   c.limit.reader(ctx, blob),
+				blob:     blob,
+				tmp:      tmp,
+				target:   target,
+				digest:   d,
+				verifier: d.Verifier(),
+				done:     func() { release(); unlock() },
+			}, nil
+		}
+	}
+	blob.Close()
+	release()
+	unlock()
+	return nil, err
+}
+
+// streamedBlob writes a blob to the cache while it is read.
+type streamedBlob struct {
+	io.Reader
+	blob     io.Closer
+	tmp      *os.File
+	target   string
+	digest   digest.Digest
+	verifier digest.Verifier
+	done     func()
+	err      error
+	closed   sync.Once
+}
+
+func (b *streamedBlob) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.Reader.Read(p)
+	if n > 0 {
+		b.verifier.Write(p[:n])
+		if _, writeErr := b.tmp.Write(p[:n]); writeErr != nil {
+			err = writeErr
+		}
+	}
+	if err == io.EOF {
+		if commitErr := b.commit(); commitErr != nil {
+			err = commitErr
+		}
+	}
+	b.err = err
+	return n, err
+}
+
+// commit moves the blob into the cache if it matches the digest.
+func (b *streamedBlob) commit() error {
+	if !b.verifier.Verified() {
+		return &errdefs.VerificationError{Err: fmt.Errorf("digest mismatch for %s", b.digest)}
+	}
+	if err := b.tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(b.tmp.Name(), b.target)
+}
+
+// Close discards the blob unless it was committed.
+func (b *streamedBlob) Close() (err error) {
+	b.closed.Do(func() {
+		err = b.blob.Close()
+		b.tmp.Close()
+		if b.err != io.EOF {
+			os.Remove(b.tmp.Name())
+		}
+		b.done()
+	})
+	return err
+}
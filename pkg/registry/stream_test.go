@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/config"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// testRegistry serves blobs by digest over plain HTTP. The content does not have to match the digest, so corrupt
+// blobs can be served.
+type testRegistry struct {
+	host  string
+	rc    *regclient.RegClient
+	blobs map[digest.Digest][]byte
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+	reg := &testRegistry{blobs: make(map[digest.Digest][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		_, d, found := strings.Cut(r.URL.Path, "/blobs/")
+		content, ok := reg.blobs[digest.Digest(d)]
+		if !found || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", d)
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(srv.Close)
+	reg.host = strings.TrimPrefix(srv.URL, "http://")
+	reg.rc = regclient.New(regclient.WithConfigHost(config.Host{Name: reg.host, TLS: config.TLSDisabled}))
+	return reg
+}
+
+// add serves the content under the digest of want.
+func (reg *testRegistry) add(want, content []byte) digest.Digest {
+	d := digest.FromBytes(want)
+	reg.blobs[d] = content
+	return d
+}
+
+// location returns the packageLocation of the blob.
+func (reg *testRegistry) location(d digest.Digest) string {
+	return reg.host + "/app@" + d.String()
+}
+
+func (reg *testRegistry) ref(t *testing.T) ref.Ref {
+	t.Helper()
+	r, err := ref.New(reg.host + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCacheStream(t *testing.T) {
+	content := bytes.Repeat([]byte("package"), 10_000)
+	tests := []struct {
+		name    string
+		served  []byte
+		readAll bool
+		wantErr bool
+		cached  bool
+	}{
+		{name: "complete", served: content, readAll: true, cached: true},
+		{name: "closed early", served: content},
+		{name: "corrupt", served: append(bytes.Clone(content[:len(content)-1]), '!'), readAll: true, wantErr: true},
+		{name: "truncated", served: content[:len(content)/2], readAll: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry(t)
+			d := reg.add(content, tt.served)
+			cache, err := NewCache(t.TempDir(), reg.rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blob, err := cache.Stream(context.Background(), reg.ref(t), d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.readAll {
+				b, err := io.ReadAll(blob)
+				if tt.wantErr != (err != nil) {
+					t.Errorf("ReadAll() = %v, want error: %t", err, tt.wantErr)
+				}
+				if err == nil && !bytes.Equal(b, content) {
+					t.Error("content differs")
+				}
+			} else if _, err := blob.Read(make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+			if err := blob.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := cache.Has(d); got != tt.cached {
+				t.Errorf("Has() = %t, want %t", got, tt.cached)
+			}
+			// neither partial nor corrupt downloads are left behind
+			tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(cache.blobPath(d)), ".tmp-*"))
+			if len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+			// the lock was released
+			if f, err := cache.Stream(context.Background(), reg.ref(t), d); err != nil {
+				t.Fatal(err)
+			} else {
+				f.Close()
+			}
+		})
+	}
+}
+
+func TestCacheStreamCached(t *testing.T) {
+	reg := newTestRegistry(t)
+	content := []byte("package")
+	d := digest.FromBytes(content)
+	cache, err := NewCache(t.TempDir(), reg.rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.store(d, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the registry does not serve the blob, so it must come from the cache
+	blob, err := cache.Stream(context.Background(), reg.ref(t), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, ok := blob.(*os.File); !ok {
+		t.Errorf("Stream() = %T, want the cached file", blob)
+	}
+	b, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("content differs")
+	}
+}
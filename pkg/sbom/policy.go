@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package sbom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy decides whether a package may be deployed based on its SBOMs. The zero Policy accepts every package.
+type Policy struct {
+	// Required rejects packages without SBOM.
+	Required bool `yaml:"required"`
+	// DenyLicenses lists SPDX license identifiers which must not occur, e.g. GPL-3.0-only. Patterns as understood by
+	// path.Match are supported, e.g. AGPL-*.
+	DenyLicenses []string `yaml:"denyLicenses"`
+	// DenyComponents lists components which must not occur, as name or name@version patterns, e.g. log4j-core@2.14.*.
+	DenyComponents []string `yaml:"denyComponents"`
+}
+
+// LoadPolicy reads a policy from a YAML file of the form:
+//
+//	required: true
+//	denyLicenses: [AGPL-*, SSPL-1.0]
+//	denyComponents: [log4j-core@2.14.*]
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, p.validate()
+}
+
+func (p *Policy) validate() error {
+	var errs []error
+	for i, pattern := range slices.Concat(p.DenyLicenses, p.DenyComponents) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("pattern %d %q: %w", i, pattern, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check returns an error listing all violations of the policy.
+func (p *Policy) Check(docs []Document) error {
+	if p.Required && len(docs) == 0 {
+		return errors.New("package has no SBOM")
+	}
+	var violations []string
+	for _, doc := range docs {
+		pkgs, err := doc.Packages()
+		if err != nil {
+			return fmt.Errorf("invalid SBOM %s: %w", doc.Referrer, err)
+		}
+		for _, pkg := range pkgs {
+			if matchAny(p.DenyComponents, pkg.Name) || (pkg.Version != "" && matchAny(p.DenyComponents, pkg.Name+"@"+pkg.Version)) {
+				violations = append(violations, fmt.Sprintf("component %s@%s is denied", pkg.Name, pkg.Version))
+			}
+			for _, license := range pkg.Licenses {
+				if matchAny(p.DenyLicenses, license) {
+					violations = append(violations, fmt.Sprintf("component %s@%s has denied license %s", pkg.Name, pkg.Version, license))
+				}
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("SBOM policy violated: %s", strings.Join(slices.Compact(violations), "; "))
+	}
+	return nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		match, _ := path.Match(pattern, s)
+		return match
+	})
+}
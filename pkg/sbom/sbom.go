@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package sbom retrieves the software bills of materials attached to packages and checks them against a policy.
+package sbom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+)
+
+const (
+	// SPDXArtifactType identifies referrers holding an SPDX document in JSON format.
+	SPDXArtifactType = "application/spdx+json"
+	// CycloneDXArtifactType identifies referrers holding a CycloneDX document in JSON format.
+	CycloneDXArtifactType = "application/vnd.cyclonedx+json"
+)
+
+// Document is an SBOM attached to a package.
+type Document struct {
+	ArtifactType string
+	// Referrer is the digest of the referrer manifest holding the document.
+	Referrer string
+	Content  []byte
+}
+
+// Detect returns the artifact type of an SBOM document, i.e. SPDXArtifactType or CycloneDXArtifactType.
+func Detect(content []byte) (string, error) {
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		BOMFormat   string `json:"bomFormat"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return "", fmt.Errorf("not a JSON SBOM: %w", err)
+	}
+	switch {
+	case doc.SPDXVersion != "":
+		return SPDXArtifactType, nil
+	case doc.BOMFormat == "CycloneDX":
+		return CycloneDXArtifactType, nil
+	}
+	return "", errors.New("neither an SPDX nor a CycloneDX document")
+}
+
+// Fetch returns the SBOMs attached as referrers to the artifact manifest holding a package.
+func Fetch(ctx context.Context, rc *regclient.RegClient, r ref.Ref) ([]Document, error) {
+	referrers, err := rc.ReferrerList(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	var docs []Document
+	for _, desc := range referrers.Descriptors {
+		if desc.ArtifactType != SPDXArtifactType && desc.ArtifactType != CycloneDXArtifactType {
+			continue
+		}
+		referrer := r.SetDigest(desc.Digest.String())
+		mf, err := rc.ManifestGet(ctx, referrer)
+		if err != nil {
+			return nil, err
+		}
+		imager, ok := mf.(manifest.Imager)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported manifest type %s", desc.Digest, desc.MediaType)
+		}
+		layers, err := imager.GetLayers()
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			content, err := registry.FetchBlob(ctx, rc, r, layer)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", desc.Digest, err)
+			}
+			docs = append(docs, Document{ArtifactType: desc.ArtifactType, Referrer: desc.Digest.String(), Content: content})
+		}
+	}
+	log.Printf("Found %d SBOM(s) for %s", len(docs), r.CommonName())
+	return docs, nil
+}
+
+// Store writes the documents into dir, replacing previously stored ones.
+func Store(dir string, docs []Document) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		ext := ".cdx.json"
+		if doc.ArtifactType == SPDXArtifactType {
+			ext = ".spdx.json"
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("sbom-%d%s", i, ext)), doc.Content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Package is a software component listed in an SBOM.
+type Package struct {
+	Name     string
+	Version  string
+	Licenses []string
+}
+
+// Packages returns the software components listed in the document.
+func (d Document) Packages() ([]Package, error) {
+	if d.ArtifactType == SPDXArtifactType {
+		var doc struct {
+			Packages []struct {
+				Name             string `json:"name"`
+				VersionInfo      string `json:"versionInfo"`
+				LicenseConcluded string `json:"licenseConcluded"`
+				LicenseDeclared  string `json:"licenseDeclared"`
+			} `json:"packages"`
+		}
+		if err := json.Unmarshal(d.Content, &doc); err != nil {
+			return nil, err
+		}
+		pkgs := make([]Package, 0, len(doc.Packages))
+		for _, p := range doc.Packages {
+			pkgs = append(pkgs, Package{Name: p.Name, Version: p.VersionInfo, Licenses: licenseIDs(p.LicenseConcluded, p.LicenseDeclared)})
+		}
+		return pkgs, nil
+	}
+
+	var doc struct {
+		Components []cdxComponent `json:"components"`
+	}
+	if err := json.Unmarshal(d.Content, &doc); err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	var walk func([]cdxComponent)
+	walk = func(components []cdxComponent) {
+		for _, c := range components {
+			var expressions, names []string
+			for _, l := range c.Licenses {
+				expressions = append(expressions, l.Expression, l.License.ID)
+				if l.License.Name != "" {
+					names = append(names, l.License.Name)
+				}
+			}
+			pkgs = append(pkgs, Package{Name: c.Name, Version: c.Version, Licenses: append(licenseIDs(expressions...), names...)})
+			walk(c.Components)
+		}
+	}
+	walk(doc.Components)
+	return pkgs, nil
+}
+
+type cdxComponent struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Licenses []struct {
+		Expression string `json:"expression"`
+		License    struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"license"`
+	} `json:"licenses"`
+	Components []cdxComponent `json:"components"`
+}
+
+// licenseIDs splits SPDX license expressions such as "(MIT OR GPL-3.0-only)" into the license identifiers.
+func licenseIDs(expressions ...string) []string {
+	var ids []string
+	for _, expr := range expressions {
+		for _, token := range strings.FieldsFunc(expr, func(r rune) bool { return r == ' ' || r == '(' || r == ')' }) {
+			switch token {
+			case "AND", "OR", "WITH", "NOASSERTION", "NONE":
+				continue
+			}
+			if !slices.Contains(ids, token) {
+				ids = append(ids, token)
+			}
+		}
+	}
+	return ids
+}
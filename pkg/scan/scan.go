@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package scan blocks deployments whose bundled images contain vulnerabilities.
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Severities in ascending order.
+var Severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// Gate scans image tars with Trivy (https://trivy.dev), either locally or in client mode against a Trivy server.
+type Gate struct {
+	// Severity is the lowest severity which blocks a deployment, e.g. HIGH.
+	Severity string
+	// Server is the URL of a Trivy server. Scans run locally if empty.
+	Server string
+	// IgnoreUnfixed ignores vulnerabilities without fix.
+	IgnoreUnfixed bool
+	// Command defaults to trivy.
+	Command []string
+}
+
+// Vulnerability is a finding of the scanner.
+type Vulnerability struct {
+	ID       string `json:"VulnerabilityID"`
+	Package  string `json:"PkgName"`
+	Version  string `json:"InstalledVersion"`
+	Severity string `json:"Severity"`
+}
+
+// Validate checks the configured severity.
+func (g *Gate) Validate() error {
+	if !slices.Contains(Severities, strings.ToUpper(g.Severity)) {
+		return fmt.Errorf("unknown severity %q, expected one of %s", g.Severity, strings.Join(Severities, ", "))
+	}
+	return nil
+}
+
+// Check scans all image tars below dir and fails if any has vulnerabilities of at least the configured severity which
+// are not in ignore. An ignore list containing "*" skips the scan.
+func (g *Gate) Check(ctx context.Context, dir string, ignore []string) error {
+	if slices.Contains(ignore, "*") {
+		log.Println("WARN: Vulnerability scan skipped by annotation")
+		return nil
+	}
+	var images []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".tar") {
+			images = append(images, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var findings []string
+	for _, image := range images {
+		vulns, err := g.scan(ctx, image)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", filepath.Base(image), err)
+		}
+		for _, v := range vulns {
+			if slices.Contains(ignore, v.ID) {
+				log.Printf("WARN: %s: ignoring %s in %s@%s", filepath.Base(image), v.ID, v.Package, v.Version)
+				continue
+			}
+			findings = append(findings, fmt.Sprintf("%s (%s, %s@%s)", v.ID, v.Severity, v.Package, v.Version))
+		}
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	slices.Sort(findings)
+	findings = slices.Compact(findings)
+	summary := strings.Join(findings, ", ")
+	if len(findings) > 10 {
+		summary = strings.Join(findings[:10], ", ") + fmt.Sprintf(" and %d more", len(findings)-10)
+	}
+	return fmt.Errorf("found %d vulnerabilities of severity %s or higher: %s", len(findings), strings.ToUpper(g.Severity), summary)
+}
+
+// scan returns the vulnerabilities of at least the configured severity in the image tar.
+func (g *Gate) scan(ctx context.Context, image string) ([]Vulnerability, error) {
+	command := g.Command
+	if len(command) == 0 {
+		command = []string{"trivy"}
+	}
+	severity := strings.ToUpper(g.Severity)
+	args := []string{"image", "--input", image, "--format", "json", "--quiet", "--exit-code", "0",
+		"--severity", strings.Join(Severities[slices.Index(Severities, severity):], ",")}
+	if g.Server != "" {
+		args = append(args, "--server", g.Server)
+	}
+	if g.IgnoreUnfixed {
+		args = append(args, "--ignore-unfixed")
+	}
+	log.Println("Scanning", image)
+	cmd := exec.CommandContext(ctx, command[0], append(slices.Clone(command[1:]), args...)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var report struct {
+		Results []struct {
+			Vulnerabilities []Vulnerability `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("invalid report: %w", err)
+	}
+	var vulns []Vulnerability
+	for _, result := range report.Results {
+		vulns = append(vulns, result.Vulnerabilities...)
+	}
+	return vulns, nil
+}
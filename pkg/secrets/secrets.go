@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package secrets resolves secret references of desired-state parameters, so credentials are never stored in plaintext
+// in the desired state.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// Resolver resolves secret references of the form
+//
+//	vault:<path>#<field>     a field of a secret in HashiCorp Vault, e.g. vault:secret/data/devices/db#password
+//	sops:<location>#<key>    a key of a SOPS-encrypted YAML or JSON blob, e.g. sops:ghcr.io/org/secrets@sha256:...#db.password
+//
+// SOPS files are decrypted with the sops CLI, which finds the device's key as usual, e.g. via SOPS_AGE_KEY_FILE.
+type Resolver struct {
+	// Registry downloads SOPS files.
+	Registry *registry.Client
+	// VaultAddr is the address of the Vault server, e.g. https://vault:8200.
+	VaultAddr  string
+	VaultToken string
+	// Client defaults to a client with a 30s timeout.
+	Client *http.Client
+	// SOPSCommand defaults to sops.
+	SOPSCommand []string
+}
+
+// Resolve returns the value of the secret.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	scheme, rest, _ := strings.Cut(ref, ":")
+	location, key, found := strings.Cut(rest, "#")
+	if !found || location == "" || key == "" {
+		return "", fmt.Errorf("invalid secret reference %q", ref)
+	}
+	switch scheme {
+	case "vault":
+		return r.vault(ctx, location, key)
+	case "sops":
+		return r.sops(ctx, location, key)
+	}
+	return "", fmt.Errorf("unsupported secret reference %q", ref)
+}
+
+func (r *Resolver) vault(ctx context.Context, path, field string) (string, error) {
+	if r.VaultAddr == "" || r.VaultToken == "" {
+		return "", errors.New("vault secrets require a Vault address and token")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(r.VaultAddr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.VaultToken)
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: %s: unexpected status %s", path, resp.Status)
+	}
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("vault: %s: %w", path, err)
+	}
+	data := secret.Data
+	// KV version 2 nests the secret
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	value, found := data[field]
+	if !found {
+		return "", fmt.Errorf("vault: %s: no field %q", path, field)
+	}
+	return stringValue(value), nil
+}
+
+func (r *Resolver) sops(ctx context.Context, location, key string) (string, error) {
+	if r.Registry == nil {
+		return "", errors.New("sops secrets require a registry client")
+	}
+	blob, err := r.Registry.Download(ctx, location)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		return "", err
+	}
+
+	command := r.SOPSCommand
+	if len(command) == 0 {
+		command = []string{"sops"}
+	}
+	inputType := "yaml"
+	if json.Valid(encrypted) {
+		inputType = "json"
+	}
+	args := append(slices.Clone(command[1:]), "--decrypt", "--input-type", inputType, "--output-type", "json", "/dev/stdin")
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stdin = bytes.NewReader(encrypted)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("sops: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var doc any
+	if err := yaml.Unmarshal(out, &doc); err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	for _, k := range strings.Split(key, ".") {
+		m, ok := doc.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("sops: %s: no key %q", location, key)
+		}
+		if doc, ok = m[k]; !ok {
+			return "", fmt.Errorf("sops: %s: no key %q", location, key)
+		}
+	}
+	return stringValue(doc), nil
+}
+
+func stringValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// gitSource reads the desired state from a branch, tag or commit of a git repository. The path may point to a file
+// or a directory, in which case all YAML files in it are read in lexical order.
+type gitSource struct {
+	url  string
+	ref  string
+	path string
+	dir  string
+}
+
+func newGitSource(spec string, opts Options) (*gitSource, error) {
+	url, fragment, _ := strings.Cut(spec, "#")
+	if url == "" {
+		return nil, fmt.Errorf("invalid git source %q", spec)
+	}
+	gitRef, path, _ := strings.Cut(fragment, ":")
+	if gitRef == "" {
+		gitRef = "HEAD"
+	}
+	base := opts.WorkDir
+	if base == "" {
+		var err error
+		if base, err = os.UserCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	h := sha256.Sum256([]byte(url))
+	return &gitSource{
+		url:  url,
+		ref:  gitRef,
+		path: filepath.Clean("/" + path)[1:],
+		dir:  filepath.Join(base, "oci-watcher", "git", hex.EncodeToString(h[:8])),
+	}, nil
+}
+
+func (s *gitSource) Fetch(ctx context.Context) ([]byte, string, error) {
+	if !fsutil.FileExists(filepath.Join(s.dir, ".git")) {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return nil, "", err
+		}
+		if err := s.git(ctx, "init", "--quiet"); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := s.git(ctx, "fetch", "--quiet", "--depth", "1", s.url, s.ref); err != nil {
+		return nil, "", err
+	}
+	if err := s.git(ctx, "checkout", "--quiet", "--force", "FETCH_HEAD"); err != nil {
+		return nil, "", err
+	}
+
+	target := filepath.Join(s.dir, s.path)
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, "", err
+	}
+	if !info.IsDir() {
+		b, err := os.ReadFile(target)
+		if err != nil {
+			return nil, "", err
+		}
+		return b, mediaTypeForFile(target), nil
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(target, pattern))
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, "", fmt.Errorf("no YAML files found in %s", s.path)
+	}
+	sort.Strings(files)
+	var buf bytes.Buffer
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, "", err
+		}
+		buf.WriteString("---\n")
+		buf.Write(b)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), DesiredStateMediaType, nil
+}
+
+func (s *gitSource) git(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = s.dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s *gitSource) String() string {
+	return fmt.Sprintf("git+%s#%s:%s", s.url, s.ref, s.path)
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+)
+
+// fileSource reads the desired state from the local filesystem.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) Fetch(context.Context) ([]byte, string, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, mediaTypeForFile(s.path), nil
+}
+
+func (s *fileSource) String() string {
+	return "file://" + s.path
+}
+
+// httpSource polls the desired state from a URL. The ETag of the last response is sent with every request so the
+// server can answer with 304 Not Modified, in which case the previous content is reused.
+type httpSource struct {
+	url    string
+	client *http.Client
+
+	etag      string
+	content   []byte
+	mediaType string
+}
+
+func (s *httpSource) Fetch(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", DesiredStateMediaType+", "+DesiredStatePatchMediaType+", application/yaml;q=0.9, */*;q=0.8")
+	if s.etag != "" && s.content != nil {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return s.content, s.mediaType, nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("GET %s: %s", s.url, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := mediaTypeForFile(req.URL.Path)
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct == DesiredStatePatchMediaType || ct == "application/json-patch+json" {
+		mediaType = DesiredStatePatchMediaType
+	}
+	s.etag, s.content, s.mediaType = resp.Header.Get("ETag"), b, mediaType
+	return b, mediaType, nil
+}
+
+func (s *httpSource) String() string {
+	return s.url
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package source
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/registry"
+)
+
+type ociSource struct {
+	rc         *regclient.RegClient
+	platform   platform.Platform
+	verify     func(ctx context.Context, r ref.Ref) error
+	ref        string
+	constraint *semver.Constraints
+
+	currentTag string
+	// pinned holds the content of a digest-pinned reference, which is immutable and thus fetched only once
+	pinned *pinnedContent
+}
+
+type pinnedContent struct {
+	content   []byte
+	mediaType string
+}
+
+func newOCISource(spec string, opts Options) (*ociSource, error) {
+	if opts.RegClient == nil {
+		return nil, errors.New("OCI sources require a registry client")
+	}
+	repo, query, found := strings.Cut(spec, "?")
+	s := &ociSource{rc: opts.RegClient, platform: opts.Platform, verify: opts.VerifyManifest, ref: repo}
+	if !found {
+		return s, nil
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if c := values.Get("semver"); c != "" {
+		if s.constraint, err = semver.NewConstraint(c); err != nil {
+			return nil, fmt.Errorf("invalid semver constraint %q: %w", c, err)
+		}
+		r, err := ref.New(repo)
+		if err != nil {
+			return nil, err
+		}
+		if r.Tag != "latest" || strings.HasSuffix(repo, ":latest") || strings.Contains(repo, "@") {
+			return nil, fmt.Errorf("%s: semver tracking requires a reference without tag", repo)
+		}
+	}
+	return s, nil
+}
+
+func (s *ociSource) Fetch(ctx context.Context) ([]byte, string, error) {
+	if s.pinned != nil {
+		return s.pinned.content, s.pinned.mediaType, nil
+	}
+	if strings.Contains(s.ref, "@") {
+		b, mediaType, err := s.fetchDesiredState(ctx, s.ref)
+		if err != nil {
+			return nil, "", err
+		}
+		log.Printf("%s: desired state is pinned by digest, no longer polling", s.ref)
+		s.pinned = &pinnedContent{content: b, mediaType: mediaType}
+		return b, mediaType, nil
+	}
+	if s.constraint == nil {
+		return s.fetchDesiredState(ctx, s.ref)
+	}
+	tag, err := s.resolveTag(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.fetchDesiredState(ctx, s.ref+":"+tag)
+}
+
+// resolveTag returns the highest tag of the repository satisfying the semver constraint.
+func (s *ociSource) resolveTag(ctx context.Context) (string, error) {
+	r, err := ref.New(s.ref)
+	if err != nil {
+		return "", err
+	}
+	tags, err := s.rc.TagList(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	var best *semver.Version
+	var bestTag string
+	for _, tag := range tags.Tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil || !s.constraint.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best, bestTag = v, tag
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("%s: no tag matches %s", s.ref, s.constraint)
+	}
+	if bestTag != s.currentTag {
+		log.Printf("%s: following tag %s (constraint %s)", s.ref, bestTag, s.constraint)
+		s.currentTag = bestTag
+	}
+	return bestTag, nil
+}
+
+// fetchDesiredState returns the content of the desired-state layers of the given artifact. Multiple layers are
+// concatenated to a multi-document YAML stream. If the artifact holds a JSON patch instead, its content is returned
+// with the patch media type.
+func (s *ociSource) fetchDesiredState(ctx context.Context, deployRepo string) ([]byte, string, error) {
+	r, err := ref.New(deployRepo)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := s.rc.Ping(ctx, r); err != nil {
+		return nil, "", err
+	}
+
+	mf, err := s.rc.ManifestGet(ctx, r)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.verify != nil {
+		if err := s.verify(ctx, r.SetDigest(mf.GetDescriptor().Digest.String())); err != nil {
+			return nil, "", fmt.Errorf("%s: desired state verification failed: %w", deployRepo, err)
+		}
+	}
+	if mf.IsList() {
+		// multi-arch desired state: pick the entry for this device
+		desc, err := manifest.GetPlatformDesc(mf, &s.platform)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: no desired state for platform %s: %w", deployRepo, s.platform, err)
+		}
+		if mf, err = s.rc.ManifestGet(ctx, r.SetDigest(desc.Digest.String())); err != nil {
+			return nil, "", err
+		}
+	}
+	imager, ok := mf.(manifest.Imager)
+	if !ok {
+		return nil, "", fmt.Errorf("%s: unsupported manifest type %s", deployRepo, mf.GetDescriptor().MediaType)
+	}
+	layers, _ := imager.GetLayers()
+	// OCI 1.1 artifacts declare the desired state through the artifactType (or config media type) instead, in which
+	// case every layer carries desired-state content regardless of its own media type
+	artifactType := artifactTypeOf(mf)
+	var buf bytes.Buffer
+	for _, desc := range layers {
+		mediaType := desc.MediaType
+		if mediaType != DesiredStatePatchMediaType && (artifactType == DesiredStateMediaType || artifactType == DesiredStatePatchMediaType) {
+			mediaType = artifactType
+		}
+		if mediaType != DesiredStateMediaType && mediaType != DesiredStatePatchMediaType {
+			continue
+		}
+		b, err := registry.FetchBlob(ctx, s.rc, r, desc)
+		if err != nil {
+			return nil, "", err
+		}
+		if mediaType == DesiredStatePatchMediaType {
+			if buf.Len() > 0 {
+				return nil, "", errors.New("artifact mixes desired-state documents and patches")
+			}
+			return b, mediaType, nil
+		}
+		buf.WriteString("---\n")
+		buf.Write(b)
+		buf.WriteString("\n")
+	}
+	if buf.Len() == 0 {
+		return nil, "", errors.New("no app deployment found")
+	}
+	return buf.Bytes(), DesiredStateMediaType, nil
+}
+
+// artifactTypeOf returns the artifactType of the manifest, falling back to the config media type as recommended by the
+// OCI image spec for artifacts published before artifactType existed.
+func artifactTypeOf(mf manifest.Manifest) string {
+	switch orig := mf.GetOrig().(type) {
+	case v1.Manifest:
+		if orig.ArtifactType != "" {
+			return orig.ArtifactType
+		}
+		if orig.Config.MediaType != mediatype.OCI1ImageConfig && orig.Config.MediaType != mediatype.OCI1Empty {
+			return orig.Config.MediaType
+		}
+	case v1.ArtifactManifest:
+		return orig.ArtifactType
+	}
+	return ""
+}
+
+func (s *ociSource) String() string {
+	if s.constraint != nil {
+		return fmt.Sprintf("oci://%s?semver=%s", s.ref, s.constraint)
+	}
+	return "oci://" + s.ref
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package source retrieves the desired state from OCI registries, git repositories, files and HTTP endpoints.
+package source
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/deployment"
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/errdefs"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DesiredStateMediaType      = "application/vnd.margo.desired-state.v1+yaml"
+	DesiredStatePatchMediaType = "application/vnd.margo.desired-state.patch.v1+json"
+)
+
+// Source provides the raw desired state, i.e. one or more ApplicationDeployment YAML documents.
+type Source interface {
+	// Fetch returns the current content and its media type.
+	Fetch(ctx context.Context) ([]byte, string, error)
+	String() string
+}
+
+// Options are shared by all sources.
+type Options struct {
+	// RegClient is required for OCI sources.
+	RegClient *regclient.RegClient
+	// Platform selects the entry of multi-arch desired states.
+	Platform platform.Platform
+	// WorkDir holds git checkouts. Defaults to the user's cache directory.
+	WorkDir string
+	// HTTPClient is used by HTTP sources. Defaults to a client with a 30s timeout.
+	HTTPClient *http.Client
+	// VerifyManifest is optional. OCI sources call it with the digest-pinned reference of the desired-state manifest
+	// before reading any content, e.g. to check its signature.
+	VerifyManifest func(ctx context.Context, r ref.Ref) error
+}
+
+// New creates a source from its URL:
+//
+//	oci://ghcr.io/org/repo:tag or ghcr.io/org/repo:tag
+//	oci://ghcr.io/org/repo?semver=~1.2 (follows the highest tag matching the constraint)
+//	git+https://github.com/org/repo.git#ref:path/to/desired.yaml
+//	file:///etc/margo/desired.yaml
+//	https://orchestrator.example.com/desired.yaml
+func New(spec string, opts Options) (Source, error) {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	switch {
+	case strings.HasPrefix(spec, "git+"):
+		return newGitSource(strings.TrimPrefix(spec, "git+"), opts)
+	case strings.HasPrefix(spec, "file://"):
+		return &fileSource{path: strings.TrimPrefix(spec, "file://")}, nil
+	case strings.HasPrefix(spec, "https://"), strings.HasPrefix(spec, "http://"):
+		return &httpSource{url: spec, client: opts.HTTPClient}, nil
+	default:
+		return newOCISource(strings.TrimPrefix(spec, "oci://"), opts)
+	}
+}
+
+// Load fetches the desired state from the source and applies the given overlays in order. The desired state may
+// consist of several ApplicationDeployment documents, which are decoded and validated.
+func Load(ctx context.Context, src Source, overlays ...Source) ([]*deployment.ApplicationDeployment, error) {
+	b, _, err := src.Fetch(ctx)
+	if err != nil {
+		return nil, errdefs.FromRegistry(err)
+	}
+	docs, err := deployment.SplitDocuments(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid desired state from %s: %w", src, err)
+	}
+	if len(overlays) > 0 {
+		if docs, err = applyOverlays(ctx, docs, overlays); err != nil {
+			return nil, err
+		}
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("invalid desired state from %s: no documents found", src)
+	}
+
+	appDeployments := make([]*deployment.ApplicationDeployment, 0, len(docs))
+	owners := make(map[string]string) // namespace/component -> deployment
+	for i, doc := range docs {
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		appDeployment, err := deployment.Decode(b)
+		if err == nil {
+			err = appDeployment.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid desired state from %s (document %d):\n%w", src, i, err)
+		}
+		// components of different namespaces are deployed separately
+		namespace := cmp.Or(appDeployment.Metadata.Namespace, "default")
+		for _, c := range appDeployment.Spec.DeploymentProfile.Components {
+			if other, found := owners[namespace+"/"+c.Name]; found {
+				return nil, fmt.Errorf("invalid desired state from %s: component %q is defined by both %s and %s", src, c.Name, other, appDeployment.Metadata.Name)
+			}
+			owners[namespace+"/"+c.Name] = appDeployment.Metadata.Name
+		}
+		appDeployments = append(appDeployments, appDeployment)
+	}
+	return appDeployments, nil
+}
+
+// applyOverlays merges the overlay artifacts into the documents of the base desired state. Overlays are either
+// partial documents (strategic merge) or JSON patches.
+//
+// An overlay document is merged into the base document of the same kind and name; overlay documents without a name
+// apply to all base documents, and documents not matching any base document are added. A JSON patch applies to the
+// document itself if there is exactly one, otherwise to the list of documents (i.e. paths start with the index).
+func applyOverlays(ctx context.Context, docs []any, overlays []Source) ([]any, error) {
+	for _, overlay := range overlays {
+		b, mediaType, err := overlay.Fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %s: %w", overlay, err)
+		}
+		if mediaType == DesiredStatePatchMediaType {
+			var root any = docs
+			if len(docs) == 1 {
+				root = docs[0]
+			}
+			if root, err = deployment.ApplyJSONPatch(root, b); err != nil {
+				return nil, fmt.Errorf("overlay %s: %w", overlay, err)
+			}
+			if len(docs) == 1 {
+				docs = []any{root}
+			} else if docs, err = asDocumentList(root); err != nil {
+				return nil, fmt.Errorf("overlay %s: %w", overlay, err)
+			}
+			continue
+		}
+
+		patches, err := deployment.SplitDocuments(b)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %s: %w", overlay, err)
+		}
+		for _, patch := range patches {
+			kind, name := deployment.DocumentIdentity(patch)
+			matched := false
+			for i, doc := range docs {
+				docKind, docName := deployment.DocumentIdentity(doc)
+				if (name == "" || name == docName) && (kind == "" || kind == docKind) {
+					docs[i] = deployment.MergeOverlay(doc, deployment.DeepCopy(patch))
+					matched = true
+				}
+			}
+			if !matched {
+				docs = append(docs, deployment.StripDirectives(patch))
+			}
+		}
+	}
+	return docs, nil
+}
+
+func asDocumentList(root any) ([]any, error) {
+	docs, ok := root.([]any)
+	if !ok {
+		return nil, errors.New("patch must keep the list of documents")
+	}
+	return docs, nil
+}
+
+// mediaTypeForFile derives the media type from the file name: `*.patch.json` files are JSON patches.
+func mediaTypeForFile(name string) string {
+	if strings.HasSuffix(name, ".patch.json") {
+		return DesiredStatePatchMediaType
+	}
+	return DesiredStateMediaType
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// Cosign verifies the app with `cosign verify-blob`. The package must contain either a sigstore bundle
+// (<app>.sigstore.json) or a detached signature (<app>.cosign.sig).
+type Cosign struct {
+	// Key is the public key file. Keyless verification is used if empty.
+	Key string `yaml:"key"`
+	// CertificateIdentity and CertificateOIDCIssuer are required for keyless verification.
+	CertificateIdentity   string `yaml:"certificateIdentity"`
+	CertificateOIDCIssuer string `yaml:"certificateOIDCIssuer"`
+}
+
+func (c *Cosign) Name() string {
+	return "cosign"
+}
+
+func (c *Cosign) Verify(ctx context.Context, a Artifact) error {
+	log.Println("Verifying cosign signature of", a.File)
+
+	var args []string
+	switch {
+	case fsutil.FileExists(a.File + ".sigstore.json"):
+		args = append(args, "--bundle", a.File+".sigstore.json")
+	case fsutil.FileExists(a.File + ".cosign.sig"):
+		args = append(args, "--signature", a.File+".cosign.sig")
+	default:
+		return errors.New("package contains no cosign signature")
+	}
+	if c.Key != "" {
+		args = append(args, "--key", c.Key)
+	} else {
+		if c.CertificateIdentity == "" || c.CertificateOIDCIssuer == "" {
+			return errors.New("keyless verification requires certificateIdentity and certificateOIDCIssuer")
+		}
+		args = append(args, "--certificate-identity", c.CertificateIdentity, "--certificate-oidc-issuer", c.CertificateOIDCIssuer)
+	}
+
+	cmd := exec.CommandContext(ctx, "cosign", append(append([]string{"verify-blob"}, args...), a.File)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
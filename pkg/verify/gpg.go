@@ -0,0 +1,317 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package verify checks the authenticity of application packages.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// GPGSignature verifies the detached binary signature of signedFile against the armored public keyring.
+func GPGSignature(pubKey io.Reader, signedFile, signatureFile string) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(pubKey)
+	if err != nil {
+		return err
+	}
+	_, _, err = checkGPGSignature(keyring, signedFile, signatureFile)
+	return err
+}
+
+// checkGPGSignature returns the signature and the entity which created it.
+func checkGPGSignature(keyring openpgp.EntityList, signedFile, signatureFile string) (*packet.Signature, *openpgp.Entity, error) {
+	log.Println("Verifying signature of", signedFile)
+
+	signature, err := os.Open(signatureFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer signature.Close()
+
+	signed, err := os.Open(signedFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer signed.Close()
+
+	sig, signer, err := checkGPG(keyring, signed, signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Println("Signature verified succesfully")
+	return sig, signer, nil
+}
+
+func checkGPG(keyring openpgp.EntityList, signed, signature io.Reader) (*packet.Signature, *openpgp.Entity, error) {
+	sig, signer, err := openpgp.VerifyDetachedSignature(keyring, signed, signature, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signature verification failed: %v", err)
+	}
+	return sig, signer, nil
+}
+
+// GPGSign creates the detached binary signature of signedFile with the armored private key. Encrypted keys are
+// decrypted with the passphrase.
+func GPGSign(privKey io.Reader, passphrase []byte, signedFile, signatureFile string) error {
+	signed, err := os.Open(signedFile)
+	if err != nil {
+		return err
+	}
+	defer signed.Close()
+	signature, err := os.Create(signatureFile)
+	if err != nil {
+		return err
+	}
+	if err := GPGSignStream(privKey, passphrase, signed, signature); err != nil {
+		signature.Close()
+		return err
+	}
+	return signature.Close()
+}
+
+// GPGSignStream writes the detached binary signature of signed to signature.
+func GPGSignStream(privKey io.Reader, passphrase []byte, signed io.Reader, signature io.Writer) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(privKey)
+	if err != nil {
+		return err
+	}
+	var signer *openpgp.Entity
+	for _, e := range keyring {
+		if e.PrivateKey != nil {
+			signer = e
+			break
+		}
+	}
+	if signer == nil {
+		return errors.New("no private key found")
+	}
+	if signer.PrivateKey.Encrypted {
+		if err := signer.PrivateKey.Decrypt(passphrase); err != nil {
+			return fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+	}
+	return openpgp.DetachSign(signature, signer, signed, nil)
+}
+
+// GPG verifies the detached binary signature <app>.sig. By default the signature is checked against the public key
+// from the component's keyLocation. As the key then comes from the same registry as the package, operators should
+// either pin the fingerprints of acceptable keys or provide the keys locally, in which case keyLocation is ignored.
+// Packages signed by several parties carry further signatures as <app>.sig.<suffix>.
+type GPG struct {
+	// TrustedKeys is a directory with public keys (armored or binary). If set, keyLocation is ignored.
+	TrustedKeys string `yaml:"trustedKeys"`
+	// PinnedFingerprints restricts the keys taken from keyLocation to those with one of the fingerprints.
+	PinnedFingerprints []string `yaml:"pinnedFingerprints"`
+	// AllowedSigners lists the fingerprints of the only keys (primary or signing subkey) accepted as signers.
+	AllowedSigners []string `yaml:"allowedSigners"`
+	// RevokedKeys lists fingerprints of keys whose signatures are rejected.
+	RevokedKeys []string `yaml:"revokedKeys"`
+	// RevocationList is a file with further revoked fingerprints, one per line. It is read on every verification so
+	// keys can be revoked without restarting the watcher.
+	RevocationList string `yaml:"revocationList"`
+	// Threshold is the number of distinct keys which must have signed the package, defaults to 1. A signature by a
+	// revoked key rejects the package regardless.
+	Threshold int `yaml:"threshold"`
+}
+
+func (g *GPG) Name() string {
+	return "gpg"
+}
+
+func (g *GPG) Verify(_ context.Context, a Artifact) error {
+	keyring, err := g.keyring(a)
+	if err != nil {
+		return err
+	}
+	signatures, err := signatureFiles(a.File)
+	if err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return errors.New("package contains no GPG signature")
+	}
+
+	threshold := max(g.Threshold, 1)
+	signers := make(map[string]bool)
+	var errs []error
+	for _, signature := range signatures {
+		sig, signer, err := checkGPGSignature(keyring, a.File, signature)
+		if err == nil {
+			err = g.checkSigner(sig, signer)
+		}
+		if errors.Is(err, errRevoked) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(signature), err))
+			continue
+		}
+		signers[hex.EncodeToString(signer.PrimaryKey.Fingerprint)] = true
+	}
+	if len(signers) >= threshold {
+		return nil
+	}
+	if threshold == 1 && len(errs) == 1 {
+		return errors.Unwrap(errs[0])
+	}
+	err = fmt.Errorf("found valid signatures by %d of %d required keys", len(signers), threshold)
+	if len(errs) > 0 {
+		err = fmt.Errorf("%w: %w", err, errors.Join(errs...))
+	}
+	return err
+}
+
+// signatureFiles returns the detached GPG signatures of the file: <file>.sig and <file>.sig.<suffix>, which allows
+// several parties to sign a package.
+func signatureFiles(file string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(file)
+	var signatures []string
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && (name == base+".sig" || strings.HasPrefix(name, base+".sig.")) {
+			signatures = append(signatures, filepath.Join(filepath.Dir(file), name))
+		}
+	}
+	return signatures, nil
+}
+
+var errRevoked = errors.New("revoked")
+
+// checkSigner rejects valid signatures by keys which are revoked or not allowed.
+func (g *GPG) checkSigner(sig *packet.Signature, signer *openpgp.Entity) error {
+	fingerprints := [][]byte{signer.PrimaryKey.Fingerprint}
+	if sig.IssuerKeyId != nil {
+		for _, sub := range signer.Subkeys {
+			if sub.PublicKey.KeyId == *sig.IssuerKeyId {
+				fingerprints = append(fingerprints, sub.PublicKey.Fingerprint)
+			}
+		}
+	}
+	matches := func(list []string) bool {
+		for _, fp := range fingerprints {
+			if slices.ContainsFunc(list, func(c string) bool { return sameFingerprint(c, fp) }) {
+				return true
+			}
+		}
+		return false
+	}
+
+	revoked := slices.Clone(g.RevokedKeys)
+	if g.RevocationList != "" {
+		b, err := os.ReadFile(g.RevocationList)
+		if err != nil {
+			return fmt.Errorf("failed to read revocation list: %w", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line, _, _ = strings.Cut(line, "#"); strings.TrimSpace(line) != "" {
+				revoked = append(revoked, strings.TrimSpace(line))
+			}
+		}
+	}
+	primary := strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint))
+	if matches(revoked) {
+		return fmt.Errorf("signing key %s is %w", primary, errRevoked)
+	}
+	if len(g.AllowedSigners) > 0 && !matches(g.AllowedSigners) {
+		return fmt.Errorf("signing key %s is not allowed", primary)
+	}
+	return nil
+}
+
+// keyring returns the keys which may have signed the artifact.
+func (g *GPG) keyring(a Artifact) (openpgp.EntityList, error) {
+	if g.TrustedKeys != "" {
+		keyring, err := readKeyDir(g.TrustedKeys)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyring) == 0 {
+			return nil, fmt.Errorf("no trusted keys found in %s", g.TrustedKeys)
+		}
+		return keyring, nil
+	}
+
+	if len(a.Key) == 0 {
+		return nil, errors.New("no public key")
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(a.Key))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.PinnedFingerprints) == 0 {
+		return keyring, nil
+	}
+	var pinned openpgp.EntityList
+	for _, e := range keyring {
+		if slices.ContainsFunc(g.PinnedFingerprints, func(fp string) bool { return sameFingerprint(fp, e.PrimaryKey.Fingerprint) }) {
+			pinned = append(pinned, e)
+		}
+	}
+	if len(pinned) == 0 {
+		return nil, errors.New("key from keyLocation does not match any pinned fingerprint")
+	}
+	return pinned, nil
+}
+
+// readKeyDir reads all keys in dir. Hidden files are ignored.
+func readKeyDir(dir string) (openpgp.EntityList, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keyring openpgp.EntityList
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+		if err != nil {
+			if keys, err = openpgp.ReadKeyRing(bytes.NewReader(b)); err != nil {
+				return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+			}
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring, nil
+}
+
+// sameFingerprint compares a configured fingerprint, which may contain spaces and lowercase letters, with a key's.
+func sameFingerprint(configured string, fingerprint []byte) bool {
+	return strings.EqualFold(strings.ReplaceAll(configured, " ", ""), hex.EncodeToString(fingerprint))
+}
+
+// KeyFingerprints returns the fingerprints of the primary keys of an armored OpenPGP key, none if it is no such key.
+func KeyFingerprints(key []byte) []string {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		return nil
+	}
+	fingerprints := make([]string, len(keyring))
+	for i, e := range keyring {
+		fingerprints[i] = strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint))
+	}
+	return fingerprints
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package verify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/scheme"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/ref"
+)
+
+const (
+	// GPGSignatureArtifactType identifies referrers holding a detached GPG signature of their subject manifest.
+	GPGSignatureArtifactType = "application/vnd.margo.signature.v1+pgp"
+	// GPGSignatureMediaType is the media type of the signature layer of such referrers.
+	GPGSignatureMediaType = "application/pgp-signature"
+)
+
+// ManifestVerifier checks signatures attached to a manifest in the registry, such as the desired-state artifact.
+type ManifestVerifier interface {
+	// VerifyManifest verifies the manifest referenced by digest.
+	VerifyManifest(ctx context.Context, rc *regclient.RegClient, r ref.Ref) error
+}
+
+var (
+	_ ManifestVerifier = (*GPG)(nil)
+	_ ManifestVerifier = (*Cosign)(nil)
+	_ ManifestVerifier = (*Notation)(nil)
+	_ ManifestVerifier = None{}
+)
+
+// VerifyManifest looks for referrers with a GPG signature of the manifest made by one of the trusted keys.
+func (g *GPG) VerifyManifest(ctx context.Context, rc *regclient.RegClient, r ref.Ref) error {
+	if g.TrustedKeys == "" {
+		return errors.New("verifying manifests requires gpg.trustedKeys")
+	}
+	keyring, err := readKeyDir(g.TrustedKeys)
+	if err != nil {
+		return err
+	}
+	mf, err := rc.ManifestGet(ctx, r)
+	if err != nil {
+		return err
+	}
+	body, err := mf.RawBody()
+	if err != nil {
+		return err
+	}
+	referrers, err := rc.ReferrerList(ctx, r, scheme.WithReferrerMatchOpt(descriptor.MatchOpt{ArtifactType: GPGSignatureArtifactType}))
+	if err != nil {
+		return err
+	}
+	if len(referrers.Descriptors) == 0 {
+		return errors.New("no GPG signature found")
+	}
+
+	var errs []error
+	for _, desc := range referrers.Descriptors {
+		err := g.verifyReferrer(ctx, rc, r, desc, body, keyring)
+		if err == nil {
+			log.Printf("%s: signature verified", r.CommonName())
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", desc.Digest, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (g *GPG) verifyReferrer(ctx context.Context, rc *regclient.RegClient, r ref.Ref, desc descriptor.Descriptor, body []byte, keyring openpgp.EntityList) error {
+	sigManifest, err := rc.ManifestGet(ctx, r.SetDigest(desc.Digest.String()))
+	if err != nil {
+		return err
+	}
+	imager, ok := sigManifest.(manifest.Imager)
+	if !ok {
+		return fmt.Errorf("unsupported manifest type %s", desc.MediaType)
+	}
+	layers, err := imager.GetLayers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if layer.MediaType != GPGSignatureMediaType {
+			continue
+		}
+		reader, err := rc.BlobGet(ctx, r, layer)
+		if err != nil {
+			return err
+		}
+		sig, signer, err := checkGPG(keyring, bytes.NewReader(body), reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		return g.checkSigner(sig, signer)
+	}
+	return errors.New("no signature layer")
+}
+
+// VerifyManifest runs `cosign verify` on the manifest.
+func (c *Cosign) VerifyManifest(ctx context.Context, _ *regclient.RegClient, r ref.Ref) error {
+	args := []string{"verify"}
+	if c.Key != "" {
+		args = append(args, "--key", c.Key)
+	} else {
+		if c.CertificateIdentity == "" || c.CertificateOIDCIssuer == "" {
+			return errors.New("keyless verification requires certificateIdentity and certificateOIDCIssuer")
+		}
+		args = append(args, "--certificate-identity", c.CertificateIdentity, "--certificate-oidc-issuer", c.CertificateOIDCIssuer)
+	}
+	cmd := exec.CommandContext(ctx, "cosign", append(args, r.CommonName())...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// VerifyManifest runs `notation verify` on the manifest, using notation's trust policy for OCI artifacts.
+func (n *Notation) VerifyManifest(ctx context.Context, _ *regclient.RegClient, r ref.Ref) error {
+	cmd := exec.CommandContext(ctx, "notation", "verify", r.CommonName())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (None) VerifyManifest(context.Context, *regclient.RegClient, ref.Ref) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/internal/fsutil"
+)
+
+// Notation verifies the app with `notation blob verify` against notation's trust store and blob trust policy. The
+// package must contain the signature as <app>.jws.sig or <app>.cose.sig.
+type Notation struct {
+	// Policy selects the blob trust policy, the global policy is used if empty.
+	Policy string `yaml:"policy"`
+}
+
+func (n *Notation) Name() string {
+	return "notation"
+}
+
+func (n *Notation) Verify(ctx context.Context, a Artifact) error {
+	log.Println("Verifying notation signature of", a.File)
+
+	var signature string
+	for _, format := range []string{"jws", "cose"} {
+		if name := a.File + "." + format + ".sig"; fsutil.FileExists(name) {
+			signature = name
+			break
+		}
+	}
+	if signature == "" {
+		return errors.New("package contains no notation signature")
+	}
+	args := []string{"blob", "verify", "--signature", signature}
+	if n.Policy != "" {
+		args = append(args, "--policy-name", n.Policy)
+	}
+	cmd := exec.CommandContext(ctx, "notation", append(args, a.File)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
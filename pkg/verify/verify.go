@@ -0,0 +1,281 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/ref"
+	"gopkg.in/yaml.v3"
+)
+
+// Artifact is an unpacked application package awaiting verification.
+type Artifact struct {
+	Component string
+	// File is the application file. Detached signatures are expected next to it.
+	File string
+	// Key is the content of the component's keyLocation.
+	Key []byte
+}
+
+// Verifier checks the authenticity of an artifact.
+type Verifier interface {
+	Name() string
+	Verify(ctx context.Context, a Artifact) error
+}
+
+// Rule selects the verifiers applied to a component. All verifiers in Require must succeed, and at least Threshold
+// in AnyOf if it is not empty.
+type Rule struct {
+	// Component is a path.Match pattern of component names. It is ignored for the default rule.
+	Component string   `yaml:"component"`
+	Require   []string `yaml:"require"`
+	AnyOf     []string `yaml:"anyOf"`
+	// Threshold defaults to 1.
+	Threshold int `yaml:"threshold"`
+}
+
+// VerifierConfig configures a named verifier in addition to the built-in gpg, cosign, notation and none, e.g. to
+// check signatures against the keys of different parties.
+type VerifierConfig struct {
+	// Type is one of gpg, cosign or notation. The remaining fields are those of the respective verifier.
+	Type     string `yaml:"type"`
+	GPG      `yaml:",inline"`
+	Cosign   `yaml:",inline"`
+	Notation `yaml:",inline"`
+}
+
+// DesiredStateRule selects the verifiers applied to the desired-state artifact of OCI sources.
+type DesiredStateRule struct {
+	Rule `yaml:",inline"`
+	// Strict refuses desired states which fail verification, otherwise failures are only logged.
+	Strict bool `yaml:"strict"`
+}
+
+// Config is the declarative verification policy, a YAML document of the form:
+//
+//	gpg:
+//	  trustedKeys: /etc/oci-watcher/trusted-keys
+//	  revocationList: /etc/oci-watcher/revoked-keys
+//	cosign:
+//	  key: /etc/oci-watcher/cosign.pub
+//	default:
+//	  require: [gpg]
+//	components:
+//	  - component: sensor-*
+//	    require: [gpg, cosign]
+//	  - component: dev-*
+//	    require: [none]
+//	desiredState:
+//	  require: [cosign]
+//	  strict: true
+//
+// The first matching component rule wins, components matching none use the default rule. The desired state is only
+// verified if a desiredState rule is configured. For M-of-N policies, e.g. signatures by vendor and operator, define
+// named verifiers and a threshold:
+//
+//	verifiers:
+//	  vendor: {type: gpg, trustedKeys: /etc/oci-watcher/keys/vendor}
+//	  operator: {type: gpg, trustedKeys: /etc/oci-watcher/keys/operator}
+//	  auditor: {type: gpg, trustedKeys: /etc/oci-watcher/keys/auditor}
+//	default:
+//	  anyOf: [vendor, operator, auditor]
+//	  threshold: 2
+type Config struct {
+	Verifiers  map[string]VerifierConfig `yaml:"verifiers"`
+	GPG        GPG                       `yaml:"gpg"`
+	Cosign     Cosign                    `yaml:"cosign"`
+	Notation   Notation                  `yaml:"notation"`
+	Default    Rule                      `yaml:"default"`
+	Components []Rule                    `yaml:"components"`
+	// DesiredState applies to the desired-state manifest of OCI sources.
+	DesiredState DesiredStateRule `yaml:"desiredState"`
+}
+
+// Chain applies the verifiers required by the policy. It is a Verifier itself.
+type Chain struct {
+	verifiers    map[string]Verifier
+	defaults     Rule
+	components   []Rule
+	desiredState DesiredStateRule
+}
+
+var _ Verifier = (*Chain)(nil)
+
+// DefaultChain requires a valid GPG signature for every component.
+func DefaultChain() *Chain {
+	c, _ := NewChain(Config{Default: Rule{Require: []string{"gpg"}}})
+	return c
+}
+
+// LoadConfig reads the verification policy from a YAML file.
+func LoadConfig(path string) (*Chain, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return NewChain(cfg)
+}
+
+// NewChain validates the policy and sets up the referenced verifiers.
+func NewChain(cfg Config) (*Chain, error) {
+	gpg, cosign, notation := cfg.GPG, cfg.Cosign, cfg.Notation
+	c := &Chain{
+		verifiers: map[string]Verifier{
+			"gpg":      &gpg,
+			"cosign":   &cosign,
+			"notation": &notation,
+			"none":     None{},
+		},
+		defaults:     cfg.Default,
+		components:   cfg.Components,
+		desiredState: cfg.DesiredState,
+	}
+	if len(c.defaults.Require) == 0 && len(c.defaults.AnyOf) == 0 {
+		c.defaults.Require = []string{"gpg"}
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(cfg.Verifiers)) {
+		vc := cfg.Verifiers[name]
+		if _, found := c.verifiers[name]; found {
+			errs = append(errs, fmt.Errorf("verifiers.%s: name is reserved for the built-in verifier", name))
+			continue
+		}
+		switch vc.Type {
+		case "gpg":
+			c.verifiers[name] = &vc.GPG
+		case "cosign":
+			c.verifiers[name] = &vc.Cosign
+		case "notation":
+			c.verifiers[name] = &vc.Notation
+		default:
+			errs = append(errs, fmt.Errorf("verifiers.%s.type: unsupported type %q", name, vc.Type))
+		}
+	}
+	check := func(field string, r Rule) {
+		for _, name := range slices.Concat(r.Require, r.AnyOf) {
+			if _, found := c.verifiers[name]; !found {
+				errs = append(errs, fmt.Errorf("%s: unknown verifier %q", field, name))
+			}
+		}
+		if r.Threshold < 0 || r.Threshold > len(r.AnyOf) {
+			errs = append(errs, fmt.Errorf("%s.threshold: must be between 1 and the number of anyOf verifiers", field))
+		}
+	}
+	check("default", c.defaults)
+	check("desiredState", c.desiredState.Rule)
+	for i, r := range c.components {
+		field := fmt.Sprintf("components[%d]", i)
+		if _, err := path.Match(r.Component, ""); err != nil || r.Component == "" {
+			errs = append(errs, fmt.Errorf("%s.component: invalid pattern %q", field, r.Component))
+		}
+		if len(r.Require) == 0 && len(r.AnyOf) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no verifiers", field))
+		}
+		check(field, r)
+	}
+	return c, errors.Join(errs...)
+}
+
+func (c *Chain) Name() string {
+	return "chain"
+}
+
+// Rule returns the rule applying to the component.
+func (c *Chain) Rule(component string) Rule {
+	for _, r := range c.components {
+		if match, _ := path.Match(r.Component, component); match {
+			return r
+		}
+	}
+	return c.defaults
+}
+
+func (c *Chain) Verify(ctx context.Context, a Artifact) error {
+	return c.apply(c.Rule(a.Component), func(v Verifier) error {
+		return v.Verify(ctx, a)
+	})
+}
+
+// VerifyDesiredState checks the signature of the desired-state manifest referenced by digest. Failures are only
+// logged unless the policy is strict.
+func (c *Chain) VerifyDesiredState(ctx context.Context, rc *regclient.RegClient, r ref.Ref) error {
+	rule := c.desiredState
+	if len(rule.Require) == 0 && len(rule.AnyOf) == 0 {
+		return nil
+	}
+	err := c.apply(rule.Rule, func(v Verifier) error {
+		mv, ok := v.(ManifestVerifier)
+		if !ok {
+			return errors.New("cannot verify manifests")
+		}
+		return mv.VerifyManifest(ctx, rc, r)
+	})
+	if err != nil && !rule.Strict {
+		log.Printf("WARN: %s: desired state is not properly signed, continuing as strict mode is off: %s", r.CommonName(), err)
+		return nil
+	}
+	return err
+}
+
+// StrictDesiredState reports whether unverified desired states are refused.
+func (c *Chain) StrictDesiredState() bool {
+	return c.desiredState.Strict && (len(c.desiredState.Require) > 0 || len(c.desiredState.AnyOf) > 0)
+}
+
+// apply runs the verifiers selected by the rule.
+func (c *Chain) apply(rule Rule, verify func(Verifier) error) error {
+	for _, name := range rule.Require {
+		if err := verify(c.verifiers[name]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if len(rule.AnyOf) == 0 {
+		return nil
+	}
+	threshold := max(rule.Threshold, 1)
+	succeeded := 0
+	var errs []error
+	for _, name := range rule.AnyOf {
+		err := verify(c.verifiers[name])
+		if err == nil {
+			if succeeded++; succeeded >= threshold {
+				return nil
+			}
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	if threshold == 1 {
+		return fmt.Errorf("none of the verifiers succeeded: %w", errors.Join(errs...))
+	}
+	return fmt.Errorf("%d of %d required verifiers succeeded: %w", succeeded, threshold, errors.Join(errs...))
+}
+
+// None accepts every artifact. It is meant for development setups.
+type None struct{}
+
+func (None) Name() string {
+	return "none"
+}
+
+func (None) Verify(context.Context, Artifact) error {
+	return nil
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package workload
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// acrUser is the username presented along with ACR refresh tokens.
+const acrUser = "00000000-0000-0000-0000-000000000000"
+
+// acr authenticates to Entra ID with the OIDC token as federated credential and exchanges the access token for an ACR
+// refresh token, like az acr login does.
+func (r *Registry) acr(ctx context.Context, token string) (string, string, error) {
+	tenant := envDefault(r.TenantID, "AZURE_TENANT_ID")
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {envDefault(r.ClientID, "AZURE_CLIENT_ID")},
+		"scope":                 {"https://management.azure.com/.default"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {token},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://login.microsoftonline.com/"+url.PathEscape(tenant)+"/oauth2/v2.0/token",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var entra struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doJSON(req, &entra); err != nil {
+		return "", "", fmt.Errorf("entra id: %w", err)
+	}
+
+	form = url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {r.Registry},
+		"tenant":       {tenant},
+		"access_token": {entra.AccessToken},
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, "https://"+r.Registry+"/oauth2/exchange", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var exchanged struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := doJSON(req, &exchanged); err != nil {
+		return "", "", fmt.Errorf("acr token exchange: %w", err)
+	}
+	return acrUser, exchanged.RefreshToken, nil
+}
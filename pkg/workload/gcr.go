@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package workload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gcr exchanges the OIDC token for a federated access token with the Security Token Service and, if configured,
+// impersonates the service account with it. Artifact Registry and GCR accept access tokens as password of
+// oauth2accesstoken.
+func (r *Registry) gcr(ctx context.Context, token string) (string, string, error) {
+	form := url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"audience":             {r.Audience},
+		"scope":                {gcpScope},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token":        {token},
+		"subject_token_type":   {"urn:ietf:params:oauth:token-type:jwt"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://sts.googleapis.com/v1/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var federated struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doJSON(req, &federated); err != nil {
+		return "", "", fmt.Errorf("gcp sts: %w", err)
+	}
+	if r.ServiceAccount == "" {
+		return "oauth2accesstoken", federated.AccessToken, nil
+	}
+
+	body, _ := json.Marshal(map[string][]string{"scope": {gcpScope}})
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/"+url.PathEscape(r.ServiceAccount)+":generateAccessToken", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+federated.AccessToken)
+	var impersonated struct {
+		AccessToken string `json:"accessToken"`
+	}
+	if err := doJSON(req, &impersonated); err != nil {
+		return "", "", fmt.Errorf("gcp service account impersonation: %w", err)
+	}
+	return "oauth2accesstoken", impersonated.AccessToken, nil
+}
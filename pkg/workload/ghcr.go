@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package workload
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ghcr exchanges the OIDC token for a GitHub token with an octo-sts compatible service. GHCR ignores the username.
+func (r *Registry) ghcr(ctx context.Context, token string) (string, string, error) {
+	u, err := url.Parse(r.ExchangeURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid exchangeUrl: %w", err)
+	}
+	query := u.Query()
+	query.Set("scope", r.Scope)
+	query.Set("identity", r.Identity)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var exchanged struct {
+		Token string `json:"token"`
+	}
+	if err := doJSON(req, &exchanged); err != nil {
+		return "", "", fmt.Errorf("github token exchange: %w", err)
+	}
+	return "oci-watcher", exchanged.Token, nil
+}
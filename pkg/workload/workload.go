@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+// Package workload exchanges an OIDC token issued to the workload by its environment (e.g. a Kubernetes service
+// account token) for registry credentials, so devices in cloud-managed environments need no static tokens.
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Registry configures the credential exchange for a registry.
+type Registry struct {
+	Registry string `yaml:"registry"`
+	// Provider is one of ecr, gcr, acr or ghcr.
+	Provider string `yaml:"provider"`
+	// TokenFile is re-read for each exchange, as the environment rotates it. It defaults to the file announced by the
+	// environment, i.e. AWS_WEB_IDENTITY_TOKEN_FILE or AZURE_FEDERATED_TOKEN_FILE.
+	TokenFile string `yaml:"tokenFile"`
+	// TokenEnv is the environment variable holding the token, used instead of TokenFile.
+	TokenEnv string `yaml:"tokenEnv"`
+
+	// RoleARN is the AWS role assumed for ECR, defaults to AWS_ROLE_ARN.
+	RoleARN string `yaml:"roleArn"`
+	// Region defaults to the region of the ECR registry.
+	Region string `yaml:"region"`
+
+	// Audience is the workload identity provider of GCP, e.g.
+	// //iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/edge/providers/fleet.
+	Audience string `yaml:"audience"`
+	// ServiceAccount is impersonated if set, otherwise the federated token is used directly.
+	ServiceAccount string `yaml:"serviceAccount"`
+
+	// TenantID and ClientID identify the Azure app with the federated credential, defaulting to AZURE_TENANT_ID and
+	// AZURE_CLIENT_ID.
+	TenantID string `yaml:"tenantId"`
+	ClientID string `yaml:"clientId"`
+
+	// ExchangeURL is the endpoint of a GitHub token exchange service compatible with octo-sts, e.g.
+	// https://octo-sts.dev/sts/exchange, as GitHub does not accept OIDC tokens itself.
+	ExchangeURL string `yaml:"exchangeUrl"`
+	// Scope is the repository or organization the GitHub token is issued for, Identity the trust policy.
+	Scope    string `yaml:"scope"`
+	Identity string `yaml:"identity"`
+}
+
+// Config lists the registries authenticated with the workload identity.
+type Config struct {
+	Registries []*Registry `yaml:"registries"`
+}
+
+// Load reads the configuration from a YAML file of the form:
+//
+//	registries:
+//	  - registry: 123456789012.dkr.ecr.eu-central-1.amazonaws.com
+//	    provider: ecr
+//	    roleArn: arn:aws:iam::123456789012:role/edge-pull
+//	  - registry: europe-docker.pkg.dev
+//	    provider: gcr
+//	    audience: //iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/edge/providers/fleet
+//	    serviceAccount: puller@project.iam.gserviceaccount.com
+//	    tokenFile: /var/run/secrets/tokens/gcp
+//	  - registry: fleet.azurecr.io
+//	    provider: acr
+//	  - registry: ghcr.io
+//	    provider: ghcr
+//	    exchangeUrl: https://octo-sts.dev/sts/exchange
+//	    scope: org/deployments
+//	    identity: edge-pull
+//	    tokenEnv: OIDC_TOKEN
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	for i, r := range cfg.Registries {
+		if r.Registry == "" {
+			return nil, fmt.Errorf("registries[%d].registry: missing", i)
+		}
+		prefix := fmt.Sprintf("registries[%d]", i)
+		switch r.Provider {
+		case "ecr":
+			if r.RoleARN == "" && os.Getenv("AWS_ROLE_ARN") == "" {
+				return nil, fmt.Errorf("%s.roleArn: missing", prefix)
+			}
+			if r.Region == "" && ecrRegion(r.Registry) == "" {
+				return nil, fmt.Errorf("%s.region: missing", prefix)
+			}
+		case "gcr":
+			if r.Audience == "" {
+				return nil, fmt.Errorf("%s.audience: missing", prefix)
+			}
+		case "acr":
+			if r.TenantID == "" && os.Getenv("AZURE_TENANT_ID") == "" {
+				return nil, fmt.Errorf("%s.tenantId: missing", prefix)
+			}
+			if r.ClientID == "" && os.Getenv("AZURE_CLIENT_ID") == "" {
+				return nil, fmt.Errorf("%s.clientId: missing", prefix)
+			}
+		case "ghcr":
+			if r.ExchangeURL == "" || r.Scope == "" || r.Identity == "" {
+				return nil, fmt.Errorf("%s: exchangeUrl, scope and identity are required", prefix)
+			}
+		default:
+			return nil, fmt.Errorf("%s.provider: unknown provider %q", prefix, r.Provider)
+		}
+	}
+	return &cfg, nil
+}
+
+// Lookup returns the configuration of the registry, nil if it is not authenticated with the workload identity.
+func (c *Config) Lookup(registry string) *Registry {
+	for _, r := range c.Registries {
+		if r.Registry == registry {
+			return r
+		}
+	}
+	return nil
+}
+
+// Credentials exchanges the OIDC token for the username and password presented to the registry.
+func (r *Registry) Credentials(ctx context.Context) (string, string, error) {
+	token, err := r.oidcToken()
+	if err != nil {
+		return "", "", err
+	}
+	switch r.Provider {
+	case "ecr":
+		return r.ecr(ctx, token)
+	case "gcr":
+		return r.gcr(ctx, token)
+	case "acr":
+		return r.acr(ctx, token)
+	case "ghcr":
+		return r.ghcr(ctx, token)
+	}
+	return "", "", fmt.Errorf("unknown provider %q", r.Provider)
+}
+
+func (r *Registry) oidcToken() (string, error) {
+	if r.TokenEnv != "" {
+		if token := os.Getenv(r.TokenEnv); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("no OIDC token in %s", r.TokenEnv)
+	}
+	file := r.TokenFile
+	if file == "" {
+		switch r.Provider {
+		case "ecr":
+			file = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		case "acr":
+			file = os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+		}
+	}
+	if file == "" {
+		return "", errors.New("no OIDC token configured, set tokenFile or tokenEnv")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// doJSON sends the request and decodes the JSON response, failing on other status codes than 200.
+func doJSON(req *http.Request, v any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", req.Method, req.URL.Host, err)
+	}
+	return nil
+}
+
+func envDefault(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
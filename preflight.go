@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/backend"
+)
+
+// preflightTimeout bounds all preflight checks.
+const preflightTimeout = 2 * time.Minute
+
+// preflightCheck is the result of a check of the environment the watcher depends on.
+type preflightCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// preflight checks that the runtimes are reachable, the deploy directories are writable and the desired state can
+// be fetched with the configured credentials, and that the files of the watcher are protected from other users.
+func (w *watcher) preflight(ctx context.Context) []preflightCheck {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	var checks []preflightCheck
+	check := func(name string, err error) {
+		c := preflightCheck{Name: name, OK: err == nil}
+		if err != nil {
+			c.Detail = err.Error()
+		}
+		checks = append(checks, c)
+	}
+	for _, r := range w.reconciler.Reconcilers {
+		host := "local"
+		if r.Host != "" {
+			host = "host " + r.Host
+		}
+		check("deploy directory of "+host, checkWritable(r.DeployDir))
+		if c, ok := r.Backend.(backend.Checker); ok {
+			check("runtime of "+host, c.Check(ctx))
+		}
+	}
+	_, err := w.reconciler.Reconcilers[0].Load(ctx)
+	check("desired state", err)
+	check("file permissions", errors.Join(w.insecure...))
+	return checks
+}
+
+// checkWritable creates the directory unless it exists and checks that files can be created in it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".preflight-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// logPreflight logs the results of the checks and reports whether all passed.
+func logPreflight(checks []preflightCheck) bool {
+	ready := true
+	for _, c := range checks {
+		if c.OK {
+			log.Printf("Preflight: %s: ok", c.Name)
+		} else {
+			log.Printf("WARN: Preflight: %s: %s", c.Name, c.Detail)
+			ready = false
+		}
+	}
+	return ready
+}
+
+// runPreflight runs the checks of the watcher's environment and prints a summary.
+func runPreflight(fs *flag.FlagSet, args []string) error {
+	var wf watcherFlags
+	wf.register(fs)
+	jsonOutput := fs.Bool("json", false, "Print the results as JSON")
+	_ = fs.Parse(args)
+
+	w, err := wf.newWatcher()
+	if err != nil {
+		return err
+	}
+	checks := w.preflight(context.Background())
+	ready := true
+	for _, c := range checks {
+		ready = ready && c.OK
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Ready  bool             `json:"ready"`
+			Checks []preflightCheck `json:"checks"`
+		}{ready, checks}); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tRESULT")
+		for _, c := range checks {
+			result := "ok"
+			if !c.OK {
+				result = "FAILED: " + c.Detail
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", c.Name, result)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if !ready {
+		return fmt.Errorf("preflight checks failed")
+	}
+	return nil
+}
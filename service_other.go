@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyShutdown relays the signals asking the watcher to exit to c.
+func notifyShutdown(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+}
+
+// runService runs the command. Services are managed by systemd on other platforms than Windows, see the systemd
+// package.
+func runService(run func() error) error {
+	return run()
+}
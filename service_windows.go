@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceName is the name of the Windows service of the watcher, which is created e.g. with
+//
+//	sc.exe create oci-watcher start= auto binPath= "C:\Program Files\oci-watcher\oci-watcher.exe watch -deployDir C:\ProgramData\oci-watcher\deploy"
+const serviceName = "oci-watcher"
+
+// shutdown holds the channels notified when the service is stopped.
+var shutdown struct {
+	sync.Mutex
+	chans []chan<- os.Signal
+}
+
+// notifyShutdown relays Ctrl+C, closing the console, shutting down and stopping the service to c. Go reports all but
+// the first as SIGTERM.
+func notifyShutdown(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	shutdown.Lock()
+	defer shutdown.Unlock()
+	shutdown.chans = append(shutdown.chans, c)
+}
+
+// runService runs the command, reporting its state to the service control manager if the watcher was started as
+// Windows service.
+func runService(run func() error) error {
+	if isService, err := svc.IsWindowsService(); err != nil || !isService {
+		return run()
+	}
+	h := &serviceHandler{run: run}
+	if err := svc.Run(serviceName, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// serviceHandler runs the command as Windows service until it exits or the service is stopped.
+type serviceHandler struct {
+	run func() error
+	err error
+}
+
+func (h *serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() {
+		done <- h.run()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				shutdown.Lock()
+				for _, c := range shutdown.chans {
+					select {
+					case c <- syscall.SIGTERM:
+					default:
+					}
+				}
+				shutdown.Unlock()
+			}
+		}
+	}
+}
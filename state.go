@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/reconcile"
+)
+
+// stateFlags select the state of the watcher which is exported or imported.
+type stateFlags struct {
+	deployDir *string
+	cacheDir  *string
+	hosts     *string
+	force     *bool
+}
+
+func (f *stateFlags) register(fs *flag.FlagSet) {
+	f.deployDir = fs.String("deployDir", "./deploy", "Directory to deploy")
+	f.cacheDir = fs.String("cacheDir", "", "Directory for caching downloaded blobs and manifests (not included if empty)")
+	f.hosts = fs.String("hosts", "", "YAML file with further hosts managed by the watcher, whose deployments are included as well")
+	f.force = fs.Bool("force", false, "Proceed even if a watcher holds the lock of -deployDir, e.g. if the lock is stale")
+}
+
+// fleet returns the hosts of the watcher and locks the deploy directory, see lockDeployDir.
+func (f *stateFlags) fleet() (*reconcile.Fleet, func(), error) {
+	var hosts []reconcile.HostConfig
+	if *f.hosts != "" {
+		var err error
+		if hosts, err = reconcile.LoadHosts(*f.hosts); err != nil {
+			return nil, nil, fmt.Errorf("invalid -hosts: %w", err)
+		}
+	}
+	unlock, err := lockDeployDir(*f.deployDir, *f.force)
+	if err != nil {
+		return nil, nil, err
+	}
+	local := &reconcile.Reconciler{DeployDir: *f.deployDir, Version: watcherVersion()}
+	return reconcile.NewFleet(local, hosts), unlock, nil
+}
+
+// runExportState writes the deployments and the blob cache to an archive, see reconcile.Fleet.ExportState.
+func runExportState(fs *flag.FlagSet, args []string) error {
+	var sf stateFlags
+	sf.register(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one archive, see 'oci-watcher export-state -h'")
+	}
+	fleet, unlock, err := sf.fleet()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var w io.Writer = os.Stdout
+	if archive := fs.Arg(0); archive != "-" {
+		f, err := os.OpenFile(archive, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := fleet.ExportState(w, *sf.cacheDir); err != nil {
+		return fmt.Errorf("failed to export state: %w", err)
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// runImportState seeds the deploy directories and the blob cache from an archive, see reconcile.Fleet.ImportState.
+func runImportState(fs *flag.FlagSet, args []string) error {
+	var sf stateFlags
+	sf.register(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one archive, see 'oci-watcher import-state -h'")
+	}
+	fleet, unlock, err := sf.fleet()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var r io.Reader = os.Stdin
+	if archive := fs.Arg(0); archive != "-" {
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	dirs, err := fleet.ImportState(r, *sf.cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to import state: %w", err)
+	}
+	for _, dir := range dirs {
+		fmt.Println("Imported", dir)
+	}
+	return nil
+}
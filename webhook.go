@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2025 Margo
+//
+// SPDX-License-Identifier: MIT
+//
+// SPDX-FileContributor: Michael Adler <michael.adler@siemens.com>
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/silvanoc/margo-gitops-poc/oci-watcher/pkg/reconcile"
+)
+
+// reconcileTrigger requests an immediate reconcile. It is buffered so that triggers arriving during a reconcile are
+// coalesced into a single follow-up run.
+var reconcileTrigger = make(chan struct{}, 1)
+
+func triggerReconcile() {
+	select {
+	case reconcileTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// webhookHandler accepts registry push notifications and triggers a reconcile. Supported payloads are the CNCF
+// distribution notification envelope, Harbor webhooks, and arbitrary bodies from generic senders (e.g. a relay for
+// GitHub package events), which always trigger.
+type webhookHandler struct {
+	secret string
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secret != "" && !authorized(r, h.secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if repo, push := parseWebhook(body); push {
+		if repo != "" {
+			log.Println("Webhook: push to", repo)
+		} else {
+			log.Println("Webhook: push notification received")
+		}
+		triggerReconcile()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authorized accepts the secret as bearer token, as raw Authorization header (Harbor's "auth header" setting) or in
+// the X-Webhook-Secret header.
+func authorized(r *http.Request, secret string) bool {
+	candidates := []string{
+		strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		r.Header.Get("X-Webhook-Secret"),
+	}
+	for _, c := range candidates {
+		if c != "" && subtle.ConstantTimeCompare([]byte(c), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWebhook reports whether the payload announces a push, and the affected repository if known.
+func parseWebhook(body []byte) (string, bool) {
+	var payload struct {
+		// CNCF distribution
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+			} `json:"target"`
+		} `json:"events"`
+		// Harbor
+		Type      string `json:"type"`
+		EventData struct {
+			Repository struct {
+				RepoFullName string `json:"repo_full_name"`
+			} `json:"repository"`
+		} `json:"event_data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		// not JSON, assume a generic sender
+		return "", true
+	}
+	switch {
+	case len(payload.Events) > 0:
+		for _, e := range payload.Events {
+			if e.Action == "push" {
+				return e.Target.Repository, true
+			}
+		}
+		return "", false
+	case payload.Type != "":
+		return payload.EventData.Repository.RepoFullName, payload.Type == "PUSH_ARTIFACT"
+	default:
+		return "", true
+	}
+}
+
+// reconcileHandler triggers a reconcile on POST, e.g. from `oci-watcher reconcile`.
+type reconcileHandler struct {
+	secret string
+}
+
+func (h *reconcileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secret != "" && !authorized(r, h.secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	log.Println("Reconcile triggered via HTTP API")
+	triggerReconcile()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// redeployHandler marks the component given by the query parameters component and host (empty for the local host)
+// for redeployment on POST and triggers a reconcile, e.g. from `oci-watcher redeploy`. It is only served with a secret.
+type redeployHandler struct {
+	secret string
+	fleet  *reconcile.Fleet
+}
+
+func (h *redeployHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorized(r, h.secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	component := r.URL.Query().Get("component")
+	if err := h.fleet.Redeploy(r.URL.Query().Get("host"), component); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, reconcile.ErrNotDeployed) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("%s: redeploy triggered via HTTP API", component)
+	triggerReconcile()
+	w.WriteHeader(http.StatusAccepted)
+}